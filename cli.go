@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kyontan/cloud-pubsub-emulator-lite/emulator"
+)
+
+// defaultEmulatorHost is where the client commands look for the emulator if
+// PUBSUB_EMULATOR_HOST is not set
+const defaultEmulatorHost = "localhost:8085"
+
+// commands are the subcommands of the binary. Without one, it runs the
+// emulator.
+var commands = map[string]func(args []string) int{
+	"replay":   runReplay,
+	"topics":   runTopics,
+	"subs":     runSubs,
+	"publish":  runPublish,
+	"pull":     runPull,
+	"tail":     runTail,
+	"top":      runTop,
+	"env-init": runEnvInit,
+}
+
+// client calls the REST API of a running emulator
+type client struct {
+	baseURL string
+	project string
+	http    *http.Client
+}
+
+// newClient creates a client for the emulator at PUBSUB_EMULATOR_HOST
+func newClient(project string) *client {
+	host := os.Getenv("PUBSUB_EMULATOR_HOST")
+	if host == "" {
+		host = defaultEmulatorHost
+	}
+	return &client{baseURL: "http://" + host, project: project, http: http.DefaultClient}
+}
+
+// resourceName expands a topic or subscription ID to a full name in the
+// client's project; full names are kept as they are
+func (c *client) resourceName(kind, id string) (string, error) {
+	if strings.HasPrefix(id, "projects/") {
+		return id, nil
+	}
+	if c.project == "" {
+		return "", fmt.Errorf("%s %q is not a full name and no project is set (-project or PUBSUB_PROJECT_ID)", strings.TrimSuffix(kind, "s"), id)
+	}
+	return fmt.Sprintf("projects/%s/%s/%s", c.project, kind, id), nil
+}
+
+// do calls the API and decodes the response into resp, if not nil
+func (c *client) do(ctx context.Context, method, path string, req, resp any) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	httpResp, err := c.http.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(httpResp.Body)
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("%s: %s", httpResp.Status, apiErr.Error)
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// newCommandFlags creates the flag set of a client command with the -project
+// flag every command has
+func newCommandFlags(name, usage string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: pubsub-emulator %s\n", usage)
+		flags.PrintDefaults()
+	}
+	project := flags.String("project", os.Getenv("PUBSUB_PROJECT_ID"), "project of topic and subscription IDs (default $PUBSUB_PROJECT_ID)")
+	return flags, project
+}
+
+// fail prints an error of a client command and returns its exit code
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	return 1
+}
+
+// runTopics implements topics create|list|delete
+func runTopics(args []string) int {
+	const usage = "topics create|list|delete [flags] [topic...]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: pubsub-emulator "+usage)
+		return 2
+	}
+	action := args[0]
+	flags, project := newCommandFlags("topics "+action, usage)
+	flags.Parse(args[1:])
+	c := newClient(*project)
+	ctx := context.Background()
+
+	switch action {
+	case "list":
+		if c.project == "" {
+			return fail(errors.New("no project is set (-project or PUBSUB_PROJECT_ID)"))
+		}
+		var resp emulator.ListTopicsResponse
+		if err := c.do(ctx, http.MethodGet, "/v1/projects/"+c.project+"/topics", nil, &resp); err != nil {
+			return fail(err)
+		}
+		for _, topic := range resp.Topics {
+			fmt.Println(topic.Name)
+		}
+	case "create", "delete":
+		if flags.NArg() == 0 {
+			flags.Usage()
+			return 2
+		}
+		method := map[string]string{"create": http.MethodPut, "delete": http.MethodDelete}[action]
+		for _, id := range flags.Args() {
+			name, err := c.resourceName("topics", id)
+			if err == nil {
+				err = c.do(ctx, method, "/v1/"+name, nil, nil)
+			}
+			if err != nil {
+				return fail(err)
+			}
+			fmt.Println(name)
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+	return 0
+}
+
+// runSubs implements subs create|list|delete
+func runSubs(args []string) int {
+	const usage = "subs create [flags] subscription topic | subs list [flags] | subs delete [flags] subscription..."
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: pubsub-emulator "+usage)
+		return 2
+	}
+	action := args[0]
+	flags, project := newCommandFlags("subs "+action, usage)
+	flags.Parse(args[1:])
+	c := newClient(*project)
+	ctx := context.Background()
+
+	switch action {
+	case "list":
+		if c.project == "" {
+			return fail(errors.New("no project is set (-project or PUBSUB_PROJECT_ID)"))
+		}
+		var resp emulator.ListSubscriptionsResponse
+		if err := c.do(ctx, http.MethodGet, "/v1/projects/"+c.project+"/subscriptions", nil, &resp); err != nil {
+			return fail(err)
+		}
+		for _, sub := range resp.Subscriptions {
+			fmt.Printf("%s\t%s\n", sub.Name, sub.Topic)
+		}
+	case "create":
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return 2
+		}
+		name, err := c.resourceName("subscriptions", flags.Arg(0))
+		if err != nil {
+			return fail(err)
+		}
+		topic, err := c.resourceName("topics", flags.Arg(1))
+		if err != nil {
+			return fail(err)
+		}
+		if err := c.do(ctx, http.MethodPut, "/v1/"+name, map[string]string{"topic": topic}, nil); err != nil {
+			return fail(err)
+		}
+		fmt.Println(name)
+	case "delete":
+		if flags.NArg() == 0 {
+			flags.Usage()
+			return 2
+		}
+		for _, id := range flags.Args() {
+			name, err := c.resourceName("subscriptions", id)
+			if err == nil {
+				err = c.do(ctx, http.MethodDelete, "/v1/"+name, nil, nil)
+			}
+			if err != nil {
+				return fail(err)
+			}
+			fmt.Println(name)
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+	return 0
+}
+
+// attributesFlag collects repeated -attr key=value flags
+type attributesFlag map[string]string
+
+func (a attributesFlag) String() string {
+	return fmt.Sprint(map[string]string(a))
+}
+
+func (a attributesFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("attribute %q is not key=value", value)
+	}
+	a[key] = val
+	return nil
+}
+
+// runPublish implements publish. The message is the argument after the
+// topic, the -file or standard input, and is base64 encoded for the API.
+func runPublish(args []string) int {
+	flags, project := newCommandFlags("publish", "publish [flags] topic [message]")
+	attributes := attributesFlag{}
+	flags.Var(attributes, "attr", "message attribute as key=value (repeatable)")
+	file := flags.String("file", "", "publish the contents of this file")
+	lines := flags.Bool("lines", false, "publish each line of the input as a separate message")
+	flags.Parse(args)
+	if flags.NArg() < 1 || flags.NArg() > 2 || (flags.NArg() == 2 && *file != "") {
+		flags.Usage()
+		return 2
+	}
+
+	c := newClient(*project)
+	topic, err := c.resourceName("topics", flags.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+
+	var data []byte
+	switch {
+	case flags.NArg() == 2:
+		data = []byte(flags.Arg(1))
+	case *file != "":
+		data, err = os.ReadFile(*file)
+	default:
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	payloads := [][]byte{data}
+	if *lines {
+		payloads = nil
+		for line := range bytes.Lines(data) {
+			if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+				payloads = append(payloads, line)
+			}
+		}
+	}
+	req := emulator.PublishRequest{}
+	for _, payload := range payloads {
+		req.Messages = append(req.Messages, emulator.PubSubMessage{Data: emulator.EncodeData(payload), Attributes: attributes})
+	}
+
+	var resp emulator.PublishResponse
+	if err := c.do(context.Background(), http.MethodPost, "/v1/"+topic+":publish", req, &resp); err != nil {
+		return fail(err)
+	}
+	for _, id := range resp.MessageIDs {
+		fmt.Println(id)
+	}
+	return 0
+}
+
+// runPull implements pull
+func runPull(args []string) int {
+	flags, project := newCommandFlags("pull", "pull [flags] subscription")
+	maxMessages := flags.Int("max", 1, "maximum number of messages to pull")
+	ack := flags.Bool("ack", false, "acknowledge the pulled messages")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	c := newClient(*project)
+	sub, err := c.resourceName("subscriptions", flags.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := pullAndPrint(context.Background(), c, os.Stdout, sub, *maxMessages, *ack); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// runTail implements tail, which prints the messages arriving in a
+// subscription until interrupted. By default it watches the backlog without
+// leasing anything; with -ack it pulls and acknowledges the messages instead.
+func runTail(args []string) int {
+	flags, project := newCommandFlags("tail", "tail [flags] subscription")
+	interval := flags.Duration("interval", 500*time.Millisecond, "how long to wait before looking again when there are no new messages")
+	ack := flags.Bool("ack", false, "pull and acknowledge the messages, taking them away from other subscribers")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	c := newClient(*project)
+	sub, err := c.resourceName("subscriptions", flags.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var seen map[string]bool
+	for {
+		var n int
+		if *ack {
+			n, err = pullAndPrint(ctx, c, os.Stdout, sub, 100, true)
+		} else {
+			n, seen, err = peekAndPrint(ctx, c, os.Stdout, sub, seen)
+		}
+		if ctx.Err() != nil {
+			return 0
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if n > 0 && *ack {
+			continue
+		}
+		select {
+		case <-time.After(*interval):
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+// pullAndPrint pulls messages, prints them to w and acknowledges them if ack
+// is set. It returns the number of messages pulled.
+func pullAndPrint(ctx context.Context, c *client, w io.Writer, sub string, maxMessages int, ack bool) (int, error) {
+	var resp emulator.PullResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/"+sub+":pull", emulator.PullRequest{MaxMessages: maxMessages}, &resp); err != nil {
+		return 0, err
+	}
+
+	out := bufio.NewWriter(w)
+	ackIDs := make([]string, 0, len(resp.ReceivedMessages))
+	for _, received := range resp.ReceivedMessages {
+		printMessage(out, received.Message)
+		ackIDs = append(ackIDs, received.AckID)
+	}
+	if err := out.Flush(); err != nil {
+		return 0, err
+	}
+
+	if ack && len(ackIDs) > 0 {
+		if err := c.do(ctx, http.MethodPost, "/v1/"+sub+":acknowledge", emulator.AcknowledgeRequest{AckIDs: ackIDs}, nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(resp.ReceivedMessages), nil
+}
+
+// peekAndPrint prints the messages in the backlog of sub that are not in
+// seen, without leasing them. It returns the number of messages printed and
+// the IDs of all messages in the backlog, to pass as seen next time.
+func peekAndPrint(ctx context.Context, c *client, w io.Writer, sub string, seen map[string]bool) (int, map[string]bool, error) {
+	out := bufio.NewWriter(w)
+	backlog := make(map[string]bool)
+	printed := 0
+	token := ""
+	for {
+		path := "/admin/v1/" + sub + "/messages?pageSize=1000"
+		if token != "" {
+			path += "&pageToken=" + url.QueryEscape(token)
+		}
+		var resp emulator.PeekMessagesResponse
+		if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+			return 0, nil, err
+		}
+		for _, msg := range resp.Messages {
+			backlog[msg.MessageID] = true
+			if seen[msg.MessageID] {
+				continue
+			}
+			printMessage(out, emulator.Message{
+				Data:        msg.Data,
+				Attributes:  msg.Attributes,
+				MessageID:   msg.MessageID,
+				PublishTime: msg.PublishTime,
+			})
+			printed++
+		}
+		if resp.NextPageToken == "" {
+			return printed, backlog, out.Flush()
+		}
+		token = resp.NextPageToken
+	}
+}
+
+// printMessage writes a message as one tab-separated line: publish time,
+// message ID, attributes and decoded data
+func printMessage(w io.Writer, msg emulator.Message) {
+	attributes := make([]string, 0, len(msg.Attributes))
+	for _, key := range slices.Sorted(maps.Keys(msg.Attributes)) {
+		attributes = append(attributes, key+"="+msg.Attributes[key])
+	}
+	data, err := emulator.DecodeData(msg.Data)
+	if err != nil {
+		data = []byte(msg.Data)
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", msg.PublishTime, msg.MessageID, strings.Join(attributes, ","), data)
+}
+
+// runEnvInit prints the environment variables that point the client
+// libraries at the emulator, for use with eval
+func runEnvInit(args []string) int {
+	flags, project := newCommandFlags("env-init", "env-init [flags]")
+	defaultHost := os.Getenv("PUBSUB_EMULATOR_HOST")
+	if defaultHost == "" {
+		defaultHost = defaultEmulatorHost
+	}
+	host := flags.String("host", defaultHost, "host:port of the emulator")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	fmt.Printf("export PUBSUB_EMULATOR_HOST=%s\n", *host)
+	if *project != "" {
+		fmt.Printf("export PUBSUB_PROJECT_ID=%s\n", *project)
+	}
+	return 0
+}
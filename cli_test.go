@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kyontan/cloud-pubsub-emulator-lite/emulator"
+)
+
+// startEmulator starts an emulator for the client commands to talk to
+func startEmulator(t *testing.T) *emulator.Emulator {
+	t.Helper()
+	emu, err := emulator.Start(context.Background(), emulator.Options{IDSeed: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { emu.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", emu.Addr())
+	t.Setenv("PUBSUB_PROJECT_ID", "test")
+	return emu
+}
+
+// runCommand runs a subcommand and returns its exit code and output
+func runCommand(t *testing.T, stdin string, args ...string) (int, string) {
+	t.Helper()
+	stdout, stdinFile := os.Stdout, os.Stdin
+	defer func() { os.Stdout, os.Stdin = stdout, stdinFile }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	os.Stdout = w
+	if stdin != "" {
+		in, err := os.CreateTemp(t.TempDir(), "stdin")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		in.WriteString(stdin)
+		in.Seek(0, io.SeekStart)
+		os.Stdin = in
+	}
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	code := commands[args[0]](args[1:])
+	w.Close()
+	return code, <-output
+}
+
+func TestCLI_TopicsAndSubs(t *testing.T) {
+	startEmulator(t)
+
+	if code, out := runCommand(t, "", "topics", "create", "orders", "projects/other/topics/audit"); code != 0 || out != "projects/test/topics/orders\nprojects/other/topics/audit\n" {
+		t.Errorf("Expected the created topics, got %d %q", code, out)
+	}
+	if code, out := runCommand(t, "", "topics", "list"); code != 0 || out != "projects/test/topics/orders\n" {
+		t.Errorf("Expected the topics of the project, got %d %q", code, out)
+	}
+	if code, _ := runCommand(t, "", "topics", "create", "orders"); code != 1 {
+		t.Errorf("Expected exit code 1 for an existing topic, got %d", code)
+	}
+
+	if code, _ := runCommand(t, "", "subs", "create", "billing", "orders"); code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+	if code, out := runCommand(t, "", "subs", "list"); code != 0 || out != "projects/test/subscriptions/billing\tprojects/test/topics/orders\n" {
+		t.Errorf("Expected the subscription, got %d %q", code, out)
+	}
+	if code, _ := runCommand(t, "", "subs", "delete", "billing"); code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+	if code, out := runCommand(t, "", "subs", "list"); code != 0 || out != "" {
+		t.Errorf("Expected no subscriptions, got %d %q", code, out)
+	}
+
+	t.Setenv("PUBSUB_PROJECT_ID", "")
+	if code, _ := runCommand(t, "", "topics", "delete", "orders"); code != 1 {
+		t.Errorf("Expected exit code 1 without a project, got %d", code)
+	}
+	if code, _ := runCommand(t, "", "topics", "delete", "-project", "test", "orders"); code != 0 {
+		t.Errorf("Expected exit code 0 with -project, got %d", code)
+	}
+}
+
+func TestCLI_PublishAndPull(t *testing.T) {
+	emu := startEmulator(t)
+	emu.Storage().CreateTopic("projects/test/topics/orders")
+	emu.Storage().CreateSubscription("projects/test/subscriptions/billing", "projects/test/topics/orders")
+
+	if code, out := runCommand(t, "", "publish", "-attr", "kind=tea", "-attr", "size=large", "orders", "hello"); code != 0 || out != "1\n" {
+		t.Errorf("Expected the message ID, got %d %q", code, out)
+	}
+	if code, out := runCommand(t, "first\nsecond\n", "publish", "-lines", "orders"); code != 0 || out != "2\n3\n" {
+		t.Errorf("Expected a message per line, got %d %q", code, out)
+	}
+
+	code, out := runCommand(t, "", "pull", "-max", "10", "--ack", "billing")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 messages, got %q", out)
+	}
+	if fields := strings.Split(lines[0], "\t"); len(fields) != 4 || fields[1] != "1" || fields[2] != "kind=tea,size=large" || fields[3] != "hello" {
+		t.Errorf("Expected the decoded message, got %q", lines[0])
+	}
+
+	if stats, _ := emu.Storage().BacklogStats("projects/test/subscriptions/billing"); stats.Backlog != 0 {
+		t.Errorf("Expected the messages to be acked, got a backlog of %d", stats.Backlog)
+	}
+}
+
+func TestCLI_EnvInit(t *testing.T) {
+	t.Setenv("PUBSUB_EMULATOR_HOST", "")
+	t.Setenv("PUBSUB_PROJECT_ID", "")
+
+	if code, out := runCommand(t, "", "env-init"); code != 0 || out != "export PUBSUB_EMULATOR_HOST=localhost:8085\n" {
+		t.Errorf("Expected the default host, got %d %q", code, out)
+	}
+	if _, out := runCommand(t, "", "env-init", "-host", "localhost:9090", "-project", "test"); out != "export PUBSUB_EMULATOR_HOST=localhost:9090\nexport PUBSUB_PROJECT_ID=test\n" {
+		t.Errorf("Expected the given host and project, got %q", out)
+	}
+}
+
+func TestCLI_TailWithoutAck(t *testing.T) {
+	emu := startEmulator(t)
+	emu.Storage().CreateTopic("projects/test/topics/orders")
+	emu.Storage().CreateSubscription("projects/test/subscriptions/billing", "projects/test/topics/orders")
+	emu.Storage().Publish("projects/test/topics/orders", []emulator.PubSubMessage{{Data: emulator.EncodeData([]byte("first"))}})
+
+	c := newClient("test")
+	var out strings.Builder
+	n, seen, err := peekAndPrint(context.Background(), c, &out, "projects/test/subscriptions/billing", nil)
+	if err != nil || n != 1 || !strings.HasSuffix(out.String(), "\tfirst\n") {
+		t.Fatalf("Expected the message to be printed, got %d %q %v", n, out.String(), err)
+	}
+
+	// Only messages not printed before are printed again
+	emu.Storage().Publish("projects/test/topics/orders", []emulator.PubSubMessage{{Data: emulator.EncodeData([]byte("second"))}})
+	out.Reset()
+	n, _, err = peekAndPrint(context.Background(), c, &out, "projects/test/subscriptions/billing", seen)
+	if err != nil || n != 1 || !strings.HasSuffix(out.String(), "\tsecond\n") {
+		t.Fatalf("Expected only the new message, got %d %q %v", n, out.String(), err)
+	}
+
+	// Nothing was leased, so a real consumer still gets both messages
+	pulled, _ := emu.Storage().Pull("projects/test/subscriptions/billing", 10)
+	if len(pulled) != 2 {
+		t.Errorf("Expected the messages to stay available, got %d", len(pulled))
+	}
+}
@@ -0,0 +1,725 @@
+package emulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Admin API routes, served under /admin/ next to the Pub/Sub API
+var (
+	adminResetRegex        = regexp.MustCompile(`^/admin/v1:reset$`)
+	adminProjectResetRegex = regexp.MustCompile(`^/admin/v1/projects/([^/]+):reset$`)
+	adminCheckpointsRegex  = regexp.MustCompile(`^/admin/v1/checkpoints$`)
+	adminCheckpointRegex   = regexp.MustCompile(`^/admin/v1/checkpoints/([^/:]+)$`)
+	adminRestoreRegex      = regexp.MustCompile(`^/admin/v1/checkpoints/([^/:]+):restore$`)
+	adminStateRegex        = regexp.MustCompile(`^/admin/v1/state$`)
+	adminImportRegex       = regexp.MustCompile(`^/admin/v1/state:import$`)
+	adminProjectsRegex     = regexp.MustCompile(`^/admin/v1/projects$`)
+	adminPeekRegex         = regexp.MustCompile(`^/admin/v1/projects/([^/]+)/subscriptions/([^/]+)/messages$`)
+	adminHistoryRegex      = regexp.MustCompile(`^/admin/v1/projects/([^/]+)/messages/([^/]+)/history$`)
+	adminChaosRegex        = regexp.MustCompile(`^/admin/v1/projects/([^/]+)/subscriptions/([^/]+)/chaos$`)
+	adminPurgeRegex        = regexp.MustCompile(`^/admin/v1/projects/([^/]+)/subscriptions/([^/]+):purge$`)
+	adminTailRegex         = regexp.MustCompile(`^/admin/v1/projects/([^/]+)/topics/([^/]+):tail$`)
+	adminNamespacesRegex   = regexp.MustCompile(`^/admin/v1/namespaces$`)
+	adminNamespaceRegex    = regexp.MustCompile(`^/admin/v1/namespaces/(` + namespaceNamePattern + `)$`)
+	adminLimitsRegex       = regexp.MustCompile(`^/admin/v1/namespaces/(` + namespaceNamePattern + `)/limits$`)
+	adminClockRegex        = regexp.MustCompile(`^/admin/v1/clock$`)
+	adminClockActionRegex  = regexp.MustCompile(`^/admin/v1/clock:(advance|set)$`)
+)
+
+// ListCheckpointsResponse is the response for listing checkpoints
+type ListCheckpointsResponse struct {
+	Checkpoints []string `json:"checkpoints"`
+}
+
+// ProjectOverview lists the resources of a project
+type ProjectOverview struct {
+	ID            string                 `json:"id"`
+	Topics        []TopicOverview        `json:"topics"`
+	Subscriptions []SubscriptionOverview `json:"subscriptions"`
+}
+
+// TopicOverview is a topic together with its publish count
+type TopicOverview struct {
+	Topic
+	Published int64 `json:"published"` // messages published since startup
+}
+
+// SubscriptionOverview is a subscription together with its backlog counts
+type SubscriptionOverview struct {
+	Subscription
+	BacklogStats
+	Acked int64 `json:"acked"` // messages acknowledged since startup
+}
+
+// PurgeResponse is the response for purging a subscription
+type PurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+// ListProjectsResponse is the response for listing projects
+type ListProjectsResponse struct {
+	Projects []ProjectOverview `json:"projects"`
+}
+
+// ClockResponse reports the emulator's time
+type ClockResponse struct {
+	Time    time.Time `json:"time"`
+	Virtual bool      `json:"virtual"`
+}
+
+// AdvanceClockRequest moves a virtual clock forward by a duration such as
+// "30s"
+type AdvanceClockRequest struct {
+	Duration string `json:"duration"`
+}
+
+// SetClockRequest moves a virtual clock forward to a point in time
+type SetClockRequest struct {
+	Time time.Time `json:"time"`
+}
+
+// isAdminPath reports whether a request targets the admin API
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/")
+}
+
+// serveAdmin routes admin API requests
+func (s *Server) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	// Reset everything
+	if adminResetRegex.MatchString(path) {
+		if r.Method == http.MethodPost {
+			s.handleReset(w, r, "")
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Reset a single project
+	if matches := adminProjectResetRegex.FindStringSubmatch(path); matches != nil {
+		if r.Method == http.MethodPost {
+			s.handleReset(w, r, matches[1])
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Checkpoint restore (check before checkpoint operations)
+	if matches := adminRestoreRegex.FindStringSubmatch(path); matches != nil {
+		if r.Method == http.MethodPost {
+			s.handleRestoreCheckpoint(w, r, matches[1])
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// List checkpoints
+	if adminCheckpointsRegex.MatchString(path) {
+		if r.Method == http.MethodGet {
+			s.handleListCheckpoints(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Checkpoint operations
+	if matches := adminCheckpointRegex.FindStringSubmatch(path); matches != nil {
+		switch r.Method {
+		case http.MethodPut:
+			s.handleSaveCheckpoint(w, r, matches[1])
+		case http.MethodDelete:
+			s.handleDeleteCheckpoint(w, r, matches[1])
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// State export
+	if adminStateRegex.MatchString(path) {
+		if r.Method == http.MethodGet {
+			s.handleExportState(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// State import
+	if adminImportRegex.MatchString(path) {
+		if r.Method == http.MethodPost {
+			s.handleImportState(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// List projects with their resources
+	if adminProjectsRegex.MatchString(path) {
+		if r.Method == http.MethodGet {
+			s.handleListProjects(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Peek at a subscription's messages
+	if matches := adminPeekRegex.FindStringSubmatch(path); matches != nil {
+		project, subscription := matches[1], matches[2]
+		subscriptionName := fmt.Sprintf("projects/%s/subscriptions/%s", project, subscription)
+
+		if r.Method == http.MethodGet {
+			s.handlePeekMessages(w, r, subscriptionName)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// History of a message
+	if matches := adminHistoryRegex.FindStringSubmatch(path); matches != nil {
+		if r.Method == http.MethodGet {
+			s.handleMessageHistory(w, r, matches[1], matches[2])
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Chaos policy of a subscription
+	if matches := adminChaosRegex.FindStringSubmatch(path); matches != nil {
+		project, subscription := matches[1], matches[2]
+		subscriptionName := fmt.Sprintf("projects/%s/subscriptions/%s", project, subscription)
+
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetChaosPolicy(w, r, subscriptionName)
+		case http.MethodPut:
+			s.handleSetChaosPolicy(w, r, subscriptionName)
+		case http.MethodDelete:
+			s.handleDeleteChaosPolicy(w, r, subscriptionName)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Purge a subscription
+	if matches := adminPurgeRegex.FindStringSubmatch(path); matches != nil {
+		if r.Method == http.MethodPost {
+			s.handlePurge(w, r, fmt.Sprintf("projects/%s/subscriptions/%s", matches[1], matches[2]))
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Stream the messages published to a topic
+	if matches := adminTailRegex.FindStringSubmatch(path); matches != nil {
+		if r.Method == http.MethodGet {
+			s.handleTailTopic(w, r, fmt.Sprintf("projects/%s/topics/%s", matches[1], matches[2]))
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Namespaces, which only exist next to the shared view
+	if s.namespaces != nil {
+		if adminNamespacesRegex.MatchString(path) {
+			if r.Method == http.MethodGet {
+				s.handleListNamespaces(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+		if matches := adminNamespaceRegex.FindStringSubmatch(path); matches != nil {
+			switch r.Method {
+			case http.MethodGet:
+				s.handleGetNamespace(w, r, matches[1])
+			case http.MethodDelete:
+				s.handleDeleteNamespace(w, r, matches[1])
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+		if matches := adminLimitsRegex.FindStringSubmatch(path); matches != nil {
+			if r.Method == http.MethodPut {
+				s.handleSetNamespaceLimits(w, r, matches[1])
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+	}
+
+	// Current time
+	if adminClockRegex.MatchString(path) {
+		if r.Method == http.MethodGet {
+			s.handleGetClock(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Advance or set the virtual clock
+	if matches := adminClockActionRegex.FindStringSubmatch(path); matches != nil {
+		if r.Method == http.MethodPost {
+			s.handleMoveClock(w, r, matches[1])
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+// checkpointer returns the storage as a Checkpointer, or writes an error if
+// the backend does not support checkpoints
+func (s *Server) checkpointer(w http.ResponseWriter) (Checkpointer, bool) {
+	checkpointer, ok := s.storage.(Checkpointer)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "checkpoints are not supported by this backend"})
+	}
+	return checkpointer, ok
+}
+
+func (s *Server) handleSaveCheckpoint(w http.ResponseWriter, r *http.Request, name string) {
+	checkpointer, ok := s.checkpointer(w)
+	if !ok {
+		return
+	}
+
+	if err := checkpointer.SaveCheckpoint(name); err != nil {
+		logger.Error("failed to save checkpoint",
+			"operation", "save_checkpoint",
+			"checkpoint", name,
+			"error", err.Error())
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	logger.Info("checkpoint saved",
+		"operation", "save_checkpoint",
+		"checkpoint", name)
+	writeJSON(w, http.StatusOK, map[string]string{"name": name})
+}
+
+func (s *Server) handleRestoreCheckpoint(w http.ResponseWriter, r *http.Request, name string) {
+	checkpointer, ok := s.checkpointer(w)
+	if !ok {
+		return
+	}
+
+	if err := checkpointer.RestoreCheckpoint(name); err != nil {
+		logger.Error("failed to restore checkpoint",
+			"operation", "restore_checkpoint",
+			"checkpoint", name,
+			"error", err.Error())
+		if err == ErrCheckpointNotFound {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		} else {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return
+	}
+
+	logger.Info("checkpoint restored",
+		"operation", "restore_checkpoint",
+		"checkpoint", name)
+	writeJSON(w, http.StatusOK, map[string]string{"name": name})
+}
+
+func (s *Server) handleDeleteCheckpoint(w http.ResponseWriter, r *http.Request, name string) {
+	checkpointer, ok := s.checkpointer(w)
+	if !ok {
+		return
+	}
+
+	if err := checkpointer.DeleteCheckpoint(name); err != nil {
+		if err == ErrCheckpointNotFound {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		} else {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return
+	}
+
+	logger.Info("checkpoint deleted",
+		"operation", "delete_checkpoint",
+		"checkpoint", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListCheckpoints(w http.ResponseWriter, r *http.Request) {
+	checkpointer, ok := s.checkpointer(w)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ListCheckpointsResponse{Checkpoints: checkpointer.ListCheckpoints()})
+}
+
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request, project string) {
+	if err := s.storage.Reset(project); err != nil {
+		logger.Error("failed to reset",
+			"operation", "reset",
+			"project", project,
+			"error", err.Error())
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	s.tails.reset(project)
+
+	logger.Info("reset",
+		"operation", "reset",
+		"project", project)
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+// stateExporter returns the storage as a StateExporter, or writes an error if
+// the backend does not support export
+func (s *Server) stateExporter(w http.ResponseWriter) (StateExporter, bool) {
+	exporter, ok := s.storage.(StateExporter)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "state export is not supported by this backend"})
+	}
+	return exporter, ok
+}
+
+func (s *Server) handleExportState(w http.ResponseWriter, r *http.Request) {
+	exporter, ok := s.stateExporter(w)
+	if !ok {
+		return
+	}
+
+	snap := exporter.ExportState()
+
+	logger.Info("state exported",
+		"operation", "export_state",
+		"topic_count", len(snap.Topics),
+		"subscription_count", len(snap.Subscriptions))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(snap)
+}
+
+func (s *Server) handleImportState(w http.ResponseWriter, r *http.Request) {
+	exporter, ok := s.stateExporter(w)
+	if !ok {
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "merge"
+	}
+	if mode != "merge" && mode != "replace" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "mode must be merge or replace"})
+		return
+	}
+
+	var snap StateSnapshot
+	if err := json.NewDecoder(r.Body).Decode(&snap); err != nil {
+		logger.Error("invalid request body",
+			"operation", "import_state",
+			"error", err.Error())
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	if err := exporter.ImportState(&snap, mode == "replace"); err != nil {
+		logger.Error("failed to import state",
+			"operation", "import_state",
+			"mode", mode,
+			"error", err.Error())
+		if errors.Is(err, ErrInvalidState) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		} else {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return
+	}
+
+	logger.Info("state imported",
+		"operation", "import_state",
+		"mode", mode,
+		"topic_count", len(snap.Topics),
+		"subscription_count", len(snap.Subscriptions))
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+// handlePeekMessages lists a subscription's messages without leasing them.
+// Query parameters: state (available or leased), attribute=key=value
+// (repeatable, all must match), pageSize and pageToken.
+func (s *Server) handlePeekMessages(w http.ResponseWriter, r *http.Request, subscriptionName string) {
+	peeker, ok := s.storage.(MessagePeeker)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "peeking is not supported by this backend"})
+		return
+	}
+
+	query := r.URL.Query()
+	filter := PeekFilter{State: query.Get("state")}
+	switch filter.State {
+	case "", PeekStateAvailable, PeekStateLeased:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "state must be available or leased"})
+		return
+	}
+	for _, attr := range query["attribute"] {
+		key, value, found := strings.Cut(attr, "=")
+		if !found {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "attribute must be key=value"})
+			return
+		}
+		if filter.Attributes == nil {
+			filter.Attributes = make(map[string]string)
+		}
+		filter.Attributes[key] = value
+	}
+
+	pageSize := 0
+	if value := query.Get("pageSize"); value != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(value); err != nil || pageSize < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "pageSize must be a non-negative integer"})
+			return
+		}
+	}
+
+	messages, nextPageToken, err := peeker.PeekMessages(subscriptionName, filter, pageSize, query.Get("pageToken"))
+	if err != nil {
+		switch err {
+		case ErrSubscriptionNotFound:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		case ErrInvalidPageToken:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		default:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, PeekMessagesResponse{Messages: messages, NextPageToken: nextPageToken})
+}
+
+// handleListProjects lists every project that has a topic or subscription,
+// with backlog counts per subscription. Projects only exist through their
+// resources, so the list is derived from resource names.
+func (s *Server) handleListProjects(w http.ResponseWriter, r *http.Request) {
+	projects := make(map[string]*ProjectOverview)
+	project := func(name string) *ProjectOverview {
+		id := projectOf(name)
+		if projects[id] == nil {
+			projects[id] = &ProjectOverview{ID: id, Topics: []TopicOverview{}, Subscriptions: []SubscriptionOverview{}}
+		}
+		return projects[id]
+	}
+
+	for _, topic := range s.storage.ListTopics() {
+		p := project(topic.Name)
+		p.Topics = append(p.Topics, TopicOverview{Topic: *topic, Published: s.metrics.published(topic.Name)})
+	}
+	for _, sub := range s.storage.ListSubscriptions() {
+		stats, err := s.storage.BacklogStats(sub.Name)
+		if err == ErrSubscriptionNotFound {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		p := project(sub.Name)
+		p.Subscriptions = append(p.Subscriptions, SubscriptionOverview{Subscription: *sub, BacklogStats: *stats, Acked: s.metrics.acked(sub.Name)})
+	}
+
+	resp := ListProjectsResponse{Projects: make([]ProjectOverview, 0, len(projects))}
+	for _, p := range projects {
+		sort.Slice(p.Topics, func(i, j int) bool { return p.Topics[i].Name < p.Topics[j].Name })
+		sort.Slice(p.Subscriptions, func(i, j int) bool { return p.Subscriptions[i].Name < p.Subscriptions[j].Name })
+		resp.Projects = append(resp.Projects, *p)
+	}
+	sort.Slice(resp.Projects, func(i, j int) bool { return resp.Projects[i].ID < resp.Projects[j].ID })
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleMessageHistory returns the recorded lifecycle of a message in the
+// project's subscriptions, optionally limited to one with ?subscription=
+func (s *Server) handleMessageHistory(w http.ResponseWriter, r *http.Request, project, messageID string) {
+	subscriptionName := ""
+	if subscription := r.URL.Query().Get("subscription"); subscription != "" {
+		subscriptionName = fmt.Sprintf("projects/%s/subscriptions/%s", project, subscription)
+	}
+
+	histories := s.history.Message(project, messageID, subscriptionName)
+	if len(histories) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "No history recorded for message"})
+		return
+	}
+	writeJSON(w, http.StatusOK, MessageHistoryResponse{MessageID: messageID, Subscriptions: histories})
+}
+
+// chaosController returns the storage as a ChaosController, or writes an error
+// if the backend does not support chaos policies
+func (s *Server) chaosController(w http.ResponseWriter) (ChaosController, bool) {
+	controller, ok := s.storage.(ChaosController)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "chaos policies are not supported by this backend"})
+	}
+	return controller, ok
+}
+
+func (s *Server) handleGetChaosPolicy(w http.ResponseWriter, r *http.Request, subscriptionName string) {
+	controller, ok := s.chaosController(w)
+	if !ok {
+		return
+	}
+
+	policy, err := controller.ChaosPolicy(subscriptionName)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if policy == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "subscription has no chaos policy"})
+		return
+	}
+	writeJSON(w, http.StatusOK, policy)
+}
+
+func (s *Server) handleSetChaosPolicy(w http.ResponseWriter, r *http.Request, subscriptionName string) {
+	controller, ok := s.chaosController(w)
+	if !ok {
+		return
+	}
+
+	var policy ChaosPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	installed, err := controller.SetChaosPolicy(subscriptionName, &policy)
+	if err != nil {
+		if err == ErrSubscriptionNotFound {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		} else {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return
+	}
+
+	logger.Info("set chaos policy",
+		"operation", "set_chaos_policy",
+		"subscription", subscriptionName,
+		"seed", installed.Seed)
+	writeJSON(w, http.StatusOK, installed)
+}
+
+func (s *Server) handleDeleteChaosPolicy(w http.ResponseWriter, r *http.Request, subscriptionName string) {
+	controller, ok := s.chaosController(w)
+	if !ok {
+		return
+	}
+
+	if _, err := controller.SetChaosPolicy(subscriptionName, nil); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	logger.Info("deleted chaos policy",
+		"operation", "delete_chaos_policy",
+		"subscription", subscriptionName)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePurge acknowledges every message in a subscription's backlog
+func (s *Server) handlePurge(w http.ResponseWriter, r *http.Request, subscriptionName string) {
+	purged, err := s.storage.Purge(subscriptionName)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == ErrSubscriptionNotFound {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+
+	logger.Info("purged subscription",
+		"operation", "purge",
+		"subscription", subscriptionName,
+		"message_count", purged)
+	writeJSON(w, http.StatusOK, PurgeResponse{Purged: purged})
+}
+
+func (s *Server) handleGetClock(w http.ResponseWriter, r *http.Request) {
+	clock := s.storage.Clock()
+	_, virtual := clock.(*VirtualClock)
+	writeJSON(w, http.StatusOK, ClockResponse{Time: clock.Now(), Virtual: virtual})
+}
+
+// handleMoveClock advances or sets the virtual clock
+func (s *Server) handleMoveClock(w http.ResponseWriter, r *http.Request, action string) {
+	clock, ok := s.storage.Clock().(*VirtualClock)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "the virtual clock is not enabled"})
+		return
+	}
+
+	var now time.Time
+	var err error
+	if action == "advance" {
+		var req AdvanceClockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+			return
+		}
+		d, parseErr := time.ParseDuration(req.Duration)
+		if parseErr != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "duration must be a duration such as 30s"})
+			return
+		}
+		now, err = clock.Advance(d)
+	} else {
+		var req SetClockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+			return
+		}
+		now, err = clock.Set(req.Time)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	logger.Info("moved clock",
+		"operation", "move_clock",
+		"action", action,
+		"time", now)
+	writeJSON(w, http.StatusOK, ClockResponse{Time: now, Virtual: true})
+}
@@ -0,0 +1,128 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleReset(t *testing.T) {
+	server := NewServer()
+
+	// Setup
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	server.storage.CreateTopic("projects/other/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/v1/projects/test:reset", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if topics := server.storage.ListTopics(); len(topics) != 1 {
+		t.Errorf("Expected 1 topic after project reset, got %d", len(topics))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/v1:reset", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if topics := server.storage.ListTopics(); len(topics) != 0 {
+		t.Errorf("Expected 0 topics after reset, got %d", len(topics))
+	}
+}
+
+func TestHandleReset_MethodNotAllowed(t *testing.T) {
+	server := NewServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/v1:reset", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func TestHandleListProjects(t *testing.T) {
+	server := NewServer()
+
+	// Setup
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	server.storage.CreateTopic("projects/other/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	server.storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdDE="}, {Data: "dGVzdDI="}})
+	server.storage.Pull("projects/test/subscriptions/sub1", 1)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/v1/projects", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var resp ListProjectsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Projects) != 2 || resp.Projects[0].ID != "other" || resp.Projects[1].ID != "test" {
+		t.Fatalf("Expected projects other and test, got %+v", resp.Projects)
+	}
+
+	subs := resp.Projects[1].Subscriptions
+	if len(subs) != 1 {
+		t.Fatalf("Expected 1 subscription, got %d", len(subs))
+	}
+	if subs[0].Name != "projects/test/subscriptions/sub1" || subs[0].Backlog != 2 || subs[0].Leased != 1 {
+		t.Errorf("Expected sub1 with backlog 2 and 1 leased, got %+v", subs[0])
+	}
+	if topics := resp.Projects[1].Topics; len(topics) != 1 || topics[0].Published != 2 {
+		t.Errorf("Expected topic1 with 2 published messages, got %+v", topics)
+	}
+}
+
+func TestHandlePurge(t *testing.T) {
+	server := NewServer()
+
+	// Setup
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	server.storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdDE="}, {Data: "dGVzdDI="}})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/v1/projects/test/subscriptions/sub1:purge", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp PurgeResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Purged != 2 {
+		t.Errorf("Expected 2 purged messages, got %d", resp.Purged)
+	}
+
+	// Purged messages count as acknowledged
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/v1/projects", nil))
+	var projects ListProjectsResponse
+	json.NewDecoder(w.Body).Decode(&projects)
+	if sub := projects.Projects[0].Subscriptions[0]; sub.Backlog != 0 || sub.Acked != 2 {
+		t.Errorf("Expected an empty backlog and 2 acks, got %+v", sub)
+	}
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/v1/projects/test/subscriptions/missing:purge", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
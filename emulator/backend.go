@@ -0,0 +1,97 @@
+package emulator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Backend is the storage used by Server. Storage is the in-memory
+// implementation; BoltStorage keeps everything in an embedded database file.
+type Backend interface {
+	CreateTopic(name string) (*Topic, error)
+	GetTopic(name string) (*Topic, error)
+	DeleteTopic(name string) error
+	ListTopics() []*Topic
+
+	CreateSubscription(name, topicName string) (*Subscription, error)
+	GetSubscription(name string) (*Subscription, error)
+	DeleteSubscription(name string) error
+	ListSubscriptions() []*Subscription
+
+	Publish(topicName string, messages []PubSubMessage) ([]string, error)
+	Pull(subscriptionName string, maxMessages int) ([]ReceivedMessage, error)
+	Acknowledge(subscriptionName string, ackIDs []string) error
+	ModifyAckDeadline(subscriptionName string, ackIDs []string, ackDeadlineSeconds int) error
+
+	// Purge acknowledges every message in a subscription's backlog, leased
+	// or not, and returns how many there were
+	Purge(subscriptionName string) (int, error)
+
+	// BacklogStats summarizes the unacknowledged messages of a subscription
+	// without changing them
+	BacklogStats(subscriptionName string) (*BacklogStats, error)
+
+	// Reset atomically deletes every topic and subscription of a project,
+	// including their messages and leases. An empty project resets everything.
+	Reset(project string) error
+
+	// Observe registers an observer that is notified of message events
+	Observe(fn Observer)
+
+	// Clock returns the source of the backend's timestamps and deadlines;
+	// SetClock replaces it before the backend is used
+	Clock() Clock
+	SetClock(clock Clock)
+
+	// SetIDGenerator replaces the source of message and ack IDs before the
+	// backend is used
+	SetIDGenerator(ids IDGenerator)
+
+	// Close flushes and releases any resources held by the backend
+	Close() error
+}
+
+// BacklogStats describes a subscription's backlog
+type BacklogStats struct {
+	Backlog int `json:"backlog"` // unacknowledged messages
+	Leased  int `json:"leased"`  // unacknowledged messages currently leased
+
+	// OldestUnackedAt is the publish time of the oldest unacknowledged
+	// message, or zero if the backlog is empty
+	OldestUnackedAt time.Time `json:"oldestUnackedAt,omitzero"`
+}
+
+var (
+	_ Backend = (*Storage)(nil)
+	_ Backend = (*BoltStorage)(nil)
+)
+
+// leaseDuration is how long a pulled message is leased before it is
+// redelivered. The tests of this package shorten it; it must not depend on
+// testing.Testing, which is also true in the tests of programs embedding the
+// emulator.
+var leaseDuration = 10 * time.Second
+
+// ackDeadline returns how long a pulled message is leased before it is
+// redelivered
+func ackDeadline() time.Duration {
+	return leaseDuration
+}
+
+// inProject reports whether a resource name belongs to project. Every name
+// belongs to the empty project.
+func inProject(name, project string) bool {
+	return project == "" || strings.HasPrefix(name, fmt.Sprintf("projects/%s/", project))
+}
+
+// projectOf returns the project of a resource name, or "" if the name is not
+// of the form projects/<project>/...
+func projectOf(name string) string {
+	rest, found := strings.CutPrefix(name, "projects/")
+	if !found {
+		return ""
+	}
+	project, _, _ := strings.Cut(rest, "/")
+	return project
+}
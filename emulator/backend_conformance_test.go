@@ -0,0 +1,726 @@
+package emulator
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The conformance suite runs every case against each Backend implementation.
+// Add new backends to backendFactories.
+
+var backendFactories = map[string]func(t *testing.T) Backend{
+	"memory": func(t *testing.T) Backend {
+		return NewStorage()
+	},
+	"memory-wal": func(t *testing.T) Backend {
+		storage, err := OpenStorage(t.TempDir(), 0)
+		if err != nil {
+			t.Fatalf("Failed to open storage: %v", err)
+		}
+		return storage
+	},
+	"bolt": func(t *testing.T) Backend {
+		storage, err := OpenBoltStorage(filepath.Join(t.TempDir(), "pubsub.db"))
+		if err != nil {
+			t.Fatalf("Failed to open storage: %v", err)
+		}
+		return storage
+	},
+}
+
+func forEachBackend(t *testing.T, test func(t *testing.T, storage Backend)) {
+	for name, newBackend := range backendFactories {
+		t.Run(name, func(t *testing.T) {
+			storage := newBackend(t)
+			t.Cleanup(func() {
+				if err := storage.Close(); err != nil {
+					t.Errorf("Failed to close storage: %v", err)
+				}
+			})
+			test(t, storage)
+		})
+	}
+}
+
+func TestBackend_CreateTopic(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Test creating a topic
+		topic, err := storage.CreateTopic("projects/test/topics/topic1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if topic.Name != "projects/test/topics/topic1" {
+			t.Errorf("Expected topic name 'projects/test/topics/topic1', got %s", topic.Name)
+		}
+
+		// Test creating duplicate topic
+		_, err = storage.CreateTopic("projects/test/topics/topic1")
+		if err != ErrTopicAlreadyExists {
+			t.Errorf("Expected ErrTopicAlreadyExists, got %v", err)
+		}
+	})
+}
+
+func TestBackend_GetTopic(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Test getting non-existent topic
+		_, err := storage.GetTopic("projects/test/topics/nonexistent")
+		if err != ErrTopicNotFound {
+			t.Errorf("Expected ErrTopicNotFound, got %v", err)
+		}
+
+		// Create and get topic
+		storage.CreateTopic("projects/test/topics/topic1")
+		topic, err := storage.GetTopic("projects/test/topics/topic1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if topic.Name != "projects/test/topics/topic1" {
+			t.Errorf("Expected topic name 'projects/test/topics/topic1', got %s", topic.Name)
+		}
+	})
+}
+
+func TestBackend_DeleteTopic(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Test deleting non-existent topic
+		err := storage.DeleteTopic("projects/test/topics/nonexistent")
+		if err != ErrTopicNotFound {
+			t.Errorf("Expected ErrTopicNotFound, got %v", err)
+		}
+
+		// Create and delete topic
+		storage.CreateTopic("projects/test/topics/topic1")
+		err = storage.DeleteTopic("projects/test/topics/topic1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// Verify topic is deleted
+		_, err = storage.GetTopic("projects/test/topics/topic1")
+		if err != ErrTopicNotFound {
+			t.Errorf("Expected ErrTopicNotFound after deletion, got %v", err)
+		}
+	})
+}
+
+func TestBackend_ListTopics(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Test empty list
+		topics := storage.ListTopics()
+		if len(topics) != 0 {
+			t.Errorf("Expected 0 topics, got %d", len(topics))
+		}
+
+		// Create topics and list
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateTopic("projects/test/topics/topic2")
+		topics = storage.ListTopics()
+		if len(topics) != 2 {
+			t.Errorf("Expected 2 topics, got %d", len(topics))
+		}
+	})
+}
+
+func TestBackend_CreateSubscription(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Test creating subscription without topic
+		_, err := storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		if err != ErrTopicNotFound {
+			t.Errorf("Expected ErrTopicNotFound, got %v", err)
+		}
+
+		// Create topic first
+		storage.CreateTopic("projects/test/topics/topic1")
+
+		// Test creating subscription
+		sub, err := storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if sub.Name != "projects/test/subscriptions/sub1" {
+			t.Errorf("Expected subscription name 'projects/test/subscriptions/sub1', got %s", sub.Name)
+		}
+		if sub.Topic != "projects/test/topics/topic1" {
+			t.Errorf("Expected topic 'projects/test/topics/topic1', got %s", sub.Topic)
+		}
+
+		// Test creating duplicate subscription
+		_, err = storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		if err != ErrSubscriptionAlreadyExists {
+			t.Errorf("Expected ErrSubscriptionAlreadyExists, got %v", err)
+		}
+	})
+}
+
+func TestBackend_GetSubscription(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Test getting non-existent subscription
+		_, err := storage.GetSubscription("projects/test/subscriptions/nonexistent")
+		if err != ErrSubscriptionNotFound {
+			t.Errorf("Expected ErrSubscriptionNotFound, got %v", err)
+		}
+
+		// Create and get subscription
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		sub, err := storage.GetSubscription("projects/test/subscriptions/sub1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if sub.Name != "projects/test/subscriptions/sub1" {
+			t.Errorf("Expected subscription name 'projects/test/subscriptions/sub1', got %s", sub.Name)
+		}
+	})
+}
+
+func TestBackend_DeleteSubscription(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Test deleting non-existent subscription
+		err := storage.DeleteSubscription("projects/test/subscriptions/nonexistent")
+		if err != ErrSubscriptionNotFound {
+			t.Errorf("Expected ErrSubscriptionNotFound, got %v", err)
+		}
+
+		// Create and delete subscription
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		err = storage.DeleteSubscription("projects/test/subscriptions/sub1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// Verify subscription is deleted
+		_, err = storage.GetSubscription("projects/test/subscriptions/sub1")
+		if err != ErrSubscriptionNotFound {
+			t.Errorf("Expected ErrSubscriptionNotFound after deletion, got %v", err)
+		}
+	})
+}
+
+func TestBackend_PublishAndPull(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+		// Test publishing to non-existent topic
+		_, err := storage.Publish("projects/test/topics/nonexistent", []PubSubMessage{
+			{Data: "dGVzdA==", Attributes: map[string]string{"key": "value"}},
+		})
+		if err != ErrTopicNotFound {
+			t.Errorf("Expected ErrTopicNotFound, got %v", err)
+		}
+
+		// Publish messages
+		messages := []PubSubMessage{
+			{Data: "dGVzdDE=", Attributes: map[string]string{"key1": "value1"}},
+			{Data: "dGVzdDI=", Attributes: map[string]string{"key2": "value2"}},
+		}
+		messageIDs, err := storage.Publish("projects/test/topics/topic1", messages)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(messageIDs) != 2 {
+			t.Errorf("Expected 2 message IDs, got %d", len(messageIDs))
+		}
+
+		// Pull messages immediately (should be available right after publish)
+		pulled, err := storage.Pull("projects/test/subscriptions/sub1", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled) != 2 {
+			t.Errorf("Expected 2 messages immediately, got %d", len(pulled))
+		}
+		if pulled[0].Message.Data != "dGVzdDE=" {
+			t.Errorf("Expected data 'dGVzdDE=', got %s", pulled[0].Message.Data)
+		}
+		if pulled[0].Message.Attributes["key1"] != "value1" {
+			t.Errorf("Expected attribute key1='value1', got %s", pulled[0].Message.Attributes["key1"])
+		}
+
+		// Pull again immediately - should be empty because messages are within ack deadline
+		pulled, err = storage.Pull("projects/test/subscriptions/sub1", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled) != 0 {
+			t.Errorf("Expected 0 messages (within ack deadline), got %d", len(pulled))
+		}
+
+		// Wait for ack deadline to pass
+		time.Sleep(100 * time.Millisecond)
+
+		// Pull again - messages should be redelivered since they weren't acked
+		pulled, err = storage.Pull("projects/test/subscriptions/sub1", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled) != 2 {
+			t.Errorf("Expected 2 messages after deadline, got %d", len(pulled))
+		}
+	})
+}
+
+func TestBackend_PullWithMaxMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+		// Publish 5 messages
+		messages := make([]PubSubMessage, 5)
+		for i := 0; i < 5; i++ {
+			messages[i] = PubSubMessage{Data: "dGVzdA=="}
+		}
+		storage.Publish("projects/test/topics/topic1", messages)
+
+		// Pull with maxMessages = 3
+		pulled, err := storage.Pull("projects/test/subscriptions/sub1", 3)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled) != 3 {
+			t.Errorf("Expected 3 messages, got %d", len(pulled))
+		}
+	})
+}
+
+func TestBackend_Acknowledge(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+		// Publish messages
+		messages := []PubSubMessage{
+			{Data: "dGVzdDE="},
+			{Data: "dGVzdDI="},
+		}
+		storage.Publish("projects/test/topics/topic1", messages)
+
+		// Pull messages
+		pulled, err := storage.Pull("projects/test/subscriptions/sub1", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(pulled))
+		}
+
+		// Acknowledge first message
+		ackIDs := []string{pulled[0].AckID}
+		err = storage.Acknowledge("projects/test/subscriptions/sub1", ackIDs)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// Wait for ack deadline to pass
+		time.Sleep(100 * time.Millisecond)
+
+		// Pull again - should only get the second message (first was acked)
+		pulled, err = storage.Pull("projects/test/subscriptions/sub1", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled) != 1 {
+			t.Errorf("Expected 1 message after ack, got %d", len(pulled))
+		}
+		if pulled[0].Message.Data != "dGVzdDI=" {
+			t.Errorf("Expected second message, got %s", pulled[0].Message.Data)
+		}
+	})
+}
+
+func TestBackend_MultipleSubscriptions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub2", "projects/test/topics/topic1")
+
+		// Publish message
+		messages := []PubSubMessage{{Data: "dGVzdA=="}}
+		storage.Publish("projects/test/topics/topic1", messages)
+
+		// Both subscriptions should receive the message immediately
+		pulled1, err := storage.Pull("projects/test/subscriptions/sub1", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled1) != 1 {
+			t.Errorf("Expected 1 message in sub1, got %d", len(pulled1))
+		}
+
+		pulled2, err := storage.Pull("projects/test/subscriptions/sub2", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled2) != 1 {
+			t.Errorf("Expected 1 message in sub2, got %d", len(pulled2))
+		}
+	})
+}
+
+func TestBackend_ModifyAckDeadline(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+		// Publish and pull messages
+		messages := []PubSubMessage{{Data: "dGVzdA=="}}
+		storage.Publish("projects/test/topics/topic1", messages)
+		pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+
+		// Modify ack deadline to 0 (make immediately available)
+		err := storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{pulled[0].AckID}, 0)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// Pull immediately - message should be available
+		pulled2, err := storage.Pull("projects/test/subscriptions/sub1", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled2) != 1 {
+			t.Errorf("Expected 1 message after modifying deadline to 0, got %d", len(pulled2))
+		}
+	})
+}
+
+func TestBackend_ModifyAckDeadline_ExtendDeadline(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+		// Publish and pull messages
+		messages := []PubSubMessage{{Data: "dGVzdA=="}}
+		storage.Publish("projects/test/topics/topic1", messages)
+		pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+
+		// Modify ack deadline to 10 seconds
+		err := storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{pulled[0].AckID}, 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// Wait for original deadline to pass (50ms in tests)
+		time.Sleep(100 * time.Millisecond)
+
+		// Pull - message should NOT be available (extended deadline)
+		pulled2, err := storage.Pull("projects/test/subscriptions/sub1", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled2) != 0 {
+			t.Errorf("Expected 0 messages (deadline extended), got %d", len(pulled2))
+		}
+	})
+}
+
+func TestBackend_ModifyAckDeadline_SubscriptionNotFound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		err := storage.ModifyAckDeadline("projects/test/subscriptions/nonexistent", []string{"test-ack-id"}, 30)
+		if err != ErrSubscriptionNotFound {
+			t.Errorf("Expected ErrSubscriptionNotFound, got %v", err)
+		}
+	})
+}
+
+func TestBackend_ModifyAckDeadline_InvalidAckID(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+		// Try to modify with invalid ack ID
+		err := storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{"invalid-ack-id"}, 30)
+		if err == nil {
+			t.Error("Expected error for invalid ack ID, got nil")
+		}
+	})
+}
+
+func TestBackend_ModifyAckDeadline_MultipleMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+		// Publish multiple messages
+		messages := []PubSubMessage{
+			{Data: "dGVzdDE="},
+			{Data: "dGVzdDI="},
+			{Data: "dGVzdDM="},
+		}
+		storage.Publish("projects/test/topics/topic1", messages)
+		pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+
+		// Modify deadline for first and third message
+		ackIDs := []string{pulled[0].AckID, pulled[2].AckID}
+		err := storage.ModifyAckDeadline("projects/test/subscriptions/sub1", ackIDs, 0)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// Pull immediately - should get 2 messages
+		pulled2, err := storage.Pull("projects/test/subscriptions/sub1", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled2) != 2 {
+			t.Errorf("Expected 2 messages (modified deadline), got %d", len(pulled2))
+		}
+	})
+}
+
+func TestBackend_ModifyAckDeadline_AfterAcknowledge(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+		// Publish, pull, and acknowledge
+		messages := []PubSubMessage{{Data: "dGVzdA=="}}
+		storage.Publish("projects/test/topics/topic1", messages)
+		pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+		storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID})
+
+		// Try to modify ack deadline after acknowledge
+		err := storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{pulled[0].AckID}, 30)
+		if err == nil {
+			t.Error("Expected error when modifying deadline of acknowledged message, got nil")
+		}
+	})
+}
+
+func TestBackend_Reset(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateTopic("projects/other/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		storage.CreateSubscription("projects/other/subscriptions/sub1", "projects/test/topics/topic1")
+		storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+
+		// Reset a single project
+		if err := storage.Reset("test"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := storage.GetTopic("projects/test/topics/topic1"); err != ErrTopicNotFound {
+			t.Errorf("Expected ErrTopicNotFound after reset, got %v", err)
+		}
+		if _, err := storage.GetSubscription("projects/test/subscriptions/sub1"); err != ErrSubscriptionNotFound {
+			t.Errorf("Expected ErrSubscriptionNotFound after reset, got %v", err)
+		}
+
+		// Other projects keep their resources and messages
+		pulled, err := storage.Pull("projects/other/subscriptions/sub1", 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled) != 1 {
+			t.Errorf("Expected 1 message in other project, got %d", len(pulled))
+		}
+
+		// Reset everything
+		if err := storage.Reset(""); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if topics := storage.ListTopics(); len(topics) != 0 {
+			t.Errorf("Expected 0 topics after full reset, got %d", len(topics))
+		}
+		if subs := storage.ListSubscriptions(); len(subs) != 0 {
+			t.Errorf("Expected 0 subscriptions after full reset, got %d", len(subs))
+		}
+
+		// Names can be reused right away
+		if _, err := storage.CreateTopic("projects/test/topics/topic1"); err != nil {
+			t.Errorf("Expected no error recreating topic, got %v", err)
+		}
+	})
+}
+
+func TestBackend_BacklogStats(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+		stats, err := storage.BacklogStats("projects/test/subscriptions/sub1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if stats.Backlog != 0 || !stats.OldestUnackedAt.IsZero() {
+			t.Errorf("Expected empty backlog, got %+v", stats)
+		}
+
+		storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdDE="}, {Data: "dGVzdDI="}, {Data: "dGVzdDM="}})
+		pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 2)
+		storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID})
+
+		stats, err = storage.BacklogStats("projects/test/subscriptions/sub1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if stats.Backlog != 2 {
+			t.Errorf("Expected backlog 2, got %d", stats.Backlog)
+		}
+		if stats.Leased != 1 {
+			t.Errorf("Expected 1 leased message, got %d", stats.Leased)
+		}
+		if stats.OldestUnackedAt.IsZero() {
+			t.Error("Expected oldest unacked time to be set")
+		}
+
+		if _, err := storage.BacklogStats("projects/test/subscriptions/missing"); err != ErrSubscriptionNotFound {
+			t.Errorf("Expected ErrSubscriptionNotFound, got %v", err)
+		}
+	})
+}
+
+func TestBackend_Purge(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub2", "projects/test/topics/topic1")
+
+		var acks int
+		storage.Observe(func(e Event) {
+			if e.Kind == EventAck {
+				acks++
+			}
+		})
+
+		storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdDE="}, {Data: "dGVzdDI="}, {Data: "dGVzdDM="}})
+		storage.Pull("projects/test/subscriptions/sub1", 1)
+
+		purged, err := storage.Purge("projects/test/subscriptions/sub1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if purged != 3 || acks != 3 {
+			t.Errorf("Expected 3 purged and acked messages, got %d and %d", purged, acks)
+		}
+		if stats, _ := storage.BacklogStats("projects/test/subscriptions/sub1"); stats.Backlog != 0 {
+			t.Errorf("Expected an empty backlog, got %d", stats.Backlog)
+		}
+		if stats, _ := storage.BacklogStats("projects/test/subscriptions/sub2"); stats.Backlog != 3 {
+			t.Errorf("Expected the other subscription to keep its backlog, got %d", stats.Backlog)
+		}
+
+		if purged, err := storage.Purge("projects/test/subscriptions/sub1"); err != nil || purged != 0 {
+			t.Errorf("Expected nothing to purge, got %d %v", purged, err)
+		}
+		if _, err := storage.Purge("projects/test/subscriptions/missing"); err != ErrSubscriptionNotFound {
+			t.Errorf("Expected ErrSubscriptionNotFound, got %v", err)
+		}
+	})
+}
+
+func TestBackend_Events(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		var kinds []EventKind
+		storage.Observe(func(e Event) {
+			kinds = append(kinds, e.Kind)
+		})
+
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+		storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+		pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 1)
+		storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{pulled[0].AckID}, 0)
+		pulled, _ = storage.Pull("projects/test/subscriptions/sub1", 1)
+		time.Sleep(ackDeadline() + 10*time.Millisecond)
+		pulled, _ = storage.Pull("projects/test/subscriptions/sub1", 1)
+		storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID})
+		storage.Reset("test")
+
+		expected := []EventKind{EventPublish, EventDeliver, EventNack, EventDeliver, EventExpire, EventDeliver, EventAck, EventReset}
+		if fmt.Sprint(kinds) != fmt.Sprint(expected) {
+			t.Errorf("Expected events %v, got %v", expected, kinds)
+		}
+	})
+}
+
+func TestBackend_VirtualClock(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		clock := NewVirtualClock(start)
+		storage.SetClock(clock)
+
+		// Setup
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+
+		pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 1)
+		if len(pulled) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(pulled))
+		}
+		if pulled[0].Message.PublishTime != start.Format(time.RFC3339) {
+			t.Errorf("Expected publish time from the virtual clock, got %s", pulled[0].Message.PublishTime)
+		}
+
+		// Real time passing does not expire the lease
+		time.Sleep(ackDeadline() + 10*time.Millisecond)
+		if pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 1); len(pulled) != 0 {
+			t.Errorf("Expected no redelivery before the virtual deadline, got %d", len(pulled))
+		}
+
+		clock.Advance(ackDeadline() + time.Millisecond)
+		if pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 1); len(pulled) != 1 {
+			t.Errorf("Expected redelivery after advancing the clock, got %d", len(pulled))
+		}
+
+		stats, _ := storage.BacklogStats("projects/test/subscriptions/sub1")
+		if !stats.OldestUnackedAt.Equal(start) {
+			t.Errorf("Expected oldest unacked at %v, got %v", start, stats.OldestUnackedAt)
+		}
+	})
+}
+
+func TestBackend_SequentialIDs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub2", "projects/test/topics/topic1")
+
+		// IDs published before continue to be unique
+		storage.SetIDGenerator(NewSequentialIDs(100))
+		storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+		storage.SetIDGenerator(NewSequentialIDs(100))
+
+		ids, err := storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}, {Data: "dGVzdA=="}})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if fmt.Sprint(ids) != "[101 102]" {
+			t.Errorf("Expected message IDs [101 102], got %v", ids)
+		}
+
+		sub1, _ := storage.Pull("projects/test/subscriptions/sub1", 3)
+		sub2, _ := storage.Pull("projects/test/subscriptions/sub2", 3)
+		if len(sub1) != 3 || len(sub2) != 3 {
+			t.Fatalf("Expected 3 messages in each subscription, got %d and %d", len(sub1), len(sub2))
+		}
+		generator := NewSequentialIDs(100)
+		for i, msg := range sub1 {
+			if expected := generator.AckID("projects/test/subscriptions/sub1", msg.Message.MessageID); msg.AckID != expected {
+				t.Errorf("Expected derived ack ID %s, got %s", expected, msg.AckID)
+			}
+			if msg.AckID == sub2[i].AckID {
+				t.Errorf("Expected different ack IDs per subscription, got %s twice", msg.AckID)
+			}
+		}
+	})
+}
@@ -0,0 +1,714 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket layout of a BoltStorage database:
+//
+//	topics/<topic name>                       -> Topic
+//	subscriptions/<subscription name>         -> Subscription
+//	bodies/<topic name>\n<message ID>         -> boltBody
+//	backlogs/<subscription name>/messages/<seq> -> boltDelivery
+//	backlogs/<subscription name>/ackIds/<ack ID> -> seq
+var (
+	boltTopicsBucket        = []byte("topics")
+	boltSubscriptionsBucket = []byte("subscriptions")
+	boltBodiesBucket        = []byte("bodies")
+	boltBacklogsBucket      = []byte("backlogs")
+	boltMessagesBucket      = []byte("messages")
+	boltAckIDsBucket        = []byte("ackIds")
+)
+
+// boltBody is a published message body shared by the delivery records of
+// every subscription it was fanned out to
+type boltBody struct {
+	Message Message `json:"message"`
+	Refs    int     `json:"refs"`
+}
+
+// boltDelivery is a subscription's delivery record for a message
+type boltDelivery struct {
+	BodyKey          string    `json:"bodyKey"`
+	AckID            string    `json:"ackId"`
+	DeadlineAt       time.Time `json:"deadlineAt"`
+	DeliveryAttempts int       `json:"deliveryAttempts,omitempty"`
+}
+
+// BoltStorage is a Backend persisted in an embedded bbolt database. Every
+// operation is a single transaction, so state survives restarts and crashes.
+type BoltStorage struct {
+	db     *bolt.DB
+	events eventBus
+	clock  Clock
+	ids    IDGenerator
+}
+
+// OpenBoltStorage opens or creates a database file at path
+func OpenBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltTopicsBucket, boltSubscriptionsBucket, boltBodiesBucket, boltBacklogsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	return &BoltStorage{db: db, clock: systemClock{}, ids: randomIDs{}}, nil
+}
+
+// Close closes the database file
+func (b *BoltStorage) Close() error {
+	return b.db.Close()
+}
+
+// Observe registers an observer for message events. Events are emitted once
+// the transaction that caused them has been committed.
+func (b *BoltStorage) Observe(fn Observer) {
+	b.events.add(fn)
+}
+
+// Clock returns the clock the storage reads time from
+func (b *BoltStorage) Clock() Clock {
+	return b.clock
+}
+
+// SetClock replaces the clock. It must be called before the storage is used.
+func (b *BoltStorage) SetClock(clock Clock) {
+	b.clock = clock
+}
+
+// SetIDGenerator replaces the generator of message and ack IDs. It must be
+// called before the storage is used.
+func (b *BoltStorage) SetIDGenerator(ids IDGenerator) {
+	if seq, ok := ids.(*SequentialIDs); ok {
+		b.db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(boltBodiesBucket).ForEach(func(key, _ []byte) error {
+				seq.skipPast(boltMessageID(string(key)))
+				return nil
+			})
+		})
+	}
+	b.ids = ids
+}
+
+// CreateTopic creates a new topic
+func (b *BoltStorage) CreateTopic(name string) (*Topic, error) {
+	topic := &Topic{Name: name}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		topics := tx.Bucket(boltTopicsBucket)
+		if topics.Get([]byte(name)) != nil {
+			return ErrTopicAlreadyExists
+		}
+		return putJSON(topics, []byte(name), topic)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return topic, nil
+}
+
+// GetTopic retrieves a topic by name
+func (b *BoltStorage) GetTopic(name string) (*Topic, error) {
+	var topic Topic
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltTopicsBucket).Get([]byte(name))
+		if data == nil {
+			return ErrTopicNotFound
+		}
+		return json.Unmarshal(data, &topic)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &topic, nil
+}
+
+// DeleteTopic deletes a topic
+func (b *BoltStorage) DeleteTopic(name string) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		topics := tx.Bucket(boltTopicsBucket)
+		if topics.Get([]byte(name)) == nil {
+			return ErrTopicNotFound
+		}
+		return topics.Delete([]byte(name))
+	})
+	if err != nil {
+		return err
+	}
+	b.events.emit(Event{Kind: EventDelete, Time: b.clock.Now(), Topic: name})
+	return nil
+}
+
+// ListTopics returns all topics
+func (b *BoltStorage) ListTopics() []*Topic {
+	topics := make([]*Topic, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTopicsBucket).ForEach(func(_, data []byte) error {
+			var topic Topic
+			if err := json.Unmarshal(data, &topic); err != nil {
+				return err
+			}
+			topics = append(topics, &topic)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Error("failed to list topics",
+			"operation", "list_topics",
+			"error", err.Error())
+	}
+	return topics
+}
+
+// CreateSubscription creates a new subscription
+func (b *BoltStorage) CreateSubscription(name, topicName string) (*Subscription, error) {
+	subscription := &Subscription{Name: name, Topic: topicName}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		subs := tx.Bucket(boltSubscriptionsBucket)
+		if subs.Get([]byte(name)) != nil {
+			return ErrSubscriptionAlreadyExists
+		}
+		if tx.Bucket(boltTopicsBucket).Get([]byte(topicName)) == nil {
+			return ErrTopicNotFound
+		}
+
+		backlog, err := tx.Bucket(boltBacklogsBucket).CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		}
+		if _, err := backlog.CreateBucket(boltMessagesBucket); err != nil {
+			return err
+		}
+		if _, err := backlog.CreateBucket(boltAckIDsBucket); err != nil {
+			return err
+		}
+		return putJSON(subs, []byte(name), subscription)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// GetSubscription retrieves a subscription by name
+func (b *BoltStorage) GetSubscription(name string) (*Subscription, error) {
+	var subscription Subscription
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltSubscriptionsBucket).Get([]byte(name))
+		if data == nil {
+			return ErrSubscriptionNotFound
+		}
+		return json.Unmarshal(data, &subscription)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// DeleteSubscription deletes a subscription
+func (b *BoltStorage) DeleteSubscription(name string) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltSubscriptionsBucket).Get([]byte(name)) == nil {
+			return ErrSubscriptionNotFound
+		}
+		return deleteBoltSubscription(tx, []byte(name))
+	})
+	if err != nil {
+		return err
+	}
+	b.events.emit(Event{Kind: EventDelete, Time: b.clock.Now(), Subscription: name})
+	return nil
+}
+
+// deleteBoltSubscription removes a subscription and releases the bodies of
+// its backlog
+func deleteBoltSubscription(tx *bolt.Tx, name []byte) error {
+	backlogs := tx.Bucket(boltBacklogsBucket)
+	err := backlogs.Bucket(name).Bucket(boltMessagesBucket).ForEach(func(_, data []byte) error {
+		var delivery boltDelivery
+		if err := json.Unmarshal(data, &delivery); err != nil {
+			return err
+		}
+		return releaseBoltBody(tx, delivery.BodyKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := backlogs.DeleteBucket(name); err != nil {
+		return err
+	}
+	return tx.Bucket(boltSubscriptionsBucket).Delete(name)
+}
+
+// ListSubscriptions returns all subscriptions
+func (b *BoltStorage) ListSubscriptions() []*Subscription {
+	subscriptions := make([]*Subscription, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSubscriptionsBucket).ForEach(func(_, data []byte) error {
+			var subscription Subscription
+			if err := json.Unmarshal(data, &subscription); err != nil {
+				return err
+			}
+			subscriptions = append(subscriptions, &subscription)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Error("failed to list subscriptions",
+			"operation", "list_subscriptions",
+			"error", err.Error())
+	}
+	return subscriptions
+}
+
+// Publish publishes messages to a topic
+func (b *BoltStorage) Publish(topicName string, messages []PubSubMessage) ([]string, error) {
+	messageIDs := make([]string, len(messages))
+	now := b.clock.Now()
+	var subNames []string
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltTopicsBucket).Get([]byte(topicName)) == nil {
+			return ErrTopicNotFound
+		}
+
+		// Find all subscriptions for this topic
+		err := tx.Bucket(boltSubscriptionsBucket).ForEach(func(key, data []byte) error {
+			var subscription Subscription
+			if err := json.Unmarshal(data, &subscription); err != nil {
+				return err
+			}
+			if subscription.Topic == topicName {
+				subNames = append(subNames, subscription.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		bodies := tx.Bucket(boltBodiesBucket)
+		backlogs := tx.Bucket(boltBacklogsBucket)
+
+		for i, pubsubMsg := range messages {
+			messageIDs[i] = b.ids.MessageID()
+			if len(subNames) == 0 {
+				continue
+			}
+
+			// Store the body once, referenced by every subscription
+			bodyKey := topicName + "\n" + messageIDs[i]
+			body := boltBody{
+				Message: Message{
+					Data:        pubsubMsg.Data,
+					Attributes:  pubsubMsg.Attributes,
+					MessageID:   messageIDs[i],
+					PublishTime: now.Format(time.RFC3339),
+				},
+				Refs: len(subNames),
+			}
+			if err := putJSON(bodies, []byte(bodyKey), body); err != nil {
+				return err
+			}
+
+			for _, subName := range subNames {
+				backlog := backlogs.Bucket([]byte(subName))
+				delivery := boltDelivery{BodyKey: bodyKey, AckID: b.ids.AckID(subName, messageIDs[i])}
+				if err := appendBoltDelivery(backlog, delivery); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if b.events.active() {
+		for i, pubsubMsg := range messages {
+			b.events.emit(Event{
+				Kind:      EventPublish,
+				Time:      now,
+				Topic:     topicName,
+				MessageID: messageIDs[i],
+				Fanout:    subNames,
+				Message: &Message{
+					Data:        pubsubMsg.Data,
+					Attributes:  maps.Clone(pubsubMsg.Attributes),
+					MessageID:   messageIDs[i],
+					PublishTime: now.Format(time.RFC3339),
+				},
+			})
+		}
+	}
+	return messageIDs, nil
+}
+
+// Pull retrieves messages from a subscription
+func (b *BoltStorage) Pull(subscriptionName string, maxMessages int) ([]ReceivedMessage, error) {
+	receivedMessages := make([]ReceivedMessage, 0, maxMessages)
+	var events []Event
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		backlog := tx.Bucket(boltBacklogsBucket).Bucket([]byte(subscriptionName))
+		if backlog == nil {
+			return ErrSubscriptionNotFound
+		}
+
+		now := b.clock.Now()
+		deadline := now.Add(ackDeadline())
+		bodies := tx.Bucket(boltBodiesBucket)
+		messages := backlog.Bucket(boltMessagesBucket)
+
+		type lease struct {
+			key      []byte
+			delivery boltDelivery
+		}
+		var leases []lease
+
+		cursor := messages.Cursor()
+		for key, data := cursor.First(); key != nil && len(leases) < maxMessages; key, data = cursor.Next() {
+			var delivery boltDelivery
+			if err := json.Unmarshal(data, &delivery); err != nil {
+				return err
+			}
+			if !delivery.DeadlineAt.Before(now) {
+				continue
+			}
+
+			var body boltBody
+			if err := json.Unmarshal(bodies.Get([]byte(delivery.BodyKey)), &body); err != nil {
+				return err
+			}
+			receivedMessages = append(receivedMessages, ReceivedMessage{
+				AckID:   delivery.AckID,
+				Message: body.Message,
+			})
+
+			events = appendDeliveryEvents(events, subscriptionName, body.Message.MessageID, delivery.AckID,
+				delivery.DeliveryAttempts, delivery.DeadlineAt, deadline, now)
+			delivery.DeadlineAt = deadline
+			delivery.DeliveryAttempts++
+			leases = append(leases, lease{key: append([]byte(nil), key...), delivery: delivery})
+		}
+
+		// Write back after iterating; modifying a bucket invalidates its cursor
+		for _, l := range leases {
+			if err := putJSON(messages, l.key, l.delivery); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range events {
+		b.events.emit(e)
+	}
+	return receivedMessages, nil
+}
+
+// Acknowledge acknowledges messages
+func (b *BoltStorage) Acknowledge(subscriptionName string, ackIDs []string) error {
+	var events []Event
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		backlog := tx.Bucket(boltBacklogsBucket).Bucket([]byte(subscriptionName))
+		if backlog == nil {
+			return ErrSubscriptionNotFound
+		}
+		var err error
+		events, err = acknowledgeBolt(tx, backlog, subscriptionName, ackIDs)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	b.emitAll(events)
+	return nil
+}
+
+// Purge acknowledges every message in a subscription's backlog, leased or
+// not, and returns how many there were
+func (b *BoltStorage) Purge(subscriptionName string) (int, error) {
+	var events []Event
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		backlog := tx.Bucket(boltBacklogsBucket).Bucket([]byte(subscriptionName))
+		if backlog == nil {
+			return ErrSubscriptionNotFound
+		}
+		var ackIDs []string
+		backlog.Bucket(boltAckIDsBucket).ForEach(func(ackID, _ []byte) error {
+			ackIDs = append(ackIDs, string(ackID))
+			return nil
+		})
+		var err error
+		events, err = acknowledgeBolt(tx, backlog, subscriptionName, ackIDs)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	b.emitAll(events)
+	return len(events), nil
+}
+
+// acknowledgeBolt deletes acknowledged messages from a backlog and returns
+// their ack events
+func acknowledgeBolt(tx *bolt.Tx, backlog *bolt.Bucket, subscriptionName string, ackIDs []string) ([]Event, error) {
+	var events []Event
+	messages := backlog.Bucket(boltMessagesBucket)
+	index := backlog.Bucket(boltAckIDsBucket)
+	for _, ackID := range ackIDs {
+		key := index.Get([]byte(ackID))
+		if key == nil {
+			continue
+		}
+		key = append([]byte(nil), key...)
+
+		var delivery boltDelivery
+		if err := json.Unmarshal(messages.Get(key), &delivery); err != nil {
+			return nil, err
+		}
+		if err := releaseBoltBody(tx, delivery.BodyKey); err != nil {
+			return nil, err
+		}
+		if err := messages.Delete(key); err != nil {
+			return nil, err
+		}
+		if err := index.Delete([]byte(ackID)); err != nil {
+			return nil, err
+		}
+		events = append(events, Event{
+			Kind:         EventAck,
+			Subscription: subscriptionName,
+			MessageID:    boltMessageID(delivery.BodyKey),
+			AckID:        ackID,
+		})
+	}
+	return events, nil
+}
+
+// ModifyAckDeadline modifies the acknowledgement deadline for messages
+func (b *BoltStorage) ModifyAckDeadline(subscriptionName string, ackIDs []string, ackDeadlineSeconds int) error {
+	kind := EventModAck
+	if ackDeadlineSeconds == 0 {
+		kind = EventNack
+	}
+
+	var events []Event
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		backlog := tx.Bucket(boltBacklogsBucket).Bucket([]byte(subscriptionName))
+		if backlog == nil {
+			return ErrSubscriptionNotFound
+		}
+
+		// If ackDeadlineSeconds is 0, make the message immediately available for redelivery
+		deadline := time.Time{} // Zero time, always in the past
+		if ackDeadlineSeconds != 0 {
+			deadline = b.clock.Now().Add(time.Duration(ackDeadlineSeconds) * time.Second)
+		}
+
+		messages := backlog.Bucket(boltMessagesBucket)
+		index := backlog.Bucket(boltAckIDsBucket)
+		for _, ackID := range ackIDs {
+			key := index.Get([]byte(ackID))
+			if key == nil {
+				continue
+			}
+			key = append([]byte(nil), key...)
+
+			var delivery boltDelivery
+			if err := json.Unmarshal(messages.Get(key), &delivery); err != nil {
+				return err
+			}
+			delivery.DeadlineAt = deadline
+			if err := putJSON(messages, key, delivery); err != nil {
+				return err
+			}
+			events = append(events, Event{
+				Kind:         kind,
+				Subscription: subscriptionName,
+				MessageID:    boltMessageID(delivery.BodyKey),
+				AckID:        ackID,
+				Deadline:     deadline,
+			})
+		}
+
+		if len(events) == 0 {
+			return fmt.Errorf("no matching messages found for provided ack IDs")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b.emitAll(events)
+	return nil
+}
+
+// BacklogStats summarizes the unacknowledged messages of a subscription.
+// The backlog is kept in publish order, so the first record is the oldest.
+func (b *BoltStorage) BacklogStats(subscriptionName string) (*BacklogStats, error) {
+	stats := &BacklogStats{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		backlog := tx.Bucket(boltBacklogsBucket).Bucket([]byte(subscriptionName))
+		if backlog == nil {
+			return ErrSubscriptionNotFound
+		}
+
+		now := b.clock.Now()
+		return backlog.Bucket(boltMessagesBucket).ForEach(func(_, data []byte) error {
+			var delivery boltDelivery
+			if err := json.Unmarshal(data, &delivery); err != nil {
+				return err
+			}
+			stats.Backlog++
+			if !delivery.DeadlineAt.Before(now) {
+				stats.Leased++
+			}
+			if stats.Backlog > 1 {
+				return nil
+			}
+
+			var body boltBody
+			if err := json.Unmarshal(tx.Bucket(boltBodiesBucket).Get([]byte(delivery.BodyKey)), &body); err != nil {
+				return err
+			}
+			if publishTime, err := time.Parse(time.RFC3339, body.Message.PublishTime); err == nil {
+				stats.OldestUnackedAt = publishTime
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Reset deletes every topic and subscription of a project, or everything if
+// project is empty
+func (b *BoltStorage) Reset(project string) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var subNames, topicNames [][]byte
+		err := tx.Bucket(boltSubscriptionsBucket).ForEach(func(key, _ []byte) error {
+			if inProject(string(key), project) {
+				subNames = append(subNames, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(boltTopicsBucket).ForEach(func(key, _ []byte) error {
+			if inProject(string(key), project) {
+				topicNames = append(topicNames, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range subNames {
+			if err := deleteBoltSubscription(tx, name); err != nil {
+				return err
+			}
+		}
+		for _, name := range topicNames {
+			if err := tx.Bucket(boltTopicsBucket).Delete(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b.events.emit(Event{Kind: EventReset, Time: b.clock.Now(), Project: project})
+	return nil
+}
+
+// emitAll stamps events with the current time and emits them
+func (b *BoltStorage) emitAll(events []Event) {
+	now := b.clock.Now()
+	for _, e := range events {
+		e.Time = now
+		b.events.emit(e)
+	}
+}
+
+// boltMessageID returns the message ID part of a body key
+func boltMessageID(bodyKey string) string {
+	_, messageID, _ := strings.Cut(bodyKey, "\n")
+	return messageID
+}
+
+// appendBoltDelivery adds a delivery record to the end of a backlog
+func appendBoltDelivery(backlog *bolt.Bucket, delivery boltDelivery) error {
+	messages := backlog.Bucket(boltMessagesBucket)
+	seq, err := messages.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+
+	if err := putJSON(messages, key, delivery); err != nil {
+		return err
+	}
+	return backlog.Bucket(boltAckIDsBucket).Put([]byte(delivery.AckID), key)
+}
+
+// releaseBoltBody drops one reference to a body and deletes it once unused
+func releaseBoltBody(tx *bolt.Tx, bodyKey string) error {
+	bodies := tx.Bucket(boltBodiesBucket)
+	data := bodies.Get([]byte(bodyKey))
+	if data == nil {
+		return errors.New("message body missing from database")
+	}
+
+	var body boltBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+	body.Refs--
+	if body.Refs <= 0 {
+		return bodies.Delete([]byte(bodyKey))
+	}
+	return putJSON(bodies, []byte(bodyKey), body)
+}
+
+func putJSON(bucket *bolt.Bucket, key []byte, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
@@ -0,0 +1,215 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// ChaosPolicy makes a subscription's deliveries misbehave in the ways Pub/Sub
+// is allowed to. All randomness comes from an RNG seeded with Seed, so a
+// consumer that makes the same calls in the same order sees the same
+// deliveries.
+type ChaosPolicy struct {
+	Seed uint64 `json:"seed"` // 0 picks a random seed, reported back
+
+	// DuplicateProbability is the chance that an ack is lost: the call
+	// succeeds, but the message is delivered again
+	DuplicateProbability float64 `json:"duplicateProbability,omitempty"`
+	// Reorder delivers available messages in random order
+	Reorder bool `json:"reorder,omitempty"`
+	// MaxVisibilityDelay makes each new message visible only after a random
+	// delay of up to this duration, e.g. "5s"
+	MaxVisibilityDelay string `json:"maxVisibilityDelay,omitempty"`
+	// EarlyExpiryProbability is the chance that a lease expires after a
+	// random fraction of the ack deadline
+	EarlyExpiryProbability float64 `json:"earlyExpiryProbability,omitempty"`
+
+	maxVisibilityDelay time.Duration
+}
+
+// ErrInvalidChaosPolicy is wrapped by the errors of an invalid policy
+var ErrInvalidChaosPolicy = errors.New("invalid chaos policy")
+
+// validate checks a policy and parses its durations
+func (p *ChaosPolicy) validate() error {
+	for _, probability := range []float64{p.DuplicateProbability, p.EarlyExpiryProbability} {
+		if probability < 0 || probability > 1 {
+			return fmt.Errorf("%w: probabilities must be between 0 and 1", ErrInvalidChaosPolicy)
+		}
+	}
+	if p.MaxVisibilityDelay != "" {
+		delay, err := time.ParseDuration(p.MaxVisibilityDelay)
+		if err != nil || delay < 0 {
+			return fmt.Errorf("%w: invalid maxVisibilityDelay %q", ErrInvalidChaosPolicy, p.MaxVisibilityDelay)
+		}
+		p.maxVisibilityDelay = delay
+	}
+	return nil
+}
+
+// ChaosController is implemented by backends that support chaos policies
+type ChaosController interface {
+	// SetChaosPolicy installs a policy on a subscription, replacing any
+	// earlier one and restarting its RNG, and returns it with the seed used.
+	// A nil policy turns chaos off.
+	SetChaosPolicy(subscriptionName string, policy *ChaosPolicy) (*ChaosPolicy, error)
+	// ChaosPolicy returns the policy of a subscription, or nil if it has none
+	ChaosPolicy(subscriptionName string) (*ChaosPolicy, error)
+}
+
+var _ ChaosController = (*Storage)(nil)
+
+// chaosState is the chaos policy of a subscription together with its RNG.
+// It is guarded by the subscription lock.
+type chaosState struct {
+	policy    ChaosPolicy
+	rng       *rand.Rand
+	visibleAt map[string]time.Time // by ack ID, for messages not yet delivered
+}
+
+func newChaosState(policy ChaosPolicy) *chaosState {
+	return &chaosState{
+		policy:    policy,
+		rng:       rand.New(rand.NewPCG(policy.Seed, policy.Seed)),
+		visibleAt: make(map[string]time.Time),
+	}
+}
+
+// deliverable picks the messages Pull hands out from the available ones:
+// messages still within their visibility delay are held back, and the rest
+// are shuffled if reordering is on
+func (c *chaosState) deliverable(available []*InternalMessage, maxMessages int, now time.Time) []*InternalMessage {
+	if c.policy.maxVisibilityDelay > 0 {
+		visibleAt := make(map[string]time.Time, len(c.visibleAt))
+		visible := available[:0]
+		for _, msg := range available {
+			if msg.DeliveryAttempts > 0 {
+				visible = append(visible, msg)
+				continue
+			}
+			at, ok := c.visibleAt[msg.AckID]
+			if !ok {
+				at = now.Add(time.Duration(c.rng.Int64N(int64(c.policy.maxVisibilityDelay) + 1)))
+			}
+			if at.After(now) {
+				visibleAt[msg.AckID] = at
+			} else {
+				visible = append(visible, msg)
+			}
+		}
+		// Rebuilt on every pull so that acked or deleted messages drop out
+		c.visibleAt = visibleAt
+		available = visible
+	}
+
+	if c.policy.Reorder {
+		c.rng.Shuffle(len(available), func(i, j int) {
+			available[i], available[j] = available[j], available[i]
+		})
+	}
+	if len(available) > maxMessages {
+		available = available[:maxMessages]
+	}
+	return available
+}
+
+// leaseDeadline returns the deadline of a new lease, cut short at random
+func (c *chaosState) leaseDeadline(deadline, now time.Time) time.Time {
+	if c.policy.EarlyExpiryProbability > 0 && c.rng.Float64() < c.policy.EarlyExpiryProbability {
+		return now.Add(time.Duration(c.rng.Int64N(int64(deadline.Sub(now)) + 1)))
+	}
+	return deadline
+}
+
+// splitAcks divides the ack IDs of an Acknowledge call into those to apply
+// and those to lose
+func (c *chaosState) splitAcks(ackIDs []string) (kept, lost []string) {
+	if c.policy.DuplicateProbability == 0 {
+		return ackIDs, nil
+	}
+	for _, id := range ackIDs {
+		if c.rng.Float64() < c.policy.DuplicateProbability {
+			lost = append(lost, id)
+		} else {
+			kept = append(kept, id)
+		}
+	}
+	return kept, lost
+}
+
+// loseAcks ends the leases of messages whose acks were lost, so that they are
+// redelivered by the next pull. The caller must hold the subscription lock.
+func (s *Storage) loseAcks(state *subscriptionState, ackIDs []string) error {
+	if len(ackIDs) == 0 {
+		return nil
+	}
+	lost := make(map[string]bool, len(ackIDs))
+	for _, id := range ackIDs {
+		lost[id] = true
+	}
+
+	rec := &walRecord{Op: walOpLease, Name: state.subscription.Name}
+	for _, msg := range state.messages {
+		if lost[msg.AckID] && msg.AckedAt == nil {
+			rec.Leases = append(rec.Leases, walLease{AckID: msg.AckID, DeadlineAt: time.Time{}}) // Zero time, always in the past
+		}
+	}
+	if len(rec.Leases) == 0 {
+		return nil
+	}
+	if err := s.record(rec); err != nil {
+		return err
+	}
+	applyLeases(state, rec.Leases)
+	return nil
+}
+
+// SetChaosPolicy installs or removes the chaos policy of a subscription.
+// Policies are runtime settings and are neither journaled nor checkpointed.
+func (s *Storage) SetChaosPolicy(subscriptionName string, policy *ChaosPolicy) (*ChaosPolicy, error) {
+	if policy != nil {
+		if err := policy.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, err := s.lockSubscription(subscriptionName)
+	if err != nil {
+		return nil, err
+	}
+	defer state.mu.Unlock()
+
+	if policy == nil {
+		state.chaos = nil
+		return nil, nil
+	}
+	installed := *policy
+	if installed.Seed == 0 {
+		installed.Seed = rand.Uint64()
+	}
+	state.chaos = newChaosState(installed)
+	return &installed, nil
+}
+
+// ChaosPolicy returns the chaos policy of a subscription
+func (s *Storage) ChaosPolicy(subscriptionName string) (*ChaosPolicy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, err := s.lockSubscription(subscriptionName)
+	if err != nil {
+		return nil, err
+	}
+	defer state.mu.Unlock()
+
+	if state.chaos == nil {
+		return nil, nil
+	}
+	policy := state.chaos.policy
+	return &policy, nil
+}
@@ -0,0 +1,221 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newChaosStorage creates a storage with topic1 and sub1 and the given policy
+// on sub1
+func newChaosStorage(t *testing.T, policy ChaosPolicy) *Storage {
+	t.Helper()
+	storage := NewStorage()
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	if _, err := storage.SetChaosPolicy("projects/test/subscriptions/sub1", &policy); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return storage
+}
+
+func publishNumbered(storage Backend, n int) []string {
+	messages := make([]PubSubMessage, n)
+	for i := range messages {
+		messages[i] = PubSubMessage{Data: EncodeData([]byte{byte(i)})}
+	}
+	ids, _ := storage.Publish("projects/test/topics/topic1", messages)
+	return ids
+}
+
+func pulledIDs(messages []ReceivedMessage) []string {
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.Message.MessageID
+	}
+	return ids
+}
+
+func TestChaos_ReorderIsReproducible(t *testing.T) {
+	var orders [][]int
+	for i := 0; i < 2; i++ {
+		storage := newChaosStorage(t, ChaosPolicy{Seed: 42, Reorder: true})
+		ids := publishNumbered(storage, 20)
+
+		pulled, err := storage.Pull("projects/test/subscriptions/sub1", 20)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled) != 20 {
+			t.Fatalf("Expected 20 messages, got %d", len(pulled))
+		}
+		var order []int
+		for _, id := range pulledIDs(pulled) {
+			order = append(order, slices.Index(ids, id))
+		}
+		orders = append(orders, order)
+	}
+
+	if !slices.Equal(orders[0], orders[1]) {
+		t.Errorf("Expected the same order for the same seed, got %v and %v", orders[0], orders[1])
+	}
+	if slices.IsSorted(orders[0]) {
+		t.Errorf("Expected messages out of publish order, got %v", orders[0])
+	}
+}
+
+func TestChaos_Duplicates(t *testing.T) {
+	storage := newChaosStorage(t, ChaosPolicy{Seed: 1, DuplicateProbability: 1})
+	ids := publishNumbered(storage, 1)
+
+	pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 1)
+	if err := storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID}); err != nil {
+		t.Fatalf("Expected the lost ack to succeed, got %v", err)
+	}
+
+	pulled, _ = storage.Pull("projects/test/subscriptions/sub1", 1)
+	if len(pulled) != 1 || pulled[0].Message.MessageID != ids[0] {
+		t.Fatalf("Expected the acked message to be redelivered, got %v", pulledIDs(pulled))
+	}
+
+	// Without chaos the ack sticks
+	storage.SetChaosPolicy("projects/test/subscriptions/sub1", nil)
+	storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID})
+	time.Sleep(ackDeadline() + 10*time.Millisecond)
+	if pulled, _ = storage.Pull("projects/test/subscriptions/sub1", 1); len(pulled) != 0 {
+		t.Errorf("Expected no redelivery, got %v", pulledIDs(pulled))
+	}
+}
+
+func TestChaos_DuplicatesWithVirtualClock(t *testing.T) {
+	storage := newChaosStorage(t, ChaosPolicy{Seed: 1, DuplicateProbability: 1})
+	storage.SetClock(NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ids := publishNumbered(storage, 1)
+
+	// The clock stands still, so the lost ack must not wait for it to move
+	pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 1)
+	storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID})
+	pulled, _ = storage.Pull("projects/test/subscriptions/sub1", 1)
+	if len(pulled) != 1 || pulled[0].Message.MessageID != ids[0] {
+		t.Fatalf("Expected the acked message to be redelivered, got %v", pulledIDs(pulled))
+	}
+}
+
+func TestChaos_VisibilityDelay(t *testing.T) {
+	storage := newChaosStorage(t, ChaosPolicy{Seed: 7, MaxVisibilityDelay: "100ms"})
+	publishNumbered(storage, 10)
+
+	// Leases expire quickly in tests, so count distinct messages
+	seen := make(map[string]bool)
+	deadline := time.Now().Add(time.Second)
+	first, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+	for _, id := range pulledIDs(first) {
+		seen[id] = true
+	}
+	for len(seen) < 10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+		for _, id := range pulledIDs(pulled) {
+			seen[id] = true
+		}
+	}
+
+	if len(first) == 10 {
+		t.Error("Expected some messages to be delayed")
+	}
+	if len(seen) != 10 {
+		t.Errorf("Expected all messages to become visible, got %d", len(seen))
+	}
+}
+
+func TestChaos_EarlyExpiry(t *testing.T) {
+	storage := newChaosStorage(t, ChaosPolicy{Seed: 3, EarlyExpiryProbability: 1})
+	publishNumbered(storage, 10)
+
+	before := time.Now()
+	storage.Pull("projects/test/subscriptions/sub1", 10)
+
+	peeked, _, _ := storage.PeekMessages("projects/test/subscriptions/sub1", PeekFilter{}, 0, "")
+	early := 0
+	for _, msg := range peeked {
+		if msg.DeadlineAt == nil {
+			t.Fatalf("Expected message %s to be leased", msg.MessageID)
+		}
+		if msg.DeadlineAt.Before(before.Add(ackDeadline())) {
+			early++
+		}
+	}
+	if early != 10 {
+		t.Errorf("Expected all 10 leases to be cut short, got %d", early)
+	}
+}
+
+func TestHandleChaosPolicy(t *testing.T) {
+	server := NewServer()
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	path := "/admin/v1/projects/test/subscriptions/sub1/chaos"
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return w
+	}
+
+	if w := do(http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d without a policy, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w := do(http.MethodPut, path, `{"reorder": true, "duplicateProbability": 0.1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var installed ChaosPolicy
+	json.Unmarshal(w.Body.Bytes(), &installed)
+	if installed.Seed == 0 || !installed.Reorder {
+		t.Errorf("Expected the policy with a generated seed, got %+v", installed)
+	}
+
+	w = do(http.MethodGet, path, "")
+	var got ChaosPolicy
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != http.StatusOK || got.Seed != installed.Seed {
+		t.Errorf("Expected the installed policy, got %d %+v", w.Code, got)
+	}
+
+	for _, body := range []string{`{"duplicateProbability": 1.5}`, `{"maxVisibilityDelay": "later"}`, `nope`} {
+		if w := do(http.MethodPut, path, body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+	if w := do(http.MethodPut, "/admin/v1/projects/test/subscriptions/missing/chaos", `{}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing subscription, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if w := do(http.MethodDelete, path, ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := do(http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d after delete, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandleChaosPolicy_Unsupported(t *testing.T) {
+	storage, err := OpenBoltStorage(filepath.Join(t.TempDir(), "pubsub.db"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer storage.Close()
+	server := NewServerWithStorage(storage)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/admin/v1/projects/test/subscriptions/sub1/chaos", bytes.NewBufferString(`{}`)))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, w.Code)
+	}
+}
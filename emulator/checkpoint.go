@@ -0,0 +1,69 @@
+package emulator
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+// Checkpointer is implemented by backends that can capture their complete
+// state under a name and restore it later
+type Checkpointer interface {
+	SaveCheckpoint(name string) error
+	RestoreCheckpoint(name string) error
+	DeleteCheckpoint(name string) error
+	ListCheckpoints() []string
+}
+
+var _ Checkpointer = (*Storage)(nil)
+
+// SaveCheckpoint captures all topics, subscriptions and backlogs, including
+// leases and delivery counts, replacing any checkpoint of the same name
+func (s *Storage) SaveCheckpoint(name string) error {
+	s.mu.Lock()
+	snap := s.snapshot()
+	s.mu.Unlock()
+
+	s.checkpointsMu.Lock()
+	defer s.checkpointsMu.Unlock()
+	s.checkpoints[name] = snap
+	return nil
+}
+
+// RestoreCheckpoint atomically replaces the current state with a checkpoint
+func (s *Storage) RestoreCheckpoint(name string) error {
+	s.checkpointsMu.Lock()
+	snap, exists := s.checkpoints[name]
+	s.checkpointsMu.Unlock()
+	if !exists {
+		return ErrCheckpointNotFound
+	}
+
+	return s.replaceState(func() *StateSnapshot { return snap })
+}
+
+// DeleteCheckpoint forgets a checkpoint
+func (s *Storage) DeleteCheckpoint(name string) error {
+	s.checkpointsMu.Lock()
+	defer s.checkpointsMu.Unlock()
+
+	if _, exists := s.checkpoints[name]; !exists {
+		return ErrCheckpointNotFound
+	}
+	delete(s.checkpoints, name)
+	return nil
+}
+
+// ListCheckpoints returns the names of all checkpoints in order
+func (s *Storage) ListCheckpoints() []string {
+	s.checkpointsMu.Lock()
+	defer s.checkpointsMu.Unlock()
+
+	names := make([]string, 0, len(s.checkpoints))
+	for name := range s.checkpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,136 @@
+package emulator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStorage_SaveAndRestoreCheckpoint(t *testing.T) {
+	storage := NewStorage()
+
+	// Setup fixture: one leased message and one fresh message
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdDE="}})
+	leased, _ := storage.Pull("projects/test/subscriptions/sub1", 1)
+	storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{leased[0].AckID}, 60)
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdDI="}})
+
+	if err := storage.SaveCheckpoint("fixture"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Run a "test" that consumes and changes everything
+	pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+	storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID, leased[0].AckID})
+	storage.CreateTopic("projects/test/topics/topic2")
+
+	if err := storage.RestoreCheckpoint("fixture"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := storage.GetTopic("projects/test/topics/topic2"); err != ErrTopicNotFound {
+		t.Errorf("Expected topic created after checkpoint to be gone, got %v", err)
+	}
+
+	// Only the fresh message is visible; the other is still leased
+	pulled, _ = storage.Pull("projects/test/subscriptions/sub1", 10)
+	if len(pulled) != 1 {
+		t.Fatalf("Expected 1 message after restore, got %d", len(pulled))
+	}
+	if pulled[0].Message.Data != "dGVzdDI=" {
+		t.Errorf("Expected data 'dGVzdDI=', got %s", pulled[0].Message.Data)
+	}
+
+	// Delivery counts are part of the checkpoint
+	state := storage.subscriptions["projects/test/subscriptions/sub1"]
+	if attempts := state.messages[0].DeliveryAttempts; attempts != 1 {
+		t.Errorf("Expected 1 delivery attempt for the leased message, got %d", attempts)
+	}
+
+	// A checkpoint can be restored repeatedly
+	if err := storage.RestoreCheckpoint("fixture"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{leased[0].AckID}, 0); err != nil {
+		t.Errorf("Expected original ack ID to be valid after restore, got %v", err)
+	}
+}
+
+func TestStorage_RestoreCheckpoint_NotFound(t *testing.T) {
+	storage := NewStorage()
+
+	if err := storage.RestoreCheckpoint("missing"); err != ErrCheckpointNotFound {
+		t.Errorf("Expected ErrCheckpointNotFound, got %v", err)
+	}
+	if err := storage.DeleteCheckpoint("missing"); err != ErrCheckpointNotFound {
+		t.Errorf("Expected ErrCheckpointNotFound, got %v", err)
+	}
+}
+
+func TestOpenStorage_RestoreCheckpointIsJournaled(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.SaveCheckpoint("fixture")
+	storage.CreateTopic("projects/test/topics/topic2")
+	storage.RestoreCheckpoint("fixture")
+
+	restored, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if topics := restored.ListTopics(); len(topics) != 1 {
+		t.Errorf("Expected 1 topic after replaying the restore, got %d", len(topics))
+	}
+}
+
+func TestHandleCheckpoints(t *testing.T) {
+	server := NewServer()
+	server.storage.CreateTopic("projects/test/topics/topic1")
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/v1/checkpoints/fixture", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	server.storage.DeleteTopic("projects/test/topics/topic1")
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/v1/checkpoints/fixture:restore", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if _, err := server.storage.GetTopic("projects/test/topics/topic1"); err != nil {
+		t.Errorf("Expected topic to be restored, got %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/v1/checkpoints", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/admin/v1/checkpoints/fixture", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/v1/checkpoints/fixture:restore", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,145 @@
+package emulator
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for a backend and everything that interprets
+// its timestamps
+type Clock interface {
+	Now() time.Time
+	// Every calls f with the time of each tick, every d, until stop is
+	// called. stop must be called exactly once.
+	Every(d time.Duration, f func(time.Time)) (stop func())
+}
+
+// systemClock is the real time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) Every(d time.Duration, f func(time.Time)) func() {
+	ticker := time.NewTicker(d)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				f(now)
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// ErrClockBackwards is returned when setting a virtual clock to an earlier time
+var ErrClockBackwards = errors.New("the clock cannot be set back")
+
+// VirtualClock is a clock whose time only moves when it is advanced or set,
+// which makes deadline-dependent behavior instant and deterministic
+type VirtualClock struct {
+	now     time.Time
+	tickers []*clockTicker // sorted by next tick
+	mu      sync.Mutex
+	moving  sync.Mutex // serializes Advance and Set
+}
+
+// clockTicker calls f every period
+type clockTicker struct {
+	due     time.Time // next tick
+	period  time.Duration
+	f       func(time.Time)
+	stopped bool
+}
+
+// NewVirtualClock creates a virtual clock starting at start
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+// Now returns the virtual time
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Every calls f for every tick of period d that Advance and Set move past,
+// in order and before they return. While f runs, Now returns the time of the
+// tick.
+func (c *VirtualClock) Every(d time.Duration, f func(time.Time)) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ticker := &clockTicker{due: c.now.Add(d), period: d, f: f}
+	c.insertLocked(ticker)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		ticker.stopped = true // not rescheduled if it is ticking right now
+		c.tickers = slices.DeleteFunc(c.tickers, func(other *clockTicker) bool { return other == ticker })
+	}
+}
+
+// insertLocked schedules a ticker after those due at the same time. The
+// caller must hold c.mu.
+func (c *VirtualClock) insertLocked(ticker *clockTicker) {
+	i := sort.Search(len(c.tickers), func(i int) bool { return c.tickers[i].due.After(ticker.due) })
+	c.tickers = slices.Insert(c.tickers, i, ticker)
+}
+
+// Advance moves the clock forward by d and fires the ticks that came due
+func (c *VirtualClock) Advance(d time.Duration) (time.Time, error) {
+	if d < 0 {
+		return time.Time{}, ErrClockBackwards
+	}
+	c.moving.Lock()
+	defer c.moving.Unlock()
+	return c.moveTo(c.Now().Add(d)), nil
+}
+
+// Set moves the clock forward to t and fires the ticks that came due
+func (c *VirtualClock) Set(t time.Time) (time.Time, error) {
+	c.moving.Lock()
+	defer c.moving.Unlock()
+	if t.Before(c.Now()) {
+		return time.Time{}, ErrClockBackwards
+	}
+	return c.moveTo(t), nil
+}
+
+// moveTo steps the time through the ticks due until t, in order, firing each
+// at its time, and then sets it to t. Tickers are called without c.mu held,
+// so that they can read the clock. The caller must hold c.moving.
+func (c *VirtualClock) moveTo(t time.Time) time.Time {
+	for {
+		c.mu.Lock()
+		if len(c.tickers) == 0 || c.tickers[0].due.After(t) {
+			c.now = t
+			c.mu.Unlock()
+			return t
+		}
+		ticker := c.tickers[0]
+		c.tickers = c.tickers[1:]
+		c.now = ticker.due
+		c.mu.Unlock()
+
+		ticker.f(ticker.due)
+
+		c.mu.Lock()
+		if !ticker.stopped {
+			ticker.due = ticker.due.Add(ticker.period)
+			c.insertLocked(ticker)
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,162 @@
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewVirtualClock(start)
+
+	if now := clock.Now(); !now.Equal(start) {
+		t.Errorf("Expected %v, got %v", start, now)
+	}
+
+	var ticks []time.Time
+	stop := clock.Every(10*time.Second, func(tick time.Time) { ticks = append(ticks, tick) })
+	defer stop()
+
+	clock.Advance(5 * time.Second)
+	if len(ticks) != 0 {
+		t.Error("Expected no tick before it is due")
+	}
+
+	clock.Advance(5 * time.Second)
+	if len(ticks) != 1 || !ticks[0].Equal(start.Add(10*time.Second)) {
+		t.Errorf("Expected a tick at its due time, got %v", ticks)
+	}
+
+	if _, err := clock.Set(start.Add(2 * time.Minute)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ticks) != 12 {
+		t.Errorf("Expected Set to fire the ticks that came due, got %d", len(ticks))
+	}
+
+	if _, err := clock.Set(start); err != ErrClockBackwards {
+		t.Errorf("Expected ErrClockBackwards, got %v", err)
+	}
+	if _, err := clock.Advance(-time.Second); err != ErrClockBackwards {
+		t.Errorf("Expected ErrClockBackwards, got %v", err)
+	}
+}
+
+func TestHandleClock(t *testing.T) {
+	server := NewServer()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return w
+	}
+
+	// The system clock cannot be moved
+	var resp ClockResponse
+	json.Unmarshal(do(http.MethodGet, "/admin/v1/clock", "").Body.Bytes(), &resp)
+	if resp.Virtual {
+		t.Error("Expected the system clock by default")
+	}
+	if w := do(http.MethodPost, "/admin/v1/clock:advance", `{"duration": "1s"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	server.storage.SetClock(NewVirtualClock(start))
+
+	w := do(http.MethodPost, "/admin/v1/clock:advance", `{"duration": "90s"}`)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || !resp.Virtual || !resp.Time.Equal(start.Add(90*time.Second)) {
+		t.Errorf("Expected the clock advanced by 90s, got %d %+v", w.Code, resp)
+	}
+
+	w = do(http.MethodPost, "/admin/v1/clock:set", `{"time": "2024-06-01T12:00:00Z"}`)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || !resp.Time.Equal(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the clock set, got %d %+v", w.Code, resp)
+	}
+
+	for path, body := range map[string]string{
+		"/admin/v1/clock:set":     `{"time": "2024-01-01T00:00:00Z"}`,
+		"/admin/v1/clock:advance": `{"duration": "soon"}`,
+	} {
+		if w := do(http.MethodPost, path, body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+}
+
+func TestSampler_VirtualClock(t *testing.T) {
+	storage := NewStorage()
+	clock := NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	storage.SetClock(clock)
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+	sampler := NewSampler(storage)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sampler.Run(ctx, time.Minute)
+
+	samples := func() int {
+		sampler.mu.Lock()
+		defer sampler.mu.Unlock()
+		return len(sampler.series["projects/test/subscriptions/sub1"])
+	}
+	waitFor := func(n int) {
+		deadline := time.Now().Add(time.Second)
+		for samples() < n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := samples(); got != n {
+			t.Fatalf("Expected %d samples, got %d", n, got)
+		}
+	}
+
+	waitFor(1)
+	clock.Advance(time.Minute)
+	waitFor(2)
+
+	// Every tick in the window is sampled before Advance returns
+	clock.Advance(3*time.Minute + 30*time.Second)
+	if got := samples(); got != 5 {
+		t.Fatalf("Expected 5 samples, got %d", got)
+	}
+	sampler.mu.Lock()
+	last := sampler.series["projects/test/subscriptions/sub1"][4].time
+	sampler.mu.Unlock()
+	if want := time.Date(2024, 1, 1, 0, 4, 0, 0, time.UTC); !last.Equal(want) {
+		t.Errorf("Expected the last sample at %v, got %v", want, last)
+	}
+}
+
+func TestVirtualClock_Every(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewVirtualClock(start)
+
+	var ticks, nows []time.Time
+	stop := clock.Every(time.Minute, func(tick time.Time) {
+		ticks = append(ticks, tick)
+		nows = append(nows, clock.Now())
+	})
+
+	clock.Advance(3 * time.Minute)
+	want := []time.Time{start.Add(time.Minute), start.Add(2 * time.Minute), start.Add(3 * time.Minute)}
+	if !slices.EqualFunc(ticks, want, time.Time.Equal) {
+		t.Errorf("Expected ticks %v, got %v", want, ticks)
+	}
+	if !slices.EqualFunc(nows, want, time.Time.Equal) {
+		t.Errorf("Expected the clock at each tick, got %v", nows)
+	}
+
+	stop()
+	clock.Advance(time.Hour)
+	if len(ticks) != 3 {
+		t.Errorf("Expected no ticks after stop, got %d", len(ticks))
+	}
+}
@@ -0,0 +1,293 @@
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BootstrapConfig declares resources to create at startup. It is read from
+// YAML or JSON (JSON being a subset of YAML):
+//
+//	projects:
+//	  - id: myproject
+//	    topics:
+//	      - name: orders
+//	        messages:
+//	          - data: hello
+//	            attributes: {source: bootstrap}
+//	    subscriptions:
+//	      - name: orders-worker
+//	        topic: orders
+type BootstrapConfig struct {
+	Projects []ProjectConfig `yaml:"projects"`
+}
+
+// ProjectConfig declares the resources of one project
+type ProjectConfig struct {
+	ID            string               `yaml:"id"`
+	Topics        []TopicConfig        `yaml:"topics"`
+	Subscriptions []SubscriptionConfig `yaml:"subscriptions"`
+	Schemas       []SchemaConfig       `yaml:"schemas"`
+}
+
+// TopicConfig declares a topic and the messages to seed it with when it is
+// first created
+type TopicConfig struct {
+	Name     string        `yaml:"name"`
+	Messages []SeedMessage `yaml:"messages"`
+}
+
+// SubscriptionConfig declares a subscription
+type SubscriptionConfig struct {
+	Name  string `yaml:"name"`
+	Topic string `yaml:"topic"`
+}
+
+// SchemaConfig declares a schema. Schemas are accepted for compatibility with
+// shared config files but not enforced by the emulator.
+type SchemaConfig struct {
+	Name       string `yaml:"name"`
+	Type       string `yaml:"type"`
+	Definition string `yaml:"definition"`
+}
+
+// SeedMessage is a message published when its topic is created. Data is
+// plain text; use DataBase64 for binary payloads.
+type SeedMessage struct {
+	Data       string            `yaml:"data"`
+	DataBase64 string            `yaml:"dataBase64"`
+	Attributes map[string]string `yaml:"attributes"`
+}
+
+// LoadConfig reads a bootstrap config file
+func LoadConfig(path string) (*BootstrapConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var cfg BootstrapConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	for _, project := range cfg.Projects {
+		if project.ID == "" {
+			return nil, errors.New("invalid config: project without id")
+		}
+		for _, topic := range project.Topics {
+			if topic.Name == "" {
+				return nil, fmt.Errorf("invalid config: topic without name in project %s", project.ID)
+			}
+		}
+		for _, sub := range project.Subscriptions {
+			if sub.Name == "" || sub.Topic == "" {
+				return nil, fmt.Errorf("invalid config: subscription needs name and topic in project %s", project.ID)
+			}
+		}
+	}
+	return &cfg, nil
+}
+
+// ConfigApplier applies a bootstrap config to a backend idempotently and can
+// keep reconciling it while the file changes
+type ConfigApplier struct {
+	storage Backend
+	path    string
+	prune   bool // delete resources removed from the file
+
+	// Resources declared by the last applied config, so that pruning only
+	// touches what the file created
+	topics        map[string]bool
+	subscriptions map[string]bool
+	modTime       time.Time
+}
+
+// NewConfigApplier creates a ConfigApplier for the config file at path
+func NewConfigApplier(storage Backend, path string, prune bool) *ConfigApplier {
+	return &ConfigApplier{
+		storage:       storage,
+		path:          path,
+		prune:         prune,
+		topics:        make(map[string]bool),
+		subscriptions: make(map[string]bool),
+	}
+}
+
+// Load reads the config file and applies it. The version it read is not
+// reloaded by Watch, even if it failed to load, so that a bad edit is
+// reported once.
+func (a *ConfigApplier) Load() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	a.modTime = info.ModTime()
+	cfg, err := LoadConfig(a.path)
+	if err != nil {
+		return err
+	}
+	return a.Apply(cfg)
+}
+
+// Watch polls the config file and re-applies it whenever it changes, until
+// ctx is done
+func (a *ConfigApplier) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(a.path)
+			if err != nil || info.ModTime().Equal(a.modTime) {
+				continue
+			}
+			if err := a.Load(); err != nil {
+				logger.Error("failed to reload config",
+					"operation", "apply_config",
+					"path", a.path,
+					"error", err.Error())
+				continue
+			}
+			logger.Info("config reloaded",
+				"operation", "apply_config",
+				"path", a.path)
+		}
+	}
+}
+
+// Apply creates every declared resource that does not exist yet. Seed
+// messages are published only when their topic is newly created, so applying
+// the same config twice has no further effect.
+func (a *ConfigApplier) Apply(cfg *BootstrapConfig) error {
+	topics := make(map[string]bool)
+	subscriptions := make(map[string]bool)
+	created := make(map[string]bool)
+
+	for _, project := range cfg.Projects {
+		for _, schema := range project.Schemas {
+			logger.Warn("schemas are not supported, ignoring",
+				"operation", "apply_config",
+				"schema", qualifiedName(project.ID, "schemas", schema.Name))
+		}
+
+		for _, topicCfg := range project.Topics {
+			topicName := qualifiedName(project.ID, "topics", topicCfg.Name)
+			topics[topicName] = true
+
+			if _, err := a.storage.CreateTopic(topicName); err != nil {
+				if err == ErrTopicAlreadyExists {
+					continue
+				}
+				return fmt.Errorf("failed to create topic %s: %w", topicName, err)
+			}
+			created[topicName] = true
+			logger.Info("topic created",
+				"operation", "apply_config",
+				"topic", topicName)
+		}
+
+		for _, subCfg := range project.Subscriptions {
+			subName := qualifiedName(project.ID, "subscriptions", subCfg.Name)
+			topicName := qualifiedName(project.ID, "topics", subCfg.Topic)
+			subscriptions[subName] = true
+
+			existing, err := a.storage.GetSubscription(subName)
+			if err == nil {
+				if existing.Topic != topicName {
+					logger.Warn("subscription exists with a different topic, leaving it unchanged",
+						"operation", "apply_config",
+						"subscription", subName,
+						"topic", existing.Topic,
+						"declared_topic", topicName)
+				}
+				continue
+			}
+			if _, err := a.storage.CreateSubscription(subName, topicName); err != nil {
+				return fmt.Errorf("failed to create subscription %s: %w", subName, err)
+			}
+			logger.Info("subscription created",
+				"operation", "apply_config",
+				"subscription", subName,
+				"topic", topicName)
+		}
+	}
+
+	// Seed after subscriptions exist so that they receive the messages
+	for _, project := range cfg.Projects {
+		for _, topicCfg := range project.Topics {
+			topicName := qualifiedName(project.ID, "topics", topicCfg.Name)
+			if !created[topicName] || len(topicCfg.Messages) == 0 {
+				continue
+			}
+			messages := make([]PubSubMessage, len(topicCfg.Messages))
+			for i, seed := range topicCfg.Messages {
+				data := seed.DataBase64
+				if data == "" {
+					data = EncodeData([]byte(seed.Data))
+				}
+				messages[i] = PubSubMessage{Data: data, Attributes: seed.Attributes}
+			}
+			if _, err := a.storage.Publish(topicName, messages); err != nil {
+				return fmt.Errorf("failed to seed topic %s: %w", topicName, err)
+			}
+			logger.Info("seeded",
+				"operation", "apply_config",
+				"topic", topicName,
+				"message_count", len(messages))
+		}
+	}
+	if a.prune {
+		a.pruneRemoved(topics, subscriptions)
+	}
+	a.topics = topics
+	a.subscriptions = subscriptions
+	return nil
+}
+
+// pruneRemoved deletes resources declared by the previous config but not by
+// the new one. Subscriptions go first so topics are not left referenced.
+func (a *ConfigApplier) pruneRemoved(topics, subscriptions map[string]bool) {
+	for subName := range a.subscriptions {
+		if subscriptions[subName] {
+			continue
+		}
+		if err := a.storage.DeleteSubscription(subName); err == nil {
+			logger.Info("subscription deleted",
+				"operation", "apply_config",
+				"subscription", subName)
+		}
+	}
+	for topicName := range a.topics {
+		if topics[topicName] {
+			continue
+		}
+		if err := a.storage.DeleteTopic(topicName); err == nil {
+			logger.Info("topic deleted",
+				"operation", "apply_config",
+				"topic", topicName)
+		}
+	}
+}
+
+// qualifiedName expands a short resource name to its full path within a
+// project. Names that are already full paths are returned unchanged.
+func qualifiedName(project, collection, name string) string {
+	if strings.HasPrefix(name, "projects/") {
+		return name
+	}
+	return fmt.Sprintf("projects/%s/%s/%s", project, collection, name)
+}
@@ -0,0 +1,187 @@
+package emulator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+}
+
+func TestConfigApplier_YAML(t *testing.T) {
+	storage := NewStorage()
+	path := filepath.Join(t.TempDir(), "pubsub.yaml")
+	writeConfig(t, path, `
+projects:
+  - id: test
+    topics:
+      - name: topic1
+        messages:
+          - data: hello
+            attributes: {source: bootstrap}
+          - dataBase64: d29ybGQ=
+    subscriptions:
+      - name: sub1
+        topic: topic1
+      - name: projects/test/subscriptions/sub2
+        topic: projects/test/topics/topic1
+`)
+
+	applier := NewConfigApplier(storage, path, false)
+	if err := applier.Load(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := storage.GetTopic("projects/test/topics/topic1"); err != nil {
+		t.Errorf("Expected topic to be created, got %v", err)
+	}
+	for _, sub := range []string{"projects/test/subscriptions/sub1", "projects/test/subscriptions/sub2"} {
+		pulled, err := storage.Pull(sub, 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(pulled) != 2 {
+			t.Fatalf("Expected 2 seed messages in %s, got %d", sub, len(pulled))
+		}
+		if pulled[0].Message.Data != EncodeData([]byte("hello")) {
+			t.Errorf("Expected seed data to be base64 encoded, got %s", pulled[0].Message.Data)
+		}
+		if pulled[0].Message.Attributes["source"] != "bootstrap" {
+			t.Errorf("Expected attribute source='bootstrap', got %s", pulled[0].Message.Attributes["source"])
+		}
+		if pulled[1].Message.Data != "d29ybGQ=" {
+			t.Errorf("Expected data 'd29ybGQ=', got %s", pulled[1].Message.Data)
+		}
+	}
+}
+
+func TestConfigApplier_Idempotent(t *testing.T) {
+	storage := NewStorage()
+	path := filepath.Join(t.TempDir(), "pubsub.json")
+	writeConfig(t, path, `{
+		"projects": [{
+			"id": "test",
+			"topics": [{"name": "topic1", "messages": [{"data": "hello"}]}],
+			"subscriptions": [{"name": "sub1", "topic": "topic1"}]
+		}]
+	}`)
+
+	// Applying twice, as on a restart with persistent storage, must not
+	// fail on existing resources or seed again
+	for i := 0; i < 2; i++ {
+		if err := NewConfigApplier(storage, path, false).Load(); err != nil {
+			t.Fatalf("Expected no error on apply %d, got %v", i+1, err)
+		}
+	}
+
+	pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+	if len(pulled) != 1 {
+		t.Errorf("Expected 1 seed message, got %d", len(pulled))
+	}
+}
+
+func TestConfigApplier_UnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pubsub.yaml")
+	writeConfig(t, path, `
+projects:
+  - id: test
+    topcs:
+      - name: topic1
+`)
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("Expected error for unknown field, got nil")
+	}
+}
+
+func TestConfigApplier_Prune(t *testing.T) {
+	storage := NewStorage()
+	path := filepath.Join(t.TempDir(), "pubsub.yaml")
+	writeConfig(t, path, `
+projects:
+  - id: test
+    topics: [{name: topic1}, {name: topic2}]
+    subscriptions: [{name: sub1, topic: topic1}, {name: sub2, topic: topic2}]
+`)
+
+	// A resource not declared in the file is never pruned
+	storage.CreateTopic("projects/test/topics/manual")
+
+	applier := NewConfigApplier(storage, path, true)
+	if err := applier.Load(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	writeConfig(t, path, `
+projects:
+  - id: test
+    topics: [{name: topic1}, {name: topic3}]
+    subscriptions: [{name: sub1, topic: topic1}]
+`)
+	if err := applier.Load(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := storage.GetTopic("projects/test/topics/topic2"); err != ErrTopicNotFound {
+		t.Errorf("Expected topic2 to be pruned, got %v", err)
+	}
+	if _, err := storage.GetSubscription("projects/test/subscriptions/sub2"); err != ErrSubscriptionNotFound {
+		t.Errorf("Expected sub2 to be pruned, got %v", err)
+	}
+	if _, err := storage.GetTopic("projects/test/topics/topic3"); err != nil {
+		t.Errorf("Expected topic3 to be created, got %v", err)
+	}
+	if _, err := storage.GetTopic("projects/test/topics/manual"); err != nil {
+		t.Errorf("Expected manually created topic to be kept, got %v", err)
+	}
+}
+
+func TestConfigApplier_Watch(t *testing.T) {
+	storage := NewStorage()
+	path := filepath.Join(t.TempDir(), "pubsub.yaml")
+	writeConfig(t, path, `projects: [{id: test, topics: [{name: topic1}]}]`)
+
+	applier := NewConfigApplier(storage, path, false)
+	if err := applier.Load(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go applier.Watch(ctx, 10*time.Millisecond)
+
+	writeConfig(t, path, `projects: [{id: test, topics: [{name: topic1}, {name: topic2}]}]`)
+	// Make sure the change is visible even on filesystems with coarse mtimes
+	future := time.Now().Add(time.Second)
+	os.Chtimes(path, future, future)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := storage.GetTopic("projects/test/topics/topic2"); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected topic2 to be created after the config changed")
+}
+
+func TestConfigApplier_LoadFailureRecordsModTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pubsub.yaml")
+	writeConfig(t, path, `projects: [{id: test, topcs: []}]`)
+
+	applier := NewConfigApplier(NewStorage(), path, false)
+	if err := applier.Load(); err == nil {
+		t.Fatal("Expected an error for the invalid config")
+	}
+	info, _ := os.Stat(path)
+	if !applier.modTime.Equal(info.ModTime()) {
+		t.Error("Expected the failed version not to be reloaded")
+	}
+}
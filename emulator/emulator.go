@@ -0,0 +1,297 @@
+// Package emulator is a lightweight Google Cloud Pub/Sub emulator speaking the
+// REST API. Start runs one in-process, e.g. for the tests of a Go service:
+//
+//	emu, err := emulator.Start(ctx, emulator.Options{})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer emu.Close()
+//	t.Setenv("PUBSUB_EMULATOR_HOST", emu.Addr())
+//
+// To serve it from an httptest server instead, pass the Handler of a Server:
+//
+//	srv := httptest.NewServer(emulator.NewServer().Handler())
+package emulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Options configures an emulator started with Start. The zero value is an
+// in-memory emulator listening on an ephemeral port of the loopback
+// interface.
+type Options struct {
+	Addr string // host:port to listen on (default "127.0.0.1:0")
+
+	Backend         string        // memory (default) or bolt, which requires DataDir
+	DataDir         string        // directory to persist state in ("" keeps it in memory)
+	CompactInterval time.Duration // how often to compact the journal (default one minute)
+
+	ConfigPath  string // topics and subscriptions to create at startup
+	WatchConfig bool   // re-apply ConfigPath whenever it changes
+	PruneConfig bool   // with WatchConfig, delete resources removed from the file
+
+	ImportPath string // state file to load at startup
+	ImportMode string // merge (default) or replace
+	ExportPath string // file to write the complete state to on Close
+
+	SampleInterval time.Duration // how often to sample backlogs for timeSeries (0 disables)
+	VirtualClock   bool          // only move time through the admin API
+	IDSeed         uint64        // sequential message IDs starting here (0: random UUIDs)
+
+	OTLPEndpoint string // OTLP/HTTP collector to export traces to
+	TraceFile    string // file to append traces to as OTLP JSON lines
+	RecordPath   string // file to append every Pub/Sub API call to
+}
+
+// configWatchInterval is how often WatchConfig checks the file for changes
+const configWatchInterval = 2 * time.Second
+
+// Emulator is a running emulator
+type Emulator struct {
+	server     *Server
+	httpServer *http.Server
+	listener   net.Listener
+	tracer     *Tracer
+	exportPath string
+	cancel     context.CancelFunc
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Start opens the storage, applies the startup options and starts serving.
+// The emulator runs until Close is called or ctx is done.
+func Start(ctx context.Context, opts Options) (*Emulator, error) {
+	if opts.Addr == "" {
+		opts.Addr = "127.0.0.1:0"
+	}
+	if opts.Backend == "" {
+		opts.Backend = "memory"
+	}
+	if opts.CompactInterval == 0 {
+		opts.CompactInterval = time.Minute
+	}
+	if opts.ImportMode == "" {
+		opts.ImportMode = "merge"
+	}
+
+	storage, err := openBackend(opts.Backend, opts.DataDir, opts.CompactInterval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	e := &Emulator{server: NewServerWithStorage(storage), exportPath: opts.ExportPath}
+	// Releases what has been set up so far if a later step fails
+	fail := func(err error) (*Emulator, error) {
+		e.release()
+		return nil, err
+	}
+
+	if opts.VirtualClock {
+		storage.SetClock(NewVirtualClock(time.Now()))
+	}
+
+	if opts.ImportPath != "" {
+		if err := importStateFile(storage, opts.ImportPath, opts.ImportMode); err != nil {
+			return fail(fmt.Errorf("failed to import state: %w", err))
+		}
+	}
+
+	// After the import, so that sequential IDs continue past imported ones
+	if opts.IDSeed != 0 {
+		storage.SetIDGenerator(NewSequentialIDs(opts.IDSeed))
+	}
+
+	var applier *ConfigApplier
+	if opts.ConfigPath != "" {
+		applier = NewConfigApplier(storage, opts.ConfigPath, opts.PruneConfig)
+		if err := applier.Load(); err != nil {
+			return fail(fmt.Errorf("failed to apply config: %w", err))
+		}
+	}
+
+	if e.tracer, err = openTracer(opts.OTLPEndpoint, opts.TraceFile); err != nil {
+		return fail(fmt.Errorf("failed to set up tracing: %w", err))
+	}
+	if e.tracer != nil {
+		e.server.EnableTracing(e.tracer)
+	}
+
+	if opts.RecordPath != "" {
+		recorder, err := NewRecorder(opts.RecordPath)
+		if err != nil {
+			return fail(err)
+		}
+		e.server.EnableRecording(recorder)
+	}
+
+	if err := e.server.configureNamespaces(opts); err != nil {
+		return fail(fmt.Errorf("failed to open namespaces: %w", err))
+	}
+
+	if e.listener, err = net.Listen("tcp", opts.Addr); err != nil {
+		return fail(err)
+	}
+
+	ctx, e.cancel = context.WithCancel(ctx)
+	if applier != nil && opts.WatchConfig {
+		go applier.Watch(ctx, configWatchInterval)
+	}
+	if opts.SampleInterval > 0 {
+		go e.server.sampler.Run(ctx, opts.SampleInterval)
+	}
+
+	e.httpServer = &http.Server{Handler: e.server.Handler()}
+	go func() {
+		if err := e.httpServer.Serve(e.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("failed to serve", "addr", e.Addr(), "error", err.Error())
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		e.Close()
+	}()
+
+	logger.Info("started emulator", "addr", e.Addr())
+	return e, nil
+}
+
+// Addr returns the host:port the emulator listens on, as expected in
+// PUBSUB_EMULATOR_HOST
+func (e *Emulator) Addr() string {
+	return e.listener.Addr().String()
+}
+
+// URL returns the base URL of the emulator
+func (e *Emulator) URL() string {
+	return "http://" + e.Addr()
+}
+
+// Server returns the server of the emulator
+func (e *Emulator) Server() *Server {
+	return e.server
+}
+
+// Storage returns the storage of the emulator
+func (e *Emulator) Storage() Backend {
+	return e.server.storage
+}
+
+// Close stops serving, waiting for calls in progress, writes the export file
+// if one was requested and closes the storage. It is safe to call more than
+// once.
+func (e *Emulator) Close() error {
+	e.closeOnce.Do(func() {
+		// Tail streams never finish on their own and would hold up Shutdown
+		e.server.closeTails()
+		if err := e.httpServer.Shutdown(context.Background()); err != nil {
+			e.closeErr = err
+		}
+		e.cancel()
+
+		if e.exportPath != "" {
+			if err := exportStateFile(e.server.storage, e.exportPath); err != nil {
+				e.closeErr = errors.Join(e.closeErr, fmt.Errorf("failed to export state: %w", err))
+			}
+		}
+		e.closeErr = errors.Join(e.closeErr, e.release())
+	})
+	return e.closeErr
+}
+
+// release closes the recording, flushes traces and closes the storage
+func (e *Emulator) release() error {
+	var errs []error
+	if e.server.recorder != nil {
+		if err := e.server.recorder.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close recording: %w", err))
+		}
+	}
+	if e.tracer != nil {
+		if err := e.tracer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush traces: %w", err))
+		}
+	}
+	if err := e.server.closeNamespaces(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := e.server.storage.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close storage: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// openBackend creates the storage backend selected by the options
+func openBackend(backend, dataDir string, compactInterval time.Duration) (Backend, error) {
+	switch backend {
+	case "memory":
+		if dataDir == "" {
+			return NewStorage(), nil
+		}
+		logger.Info("restoring state", "data_dir", dataDir)
+		return OpenStorage(dataDir, compactInterval)
+	case "bolt":
+		if dataDir == "" {
+			return nil, errors.New("the bolt backend requires a data directory")
+		}
+		if err := os.MkdirAll(dataDir, 0o755); err != nil {
+			return nil, err
+		}
+		return OpenBoltStorage(filepath.Join(dataDir, "pubsub.db"))
+	default:
+		return nil, fmt.Errorf("unknown backend %q", backend)
+	}
+}
+
+// openTracer creates a Tracer for the selected exporters, or returns nil if
+// tracing is disabled
+func openTracer(otlpEndpoint, traceFile string) (*Tracer, error) {
+	var exporters []SpanExporter
+	if otlpEndpoint != "" {
+		exporters = append(exporters, NewOTLPExporter(otlpEndpoint))
+	}
+	if traceFile != "" {
+		exporter, err := NewFileExporter(traceFile)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+	if len(exporters) == 0 {
+		return nil, nil
+	}
+	return NewTracer(exporters...), nil
+}
+
+// importStateFile loads a state document into storage
+func importStateFile(storage Backend, path, mode string) error {
+	exporter, ok := storage.(StateExporter)
+	if !ok {
+		return errors.New("the selected backend does not support state import")
+	}
+	if mode != "merge" && mode != "replace" {
+		return fmt.Errorf("unknown import mode %q", mode)
+	}
+	snap, err := ReadStateFile(path)
+	if err != nil {
+		return err
+	}
+	return exporter.ImportState(snap, mode == "replace")
+}
+
+// exportStateFile writes the complete state of storage to a file
+func exportStateFile(storage Backend, path string) error {
+	exporter, ok := storage.(StateExporter)
+	if !ok {
+		return errors.New("the selected backend does not support state export")
+	}
+	return WriteStateFile(path, exporter.ExportState())
+}
@@ -0,0 +1,103 @@
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStart(t *testing.T) {
+	emu, err := Start(context.Background(), Options{IDSeed: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer emu.Close()
+
+	resp, err := http.Get(emu.URL() + "/health")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, emu.URL()+"/v1/projects/test/topics/topic1", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	resp.Body.Close()
+	if _, err := emu.Storage().GetTopic("projects/test/topics/topic1"); err != nil {
+		t.Errorf("Expected the topic to be created, got %v", err)
+	}
+
+	ids, _ := emu.Storage().Publish("projects/test/topics/topic1", []PubSubMessage{{Data: EncodeData([]byte("test"))}})
+	if len(ids) != 1 || ids[0] != "1" {
+		t.Errorf("Expected sequential message IDs, got %v", ids)
+	}
+}
+
+func TestEmulator_Close(t *testing.T) {
+	exportPath := filepath.Join(t.TempDir(), "state.json")
+	emu, err := Start(context.Background(), Options{ExportPath: exportPath})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	emu.Storage().CreateTopic("projects/test/topics/topic1")
+
+	if err := emu.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := emu.Close(); err != nil {
+		t.Errorf("Expected closing twice to succeed, got %v", err)
+	}
+	if _, err := http.Get(emu.URL() + "/health"); err == nil {
+		t.Error("Expected the emulator to stop serving")
+	}
+
+	snap, err := ReadStateFile(exportPath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(snap.Topics) != 1 {
+		t.Errorf("Expected the topic to be exported, got %d topics", len(snap.Topics))
+	}
+}
+
+func TestEmulator_ContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	emu, err := Start(ctx, Options{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cancel()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Post(emu.URL()+"/v1/projects/test/topics/topic1:publish", "application/json", bytes.NewBufferString(`{}`))
+		if err != nil {
+			return
+		}
+		resp.Body.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected the emulator to stop when the context is done")
+}
+
+func TestStart_Errors(t *testing.T) {
+	for _, opts := range []Options{
+		{Backend: "bolt"},
+		{Backend: "unknown"},
+		{ConfigPath: filepath.Join(t.TempDir(), "missing.yaml")},
+		{Addr: "256.0.0.1:0"},
+	} {
+		if emu, err := Start(context.Background(), opts); err == nil {
+			emu.Close()
+			t.Errorf("Expected an error for %+v", opts)
+		}
+	}
+}
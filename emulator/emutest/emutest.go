@@ -0,0 +1,234 @@
+// Package emutest provides fixtures and assertions for Go tests that run the
+// emulator in-process. Assertions inspect the storage directly, so they never
+// pull and never disturb the leases of the code under test.
+//
+//	env := emutest.Start(t, emulator.Options{})
+//	t.Setenv("PUBSUB_EMULATOR_HOST", env.Addr())
+//	topic, sub := env.TopicAndSubscription(t, "orders", "billing")
+//	emutest.Publish(t, topic, Order{ID: 1})
+//	runConsumer(t)
+//	emutest.ExpectBacklog(t, sub, 0)
+//	emutest.ExpectNoRedelivery(t, sub)
+package emutest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kyontan/cloud-pubsub-emulator-lite/emulator"
+)
+
+// pollInterval is how often ExpectMessages checks the backlog
+const pollInterval = 10 * time.Millisecond
+
+// Env is an emulator running for a test
+type Env struct {
+	*emulator.Emulator
+
+	// Project is the project of the resources created by the Env
+	Project string
+
+	redelivered map[string][]string // message IDs by subscription
+	mu          sync.Mutex
+}
+
+// Start starts an emulator that is closed when the test ends
+func Start(tb testing.TB, opts emulator.Options) *Env {
+	tb.Helper()
+	emu, err := emulator.Start(context.Background(), opts)
+	if err != nil {
+		tb.Fatalf("failed to start emulator: %v", err)
+	}
+	tb.Cleanup(func() {
+		if err := emu.Close(); err != nil {
+			tb.Errorf("failed to close emulator: %v", err)
+		}
+	})
+
+	env := &Env{Emulator: emu, Project: "test", redelivered: make(map[string][]string)}
+	emu.Storage().Observe(env.observe)
+	return env
+}
+
+// observe remembers redeliveries for ExpectNoRedelivery
+func (e *Env) observe(event emulator.Event) {
+	if event.Kind != emulator.EventDeliver || event.Attempt <= 1 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.redelivered[event.Subscription] = append(e.redelivered[event.Subscription], event.MessageID)
+}
+
+// Topic is a topic created by an Env
+type Topic struct {
+	Name string
+	env  *Env
+}
+
+// Subscription is a subscription created by an Env
+type Subscription struct {
+	Name  string
+	Topic *Topic
+	env   *Env
+}
+
+// Topic creates a topic in the Env's project that is deleted when the test
+// ends
+func (e *Env) Topic(tb testing.TB, topicID string) *Topic {
+	tb.Helper()
+	name := fmt.Sprintf("projects/%s/topics/%s", e.Project, topicID)
+	if _, err := e.Storage().CreateTopic(name); err != nil {
+		tb.Fatalf("failed to create topic %s: %v", name, err)
+	}
+	tb.Cleanup(func() { e.Storage().DeleteTopic(name) })
+	return &Topic{Name: name, env: e}
+}
+
+// Subscription creates a subscription to topic that is deleted when the test
+// ends
+func (e *Env) Subscription(tb testing.TB, topic *Topic, subscriptionID string) *Subscription {
+	tb.Helper()
+	name := fmt.Sprintf("projects/%s/subscriptions/%s", e.Project, subscriptionID)
+	if _, err := e.Storage().CreateSubscription(name, topic.Name); err != nil {
+		tb.Fatalf("failed to create subscription %s: %v", name, err)
+	}
+	tb.Cleanup(func() {
+		e.Storage().DeleteSubscription(name)
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.redelivered, name)
+	})
+	return &Subscription{Name: name, Topic: topic, env: e}
+}
+
+// TopicAndSubscription creates a topic and a subscription to it
+func (e *Env) TopicAndSubscription(tb testing.TB, topicID, subscriptionID string) (*Topic, *Subscription) {
+	tb.Helper()
+	topic := e.Topic(tb, topicID)
+	return topic, e.Subscription(tb, topic, subscriptionID)
+}
+
+// Publish publishes payloads to topic and returns their message IDs. Strings
+// and byte slices are sent as they are; anything else is encoded as JSON.
+func Publish[T any](tb testing.TB, topic *Topic, payloads ...T) []string {
+	tb.Helper()
+	return PublishWithAttributes(tb, topic, nil, payloads...)
+}
+
+// PublishWithAttributes publishes payloads like Publish, each with the given
+// attributes
+func PublishWithAttributes[T any](tb testing.TB, topic *Topic, attributes map[string]string, payloads ...T) []string {
+	tb.Helper()
+	messages := make([]emulator.PubSubMessage, len(payloads))
+	for i, payload := range payloads {
+		data, err := encode(payload)
+		if err != nil {
+			tb.Fatalf("failed to encode payload %d: %v", i, err)
+		}
+		messages[i] = emulator.PubSubMessage{Data: emulator.EncodeData(data), Attributes: attributes}
+	}
+	ids, err := topic.env.Storage().Publish(topic.Name, messages)
+	if err != nil {
+		tb.Fatalf("failed to publish to %s: %v", topic.Name, err)
+	}
+	return ids
+}
+
+func encode(payload any) ([]byte, error) {
+	switch payload := payload.(type) {
+	case []byte:
+		return payload, nil
+	case string:
+		return []byte(payload), nil
+	default:
+		return json.Marshal(payload)
+	}
+}
+
+// Decode decodes the JSON payload of a message returned by ExpectMessages
+func Decode[T any](tb testing.TB, msg emulator.PeekedMessage) T {
+	tb.Helper()
+	var payload T
+	data, err := emulator.DecodeData(msg.Data)
+	if err == nil {
+		err = json.Unmarshal(data, &payload)
+	}
+	if err != nil {
+		tb.Fatalf("failed to decode message %s: %v", msg.MessageID, err)
+	}
+	return payload
+}
+
+// ExpectMessages waits until sub has exactly n unacknowledged messages and
+// returns them in delivery order. Backends that cannot list their messages
+// return nil once the count matches.
+//
+// The timeout is wall-clock time even when the emulator runs on a virtual
+// clock: the publishers and consumers it waits for run in real time, and a
+// virtual clock that nobody advances would never time out.
+func ExpectMessages(tb testing.TB, sub *Subscription, n int, timeout time.Duration) []emulator.PeekedMessage {
+	tb.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		stats := backlog(tb, sub)
+		if stats.Backlog == n {
+			break
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("expected %d messages in %s within %v, got %d", n, sub.Name, timeout, stats.Backlog)
+		}
+		time.Sleep(pollInterval)
+	}
+
+	peeker, ok := sub.env.Storage().(emulator.MessagePeeker)
+	if !ok {
+		return nil
+	}
+	var messages []emulator.PeekedMessage
+	token := ""
+	for {
+		page, next, err := peeker.PeekMessages(sub.Name, emulator.PeekFilter{}, 0, token)
+		if err != nil {
+			tb.Fatalf("failed to peek at %s: %v", sub.Name, err)
+		}
+		messages = append(messages, page...)
+		if next == "" {
+			return messages
+		}
+		token = next
+	}
+}
+
+// ExpectBacklog checks that sub has n unacknowledged messages
+func ExpectBacklog(tb testing.TB, sub *Subscription, n int) {
+	tb.Helper()
+	if stats := backlog(tb, sub); stats.Backlog != n {
+		tb.Errorf("expected a backlog of %d in %s, got %d (%d leased)", n, sub.Name, stats.Backlog, stats.Leased)
+	}
+}
+
+// ExpectNoRedelivery checks that no message has been delivered more than once
+// through sub, whether it was acked in the end or not
+func ExpectNoRedelivery(tb testing.TB, sub *Subscription) {
+	tb.Helper()
+	sub.env.mu.Lock()
+	redelivered := sub.env.redelivered[sub.Name]
+	sub.env.mu.Unlock()
+	if len(redelivered) > 0 {
+		tb.Errorf("expected no redelivery in %s, got %d redeliveries of %v", sub.Name, len(redelivered), redelivered)
+	}
+}
+
+func backlog(tb testing.TB, sub *Subscription) *emulator.BacklogStats {
+	tb.Helper()
+	stats, err := sub.env.Storage().BacklogStats(sub.Name)
+	if err != nil {
+		tb.Fatalf("failed to read the backlog of %s: %v", sub.Name, err)
+	}
+	return stats
+}
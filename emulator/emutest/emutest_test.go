@@ -0,0 +1,143 @@
+package emutest
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kyontan/cloud-pubsub-emulator-lite/emulator"
+)
+
+// fakeTB records failures instead of failing the test
+type fakeTB struct {
+	testing.TB
+	failures []string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...any) {
+	f.failures = append(f.failures, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Fatalf(format string, args ...any) {
+	f.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// run calls fn with a fakeTB and returns the failures it reported
+func run(t *testing.T, fn func(tb testing.TB)) []string {
+	fake := &fakeTB{TB: t}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fn(fake)
+	}()
+	wg.Wait()
+	return fake.failures
+}
+
+type order struct {
+	ID    int    `json:"id"`
+	Items string `json:"items"`
+}
+
+func TestPublishAndExpectMessages(t *testing.T) {
+	env := Start(t, emulator.Options{})
+	topic, sub := env.TopicAndSubscription(t, "orders", "billing")
+	if sub.Name != "projects/test/subscriptions/billing" || sub.Topic != topic {
+		t.Errorf("Expected the subscription of the topic, got %+v", sub)
+	}
+
+	ids := Publish(t, topic, order{ID: 1, Items: "tea"}, order{ID: 2, Items: "cake"})
+	PublishWithAttributes(t, topic, map[string]string{"kind": "raw"}, "plain text")
+	if len(ids) != 2 {
+		t.Fatalf("Expected 2 message IDs, got %v", ids)
+	}
+
+	messages := ExpectMessages(t, sub, 3, time.Second)
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(messages))
+	}
+	if got := Decode[order](t, messages[1]); got != (order{ID: 2, Items: "cake"}) {
+		t.Errorf("Expected the second order, got %+v", got)
+	}
+	if messages[2].DataPreview != "plain text" || messages[2].Attributes["kind"] != "raw" {
+		t.Errorf("Expected the raw message, got %+v", messages[2])
+	}
+	ExpectBacklog(t, sub, 3)
+	ExpectNoRedelivery(t, sub)
+}
+
+func TestExpectMessages_Waits(t *testing.T) {
+	env := Start(t, emulator.Options{})
+	topic, sub := env.TopicAndSubscription(t, "orders", "billing")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		env.Storage().Publish(topic.Name, []emulator.PubSubMessage{{Data: emulator.EncodeData([]byte("late"))}})
+	}()
+	if messages := ExpectMessages(t, sub, 1, time.Second); len(messages) != 1 {
+		t.Errorf("Expected 1 message, got %d", len(messages))
+	}
+
+	failures := run(t, func(tb testing.TB) { ExpectMessages(tb, sub, 2, 50*time.Millisecond) })
+	if len(failures) != 1 || !strings.Contains(failures[0], "expected 2 messages") {
+		t.Errorf("Expected a timeout, got %v", failures)
+	}
+}
+
+func TestExpectBacklog(t *testing.T) {
+	env := Start(t, emulator.Options{})
+	topic, sub := env.TopicAndSubscription(t, "orders", "billing")
+	Publish(t, topic, "one")
+
+	if failures := run(t, func(tb testing.TB) { ExpectBacklog(tb, sub, 0) }); len(failures) != 1 {
+		t.Errorf("Expected a failure for the unacked message, got %v", failures)
+	}
+
+	pulled, _ := env.Storage().Pull(sub.Name, 1)
+	env.Storage().Acknowledge(sub.Name, []string{pulled[0].AckID})
+	ExpectBacklog(t, sub, 0)
+}
+
+func TestExpectNoRedelivery(t *testing.T) {
+	env := Start(t, emulator.Options{})
+	topic, sub := env.TopicAndSubscription(t, "orders", "billing")
+	_, other := env.TopicAndSubscription(t, "payments", "audit")
+	Publish(t, topic, "one")
+
+	pulled, _ := env.Storage().Pull(sub.Name, 1)
+	env.Storage().ModifyAckDeadline(sub.Name, []string{pulled[0].AckID}, 0)
+	pulled, _ = env.Storage().Pull(sub.Name, 1)
+	env.Storage().Acknowledge(sub.Name, []string{pulled[0].AckID})
+
+	failures := run(t, func(tb testing.TB) { ExpectNoRedelivery(tb, sub) })
+	if len(failures) != 1 || !strings.Contains(failures[0], "1 redeliveries") {
+		t.Errorf("Expected a failure for the redelivered message, got %v", failures)
+	}
+	ExpectNoRedelivery(t, other)
+}
+
+func TestCleanup(t *testing.T) {
+	var env *Env
+	t.Run("fixture", func(t *testing.T) {
+		env = Start(t, emulator.Options{})
+		env.TopicAndSubscription(t, "orders", "billing")
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		shared := Start(t, emulator.Options{})
+		t.Run("first", func(t *testing.T) { shared.TopicAndSubscription(t, "orders", "billing") })
+		// The resources of the first subtest are gone
+		t.Run("second", func(t *testing.T) { shared.TopicAndSubscription(t, "orders", "billing") })
+	})
+
+	if _, err := env.Storage().GetTopic("projects/test/topics/orders"); err == nil {
+		t.Error("Expected the topic to be deleted when the test ended")
+	}
+}
@@ -0,0 +1,88 @@
+package emulator
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventKind identifies what happened to a message
+type EventKind string
+
+const (
+	EventPublish EventKind = "publish" // stored in a topic
+	EventDeliver EventKind = "deliver" // leased to a subscriber by Pull
+	EventAck     EventKind = "ack"     // acknowledged
+	EventNack    EventKind = "nack"    // released for redelivery (deadline 0)
+	EventModAck  EventKind = "modack"  // ack deadline changed
+	EventExpire  EventKind = "expire"  // lease ran out without an ack
+	EventReset   EventKind = "reset"   // project (or everything) wiped
+	EventDelete  EventKind = "delete"  // topic or subscription deleted
+)
+
+// Event describes a change to a message. Publish events carry the topic and
+// message, reset events the project, delete events either the topic or the
+// subscription that was deleted; all others carry the subscription and ack
+// ID.
+//
+// Expired leases are detected lazily, when Pull finds the message deliverable
+// again, so an expire event is always followed by a deliver event.
+type Event struct {
+	Kind         EventKind
+	Time         time.Time
+	Topic        string
+	Subscription string
+	MessageID    string
+	AckID        string
+	Attempt      int       // delivery attempt, for deliver events
+	Deadline     time.Time // new ack deadline, for deliver and modack events
+	Message      *Message  // for publish events; must not be modified
+	Fanout       []string  // for publish events, the subscriptions it went to
+	Project      string    // for reset events; empty for a full reset
+}
+
+// Observer is notified of events by a backend. It is called synchronously,
+// possibly while the backend holds locks, so it must be fast and must never
+// call back into the backend.
+type Observer func(Event)
+
+// eventBus fans events out to the registered observers
+type eventBus struct {
+	observers []Observer
+	mu        sync.RWMutex
+}
+
+// add registers an observer
+func (b *eventBus) add(fn Observer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.observers = append(b.observers, fn)
+}
+
+// active reports whether anyone is listening, so that callers can skip
+// building events nobody receives
+func (b *eventBus) active() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.observers) > 0
+}
+
+// emit delivers an event to every observer
+func (b *eventBus) emit(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.observers {
+		fn(e)
+	}
+}
+
+// messageSize returns the decoded size of a message's data plus its
+// attributes, which is what Pub/Sub bills as the message size
+func messageSize(msg *Message) int {
+	data := strings.TrimRight(msg.Data, "=")
+	size := len(data) * 3 / 4
+	for key, value := range msg.Attributes {
+		size += len(key) + len(value)
+	}
+	return size
+}
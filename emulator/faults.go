@@ -0,0 +1,326 @@
+package emulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	adminFaultsRegex = regexp.MustCompile(`^/admin/v1/faults$`)
+	adminFaultRegex  = regexp.MustCompile(`^/admin/v1/faults/([^/]+)$`)
+)
+
+// ErrFaultNotFound is returned when deleting an unknown fault rule
+var ErrFaultNotFound = errors.New("fault rule not found")
+
+// adminMethod is the method name fault rules use for admin API calls
+const adminMethod = "Admin"
+
+// faultStatus maps the gRPC status codes a rule can inject to the HTTP
+// status of the REST transport
+var faultStatus = map[string]int{
+	"CANCELLED":           499,
+	"UNKNOWN":             http.StatusInternalServerError,
+	"INVALID_ARGUMENT":    http.StatusBadRequest,
+	"DEADLINE_EXCEEDED":   http.StatusGatewayTimeout,
+	"NOT_FOUND":           http.StatusNotFound,
+	"ALREADY_EXISTS":      http.StatusConflict,
+	"PERMISSION_DENIED":   http.StatusForbidden,
+	"RESOURCE_EXHAUSTED":  http.StatusTooManyRequests,
+	"FAILED_PRECONDITION": http.StatusBadRequest,
+	"ABORTED":             http.StatusConflict,
+	"INTERNAL":            http.StatusInternalServerError,
+	"UNAVAILABLE":         http.StatusServiceUnavailable,
+	"UNAUTHENTICATED":     http.StatusUnauthorized,
+}
+
+// FaultRule makes matching API calls misbehave. A call matches if its method
+// is one of Methods (case-insensitive; empty matches all) and its resource
+// name matches the Resource glob (empty matches all). A matching call is
+// affected with the given Probability (0 means always) until Count calls have
+// been affected (0 means forever), after which the rule is removed.
+type FaultRule struct {
+	ID          string   `json:"id"`
+	Methods     []string `json:"methods,omitempty"`
+	Resource    string   `json:"resource,omitempty"`
+	Probability float64  `json:"probability,omitempty"`
+	Count       int      `json:"count,omitempty"` // remaining calls to affect
+
+	// Actions, applied in this order
+	Latency      string `json:"latency,omitempty"`      // delay before handling, e.g. "2s"
+	Error        string `json:"error,omitempty"`        // gRPC status code to fail with
+	DropResponse bool   `json:"dropResponse,omitempty"` // handle the call, then drop the connection
+
+	Triggered int `json:"triggered"` // calls affected so far
+
+	latency time.Duration
+}
+
+// validate checks a rule submitted through the admin API
+func (r *FaultRule) validate() error {
+	if r.Latency == "" && r.Error == "" && !r.DropResponse {
+		return errors.New("rule must set latency, error or dropResponse")
+	}
+	if r.Error != "" {
+		if _, ok := faultStatus[r.Error]; !ok {
+			return fmt.Errorf("unknown error code %q", r.Error)
+		}
+		if r.DropResponse {
+			return errors.New("error and dropResponse are mutually exclusive")
+		}
+	}
+	if r.Latency != "" {
+		latency, err := time.ParseDuration(r.Latency)
+		if err != nil || latency < 0 {
+			return fmt.Errorf("invalid latency %q", r.Latency)
+		}
+		r.latency = latency
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return errors.New("probability must be between 0 and 1")
+	}
+	if r.Count < 0 {
+		return errors.New("count must not be negative")
+	}
+	if _, err := path.Match(r.Resource, ""); err != nil {
+		return fmt.Errorf("invalid resource pattern %q", r.Resource)
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to a call
+func (r *FaultRule) matches(method, resource string) bool {
+	if len(r.Methods) > 0 {
+		found := false
+		for _, m := range r.Methods {
+			found = found || strings.EqualFold(m, method)
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Resource == "" {
+		return true
+	}
+	ok, _ := path.Match(r.Resource, resource)
+	return ok
+}
+
+// ListFaultRulesResponse is returned by the fault rules endpoint
+type ListFaultRulesResponse struct {
+	Rules []FaultRule `json:"rules"`
+}
+
+// Fault is what to do to one call
+type Fault struct {
+	Latency      time.Duration
+	Error        string // gRPC status code, or "" to handle the call
+	DropResponse bool
+}
+
+// FaultInjector holds the active fault rules. It does not depend on the
+// transport: a transport asks Inject for the fault to apply to each call.
+type FaultInjector struct {
+	rules  []*FaultRule
+	nextID int
+	mu     sync.Mutex
+}
+
+// NewFaultInjector creates a FaultInjector without rules
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{}
+}
+
+// Add installs a rule after validating it and returns the installed rule
+func (f *FaultInjector) Add(rule FaultRule) (FaultRule, error) {
+	if err := rule.validate(); err != nil {
+		return FaultRule{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	rule.ID = strconv.Itoa(f.nextID)
+	rule.Triggered = 0
+	f.rules = append(f.rules, &rule)
+	return rule, nil
+}
+
+// Delete removes a rule
+func (f *FaultInjector) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, rule := range f.rules {
+		if rule.ID == id {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return nil
+		}
+	}
+	return ErrFaultNotFound
+}
+
+// Clear removes all rules
+func (f *FaultInjector) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = nil
+}
+
+// List returns the active rules in the order they are evaluated
+func (f *FaultInjector) List() []FaultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rules := make([]FaultRule, len(f.rules))
+	for i, rule := range f.rules {
+		rules[i] = *rule
+	}
+	return rules
+}
+
+// Inject returns the fault to apply to a call, if any. Rules are evaluated in
+// the order they were added, and the first that fires wins.
+func (f *FaultInjector) Inject(method, resource string) (Fault, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, rule := range f.rules {
+		if !rule.matches(method, resource) {
+			continue
+		}
+		if rule.Probability > 0 && rand.Float64() >= rule.Probability {
+			continue
+		}
+
+		rule.Triggered++
+		if rule.Count > 0 {
+			rule.Count--
+			if rule.Count == 0 {
+				f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			}
+		}
+		return Fault{Latency: rule.latency, Error: rule.Error, DropResponse: rule.DropResponse}, true
+	}
+	return Fault{}, false
+}
+
+// apiResource returns the resource name an API or admin call addresses, such
+// as projects/p/topics/t for a publish
+func apiResource(urlPath string) string {
+	resource := strings.TrimPrefix(strings.TrimPrefix(urlPath, "/admin"), "/v1/")
+	resource, _, _ = strings.Cut(resource, ":")
+	return resource
+}
+
+// injectFault applies the fault selected for a REST call. It returns false if
+// the call must not be handled; dropped responses are handled here.
+func (s *Server) injectFault(w http.ResponseWriter, r *http.Request, method string, handle http.HandlerFunc) bool {
+	fault, ok := s.faults.Inject(method, apiResource(r.URL.Path))
+	if !ok {
+		return true
+	}
+
+	if fault.Latency > 0 {
+		select {
+		case <-time.After(fault.Latency):
+		case <-r.Context().Done():
+			return false
+		}
+	}
+
+	if fault.Error != "" {
+		logger.Info("injected fault",
+			"operation", "inject_fault",
+			"method", method,
+			"path", r.URL.Path,
+			"code", fault.Error)
+		writeJSON(w, faultStatus[fault.Error], map[string]string{"error": fault.Error + ": injected fault"})
+		return false
+	}
+
+	if fault.DropResponse {
+		handle(&discardResponseWriter{header: make(http.Header)}, r)
+		logger.Info("dropped response",
+			"operation", "inject_fault",
+			"method", method,
+			"path", r.URL.Path)
+		// Closes the connection without a response
+		panic(http.ErrAbortHandler)
+	}
+	return true
+}
+
+// discardResponseWriter swallows a response
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponseWriter) WriteHeader(int)             {}
+
+// isFaultsPath reports whether path belongs to the fault rules API, which is
+// itself exempt from faults
+func isFaultsPath(path string) bool {
+	return adminFaultsRegex.MatchString(path) || adminFaultRegex.MatchString(path)
+}
+
+// serveFaults handles the fault rules API
+func (s *Server) serveFaults(w http.ResponseWriter, r *http.Request) {
+	if adminFaultsRegex.MatchString(r.URL.Path) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, ListFaultRulesResponse{Rules: s.faults.List()})
+		case http.MethodPost:
+			s.handleAddFault(w, r)
+		case http.MethodDelete:
+			s.faults.Clear()
+			logger.Info("cleared fault rules", "operation", "clear_faults")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id := adminFaultRegex.FindStringSubmatch(r.URL.Path)[1]
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.faults.Delete(id); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	logger.Info("deleted fault rule", "operation", "delete_fault", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAddFault(w http.ResponseWriter, r *http.Request) {
+	var rule FaultRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	rule, err := s.faults.Add(rule)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	logger.Info("added fault rule",
+		"operation", "add_fault",
+		"id", rule.ID,
+		"methods", rule.Methods,
+		"resource", rule.Resource)
+	writeJSON(w, http.StatusOK, rule)
+}
@@ -0,0 +1,188 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func addFault(t *testing.T, server *Server, body string) FaultRule {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/admin/v1/faults", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var rule FaultRule
+	json.Unmarshal(w.Body.Bytes(), &rule)
+	return rule
+}
+
+func publishStatus(server *Server, topic string) int {
+	req := httptest.NewRequest(http.MethodPost, "/v1/projects/test/topics/"+topic+":publish",
+		bytes.NewBufferString(`{"messages": [{"data": "dGVzdA=="}]}`))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestFaults_ErrorWithCount(t *testing.T) {
+	server := NewServer()
+	server.storage.CreateTopic("projects/test/topics/orders")
+	server.storage.CreateTopic("projects/test/topics/other")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/orders")
+
+	rule := addFault(t, server, `{"methods": ["publish"], "resource": "projects/test/topics/ord*", "error": "UNAVAILABLE", "count": 2}`)
+	if rule.ID == "" {
+		t.Fatal("Expected the rule to get an ID")
+	}
+
+	if code := publishStatus(server, "other"); code != http.StatusOK {
+		t.Errorf("Expected other resources to be unaffected, got %d", code)
+	}
+	for i := 0; i < 2; i++ {
+		if code := publishStatus(server, "orders"); code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, code)
+		}
+	}
+	if code := publishStatus(server, "orders"); code != http.StatusOK {
+		t.Errorf("Expected the rule to be used up, got %d", code)
+	}
+	if rules := server.faults.List(); len(rules) != 0 {
+		t.Errorf("Expected the exhausted rule to be removed, got %+v", rules)
+	}
+
+	// Failed calls must not have published anything
+	pulled, _ := server.storage.Pull("projects/test/subscriptions/sub1", 10)
+	if len(pulled) != 1 {
+		t.Errorf("Expected 1 message, got %d", len(pulled))
+	}
+}
+
+func TestFaults_Probability(t *testing.T) {
+	server := NewServer()
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	addFault(t, server, `{"error": "RESOURCE_EXHAUSTED", "probability": 0.5}`)
+
+	failed := 0
+	for i := 0; i < 1000; i++ {
+		if publishStatus(server, "topic1") == http.StatusTooManyRequests {
+			failed++
+		}
+	}
+	if failed < 400 || failed > 600 {
+		t.Errorf("Expected about half the calls to fail, got %d of 1000", failed)
+	}
+	if rules := server.faults.List(); rules[0].Triggered != failed {
+		t.Errorf("Expected %d triggered, got %d", failed, rules[0].Triggered)
+	}
+}
+
+func TestFaults_Latency(t *testing.T) {
+	server := NewServer()
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	addFault(t, server, `{"methods": ["Publish"], "latency": "100ms", "count": 1}`)
+
+	start := time.Now()
+	if code := publishStatus(server, "topic1"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected at least 100ms of latency, got %v", elapsed)
+	}
+}
+
+func TestFaults_DropResponse(t *testing.T) {
+	server := NewServer()
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	addFault(t, server, `{"methods": ["publish"], "dropResponse": true, "count": 1}`)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/v1/projects/test/topics/topic1:publish", "application/json",
+		bytes.NewBufferString(`{"messages": [{"data": "dGVzdA=="}]}`))
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("Expected the connection to be dropped, got status %d", resp.StatusCode)
+	}
+
+	// The publish was committed before the response was dropped
+	pulled, _ := server.storage.Pull("projects/test/subscriptions/sub1", 10)
+	if len(pulled) != 1 {
+		t.Errorf("Expected 1 committed message, got %d", len(pulled))
+	}
+}
+
+func TestFaults_AdminCalls(t *testing.T) {
+	server := NewServer()
+	addFault(t, server, `{"methods": ["admin"], "error": "INTERNAL"}`)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/v1/projects", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	// The fault rules API stays reachable
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/admin/v1/faults", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/v1/projects", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d after clearing, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestFaults_AdminAPI(t *testing.T) {
+	server := NewServer()
+
+	for _, body := range []string{
+		`{}`,
+		`{"error": "BROKEN"}`,
+		`{"latency": "soon"}`,
+		`{"error": "UNAVAILABLE", "probability": 2}`,
+		`{"error": "UNAVAILABLE", "dropResponse": true}`,
+		`{"error": "UNAVAILABLE", "resource": "projects/[x"}`,
+		`not json`,
+	} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/v1/faults", bytes.NewBufferString(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+
+	first := addFault(t, server, `{"error": "UNAVAILABLE"}`)
+	addFault(t, server, `{"latency": "1s"}`)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/v1/faults", nil))
+	var list ListFaultRulesResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Rules) != 2 || list.Rules[0].ID != first.ID {
+		t.Fatalf("Expected 2 rules in order, got %+v", list.Rules)
+	}
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/admin/v1/faults/"+first.ID, nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/admin/v1/faults/"+first.ID, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if rules := server.faults.List(); len(rules) != 1 {
+		t.Errorf("Expected 1 rule left, got %d", len(rules))
+	}
+}
@@ -1,13 +1,15 @@
-package main
+package emulator
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 )
 
 var (
@@ -29,22 +31,146 @@ func init() {
 	}))
 }
 
+// SetLogger replaces the logger of the emulator. It must be called before
+// any Server is used.
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
 // Server wraps the storage and provides HTTP handlers
 type Server struct {
-	storage *Storage
+	storage Backend
+	metrics *Metrics
+	sampler *Sampler
+	history *History
+	faults  *FaultInjector
+	tails   *Tails
+	// namespaces is nil for the server of a namespace, which has none of its own
+	namespaces *namespaces
+	tracer     *Tracer   // nil unless tracing is enabled
+	recorder   *Recorder // nil unless recording is enabled
 }
 
 // NewServer creates a new Server instance
 func NewServer() *Server {
+	return NewServerWithStorage(NewStorage())
+}
+
+// NewServerWithStorage creates a new Server instance backed by the given storage
+func NewServerWithStorage(storage Backend) *Server {
 	return &Server{
-		storage: NewStorage(),
+		storage: storage,
+		metrics: NewMetrics(storage),
+		sampler: NewSampler(storage),
+		history: NewHistory(storage),
+		faults:  NewFaultInjector(),
+		tails:   NewTails(storage),
+
+		namespaces: newNamespaces(),
 	}
 }
 
+// EnableTracing records spans for API calls and message lifecycles with t
+func (s *Server) EnableTracing(t *Tracer) {
+	s.tracer = t
+	s.storage.Observe(t.observe)
+}
+
+// EnableRecording appends every Pub/Sub API call to rec
+func (s *Server) EnableRecording(rec *Recorder) {
+	s.recorder = rec
+}
+
+// Handler returns the complete HTTP handler of the emulator: the API served
+// by ServeHTTP plus the health check
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealthCheck)
+	mux.Handle("/", s)
+	return mux
+}
+
 // ServeHTTP implements http.Handler
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Requests to a namespace are served by its own server
+	if s.serveNamespace(w, r) {
+		return
+	}
+
+	path := r.URL.Path
+
+	// Prometheus metrics
+	if path == "/metrics" {
+		s.handleMetrics(w, r)
+		return
+	}
+
+	// Cloud Monitoring time series
+	if matches := timeSeriesRegex.FindStringSubmatch(path); matches != nil {
+		if r.Method == http.MethodGet {
+			s.handleListTimeSeries(w, r, matches[1])
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Record latencies of Pub/Sub API requests
+	method := apiMethod(r)
+	if method != "" {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		defer func() {
+			s.metrics.observeRequest(method, recorder.status, time.Since(start))
+		}()
+		w = recorder
+
+		if s.tracer != nil {
+			var sp *span
+			sp, r = s.tracer.startRequest(r, method)
+			defer func() { s.tracer.endRequest(sp, recorder.status) }()
+		}
+
+		if s.recorder != nil {
+			var finish func()
+			w, finish = s.recorder.capture(w, r, method)
+			defer finish()
+		}
+	}
+
+	// Fault rules, which are exempt from faults themselves
+	if isFaultsPath(path) {
+		s.serveFaults(w, r)
+		return
+	}
+
+	// Injected faults
+	if method == "" && isAdminPath(path) {
+		method = adminMethod
+	}
+	if method != "" && !s.injectFault(w, r, method, s.route) {
+		return
+	}
+
+	s.route(w, r)
+}
+
+// route dispatches a request to its handler
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 
+	// Admin API
+	if isAdminPath(path) {
+		s.serveAdmin(w, r)
+		return
+	}
+
+	// Web UI
+	if isUIPath(path) {
+		s.serveUI(w, r)
+		return
+	}
+
 	// Topic publish (check before topic operations)
 	if matches := topicPublishRegex.FindStringSubmatch(path); matches != nil {
 		project, topic := matches[1], matches[2]
@@ -169,6 +295,8 @@ func (s *Server) handleCreateTopic(w http.ResponseWriter, r *http.Request, topic
 			"error", err.Error())
 		if err == ErrTopicAlreadyExists {
 			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		} else if errors.Is(err, ErrLimitExceeded) {
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
 		} else {
 			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
@@ -241,6 +369,8 @@ func (s *Server) handleCreateSubscription(w http.ResponseWriter, r *http.Request
 			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
 		} else if err == ErrTopicNotFound {
 			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		} else if errors.Is(err, ErrLimitExceeded) {
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
 		} else {
 			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
@@ -309,12 +439,16 @@ func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request, topicName
 			"error", err.Error())
 		if err == ErrTopicNotFound {
 			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		} else if errors.Is(err, ErrLimitExceeded) {
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
 		} else {
 			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
 		return
 	}
 
+	s.tracer.linkPublish(r.Context(), messageIDs)
+
 	logger.Info("published",
 		"operation", "publish",
 		"topic", topicName,
@@ -356,6 +490,8 @@ func (s *Server) handlePull(w http.ResponseWriter, r *http.Request, subscription
 	if messages == nil {
 		messages = []ReceivedMessage{}
 	}
+	s.history.recordRequester(subscriptionName, messages, requester(r))
+	s.tracer.linkPull(r.Context(), subscriptionName, messages)
 
 	logger.Info("pulled",
 		"operation", "pull",
@@ -0,0 +1,205 @@
+package emulator
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxHistoryMessages bounds the number of message IDs with a recorded
+	// history; the oldest are forgotten first
+	maxHistoryMessages = 10000
+	// maxHistoryEntries bounds the history of a message in one subscription;
+	// the oldest entries are dropped first
+	maxHistoryEntries = 100
+)
+
+// History entry types. The emulator supports neither dead-letter topics nor
+// message retention, so there are no entries for a message being
+// dead-lettered or expiring by retention: a message stays in the backlog of
+// its subscription until it is acked, purged or the subscription is deleted.
+const (
+	HistoryPublished        = "PUBLISHED"
+	HistoryDelivered        = "DELIVERED"
+	HistoryDeadlineModified = "DEADLINE_MODIFIED"
+	HistoryNacked           = "NACKED"
+	HistoryExpired          = "EXPIRED"
+	HistoryAcked            = "ACKED"
+)
+
+// HistoryEntry is one step in the lifecycle of a message in a subscription
+type HistoryEntry struct {
+	Type            string     `json:"type"`
+	Time            time.Time  `json:"time"`
+	Topic           string     `json:"topic,omitempty"`
+	AckID           string     `json:"ackId,omitempty"`
+	DeliveryAttempt int        `json:"deliveryAttempt,omitempty"`
+	AckDeadline     *time.Time `json:"ackDeadline,omitempty"`
+	Requester       string     `json:"requester,omitempty"` // who pulled the message
+	Dropped         int        `json:"dropped,omitempty"`   // entries discarded just before this one
+}
+
+// SubscriptionHistory is the history of a message in one subscription
+type SubscriptionHistory struct {
+	Subscription string         `json:"subscription"`
+	Events       []HistoryEntry `json:"events"`
+}
+
+// MessageHistoryResponse is returned by the message history endpoint
+type MessageHistoryResponse struct {
+	MessageID     string                `json:"messageId"`
+	Subscriptions []SubscriptionHistory `json:"subscriptions"`
+}
+
+// History records what happened to recently published messages, per message
+// ID and subscription, from backend events
+type History struct {
+	messages map[string]map[string][]HistoryEntry // by message ID, then subscription
+	order    []string                             // message IDs, oldest first
+	mu       sync.Mutex
+}
+
+// NewHistory creates a History that observes storage
+func NewHistory(storage Backend) *History {
+	h := &History{messages: make(map[string]map[string][]HistoryEntry)}
+	storage.Observe(h.observe)
+	return h
+}
+
+// observe records a backend event
+func (h *History) observe(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch e.Kind {
+	case EventPublish:
+		if len(e.Fanout) == 0 {
+			return
+		}
+		subscriptions := make(map[string][]HistoryEntry, len(e.Fanout))
+		for _, name := range e.Fanout {
+			subscriptions[name] = []HistoryEntry{{Type: HistoryPublished, Time: e.Time, Topic: e.Topic}}
+		}
+		if _, ok := h.messages[e.MessageID]; !ok {
+			h.order = append(h.order, e.MessageID)
+		}
+		h.messages[e.MessageID] = subscriptions
+		h.evict()
+		return
+	case EventReset:
+		for id, subscriptions := range h.messages {
+			for name := range subscriptions {
+				if inProject(name, e.Project) {
+					delete(subscriptions, name)
+				}
+			}
+			if len(subscriptions) == 0 {
+				delete(h.messages, id)
+			}
+		}
+		return
+	}
+
+	subscriptions := h.messages[e.MessageID]
+	if subscriptions == nil {
+		return // forgotten, or published before the history was started
+	}
+	entry := HistoryEntry{Time: e.Time, AckID: e.AckID}
+	switch e.Kind {
+	case EventDeliver:
+		entry.Type = HistoryDelivered
+		entry.DeliveryAttempt = e.Attempt
+		entry.AckDeadline = &e.Deadline
+	case EventModAck:
+		entry.Type = HistoryDeadlineModified
+		entry.AckDeadline = &e.Deadline
+	case EventNack:
+		entry.Type = HistoryNacked
+	case EventExpire:
+		entry.Type = HistoryExpired
+	case EventAck:
+		entry.Type = HistoryAcked
+	default:
+		return
+	}
+	subscriptions[e.Subscription] = appendHistory(subscriptions[e.Subscription], entry)
+}
+
+// appendHistory adds an entry, dropping the oldest after the first (which
+// records the publish) when the history is full
+func appendHistory(entries []HistoryEntry, entry HistoryEntry) []HistoryEntry {
+	if len(entries) < maxHistoryEntries {
+		return append(entries, entry)
+	}
+	dropped := entries[1].Dropped + 1
+	copy(entries[1:], entries[2:])
+	entries[1].Dropped = dropped
+	entries[len(entries)-1] = entry
+	return entries
+}
+
+// evict forgets the oldest messages beyond maxHistoryMessages. The caller
+// must hold h.mu.
+func (h *History) evict() {
+	for len(h.messages) > maxHistoryMessages && len(h.order) > 0 {
+		delete(h.messages, h.order[0])
+		h.order = h.order[1:]
+	}
+	// Drop IDs forgotten by a reset so that order does not grow unbounded
+	if len(h.order) > 2*maxHistoryMessages {
+		live := h.order[:0]
+		for _, id := range h.order {
+			if _, ok := h.messages[id]; ok {
+				live = append(live, id)
+			}
+		}
+		h.order = live
+	}
+}
+
+// recordRequester notes who received the given messages on the delivery
+// entries created by their pull
+func (h *History) recordRequester(subscriptionName string, messages []ReceivedMessage, requester string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, msg := range messages {
+		entries := h.messages[msg.Message.MessageID][subscriptionName]
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].Type == HistoryDelivered && entries[i].AckID == msg.AckID {
+				entries[i].Requester = requester
+				break
+			}
+		}
+	}
+}
+
+// Message returns the history of a message in the subscriptions of project,
+// or of a single subscription if subscriptionName is set
+func (h *History) Message(project, messageID, subscriptionName string) []SubscriptionHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var histories []SubscriptionHistory
+	for name, entries := range h.messages[messageID] {
+		if !inProject(name, project) || (subscriptionName != "" && name != subscriptionName) {
+			continue
+		}
+		histories = append(histories, SubscriptionHistory{
+			Subscription: name,
+			Events:       append([]HistoryEntry(nil), entries...),
+		})
+	}
+	sort.Slice(histories, func(i, j int) bool { return histories[i].Subscription < histories[j].Subscription })
+	return histories
+}
+
+// requester describes the client that sent r
+func requester(r *http.Request) string {
+	if agent := r.UserAgent(); agent != "" {
+		return r.RemoteAddr + " (" + agent + ")"
+	}
+	return r.RemoteAddr
+}
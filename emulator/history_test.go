@@ -0,0 +1,144 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleMessageHistory(t *testing.T) {
+	server := NewServer()
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub2", "projects/test/topics/topic1")
+	ids, _ := server.storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: EncodeData([]byte("test"))}})
+
+	pull := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/v1/projects/test/subscriptions/sub1:pull", bytes.NewBufferString(`{"maxMessages": 1}`))
+		req.Header.Set("User-Agent", "worker-1")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		var resp PullResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.ReceivedMessages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(resp.ReceivedMessages))
+		}
+		return resp.ReceivedMessages[0].AckID
+	}
+
+	ackID := pull()
+	server.storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{ackID}, 30)
+	server.storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{ackID}, 0)
+	ackID = pull()
+	server.storage.Acknowledge("projects/test/subscriptions/sub1", []string{ackID})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/v1/projects/test/messages/"+ids[0]+"/history", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp MessageHistoryResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if resp.MessageID != ids[0] || len(resp.Subscriptions) != 2 {
+		t.Fatalf("Expected history in 2 subscriptions, got %+v", resp)
+	}
+
+	sub1 := resp.Subscriptions[0]
+	if sub1.Subscription != "projects/test/subscriptions/sub1" {
+		t.Fatalf("Expected sub1 first, got %s", sub1.Subscription)
+	}
+	expected := []string{HistoryPublished, HistoryDelivered, HistoryDeadlineModified, HistoryNacked, HistoryDelivered, HistoryAcked}
+	if len(sub1.Events) != len(expected) {
+		t.Fatalf("Expected %d events, got %+v", len(expected), sub1.Events)
+	}
+	for i, typ := range expected {
+		if sub1.Events[i].Type != typ {
+			t.Errorf("Expected event %d to be %s, got %s", i, typ, sub1.Events[i].Type)
+		}
+	}
+	if sub1.Events[0].Topic != "projects/test/topics/topic1" {
+		t.Errorf("Expected topic on the publish event, got %q", sub1.Events[0].Topic)
+	}
+	delivered := sub1.Events[4]
+	if delivered.DeliveryAttempt != 2 || delivered.AckID != ackID || delivered.AckDeadline == nil {
+		t.Errorf("Expected second delivery with ack ID and deadline, got %+v", delivered)
+	}
+	if delivered.Requester != "192.0.2.1:1234 (worker-1)" {
+		t.Errorf("Expected requester to be recorded, got %q", delivered.Requester)
+	}
+	if sub1.Events[2].AckDeadline == nil {
+		t.Error("Expected the new deadline on the deadline modified event")
+	}
+
+	if sub2 := resp.Subscriptions[1]; len(sub2.Events) != 1 || sub2.Events[0].Type != HistoryPublished {
+		t.Errorf("Expected only the publish in sub2, got %+v", sub2.Events)
+	}
+
+	// Filter by subscription
+	req = httptest.NewRequest(http.MethodGet, "/admin/v1/projects/test/messages/"+ids[0]+"/history?subscription=sub2", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	resp = MessageHistoryResponse{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Subscriptions) != 1 || resp.Subscriptions[0].Subscription != "projects/test/subscriptions/sub2" {
+		t.Errorf("Expected only sub2, got %+v", resp.Subscriptions)
+	}
+
+	// Other projects and unknown messages have no history
+	for _, path := range []string{
+		"/admin/v1/projects/other/messages/" + ids[0] + "/history",
+		"/admin/v1/projects/test/messages/unknown/history",
+	} {
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusNotFound, path, w.Code)
+		}
+	}
+
+	// A reset forgets the project's history
+	server.storage.Reset("test")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/v1/projects/test/messages/"+ids[0]+"/history", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d after reset, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHistory_Bounded(t *testing.T) {
+	storage := NewStorage()
+	history := NewHistory(storage)
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	ids, _ := storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: EncodeData([]byte("test"))}})
+
+	pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 1)
+	for i := 0; i < maxHistoryEntries+10; i++ {
+		storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{pulled[0].AckID}, 30)
+	}
+
+	events := history.Message("test", ids[0], "")[0].Events
+	if len(events) != maxHistoryEntries {
+		t.Fatalf("Expected %d events, got %d", maxHistoryEntries, len(events))
+	}
+	if events[0].Type != HistoryPublished {
+		t.Errorf("Expected the publish event to be kept, got %s", events[0].Type)
+	}
+	if events[1].Dropped != 12 {
+		t.Errorf("Expected 12 dropped events, got %d", events[1].Dropped)
+	}
+
+	// Old messages are forgotten
+	for i := 0; i < maxHistoryMessages; i++ {
+		storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: EncodeData([]byte("test"))}})
+	}
+	if got := history.Message("test", ids[0], ""); len(got) != 0 {
+		t.Errorf("Expected the oldest message to be forgotten, got %+v", got)
+	}
+}
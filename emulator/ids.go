@@ -0,0 +1,70 @@
+package emulator
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator produces message and ack IDs. It is called concurrently.
+type IDGenerator interface {
+	MessageID() string
+	// AckID returns the ack ID of a message in a subscription
+	AckID(subscriptionName, messageID string) string
+}
+
+// randomIDs generates random UUIDs
+type randomIDs struct{}
+
+func (randomIDs) MessageID() string        { return uuid.New().String() }
+func (randomIDs) AckID(_, _ string) string { return uuid.New().String() }
+
+// SequentialIDs generates message IDs that are increasing decimal numbers,
+// like those of the real service, and derives ack IDs from the seed, the
+// subscription and the message ID. Two runs with the same seed that publish
+// the same messages in the same order get the same IDs.
+type SequentialIDs struct {
+	seed uint64
+	next atomic.Uint64
+}
+
+// NewSequentialIDs creates a generator whose first message ID is seed
+func NewSequentialIDs(seed uint64) *SequentialIDs {
+	g := &SequentialIDs{seed: seed}
+	g.next.Store(seed)
+	return g
+}
+
+// MessageID returns the next number
+func (g *SequentialIDs) MessageID() string {
+	return strconv.FormatUint(g.next.Add(1)-1, 10)
+}
+
+// AckID hashes the seed, subscription and message ID
+func (g *SequentialIDs) AckID(subscriptionName, messageID string) string {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, g.seed)
+	h.Write([]byte(subscriptionName))
+	h.Write([]byte{0})
+	h.Write([]byte(messageID))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// skipPast makes sure later message IDs are greater than id, so that IDs
+// already in storage are not handed out again
+func (g *SequentialIDs) skipPast(id string) {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return
+	}
+	for {
+		next := g.next.Load()
+		if next > n || g.next.CompareAndSwap(next, n+1) {
+			return
+		}
+	}
+}
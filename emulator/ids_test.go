@@ -0,0 +1,40 @@
+package emulator
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSequentialIDs_Reproducible(t *testing.T) {
+	run := func() []string {
+		server := NewServer()
+		server.storage.SetClock(NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+		server.storage.SetIDGenerator(NewSequentialIDs(1))
+
+		var bodies []string
+		for _, req := range []*http.Request{
+			httptest.NewRequest(http.MethodPut, "/v1/projects/test/topics/topic1", nil),
+			httptest.NewRequest(http.MethodPut, "/v1/projects/test/subscriptions/sub1", bytes.NewBufferString(`{"topic": "projects/test/topics/topic1"}`)),
+			httptest.NewRequest(http.MethodPost, "/v1/projects/test/topics/topic1:publish", bytes.NewBufferString(`{"messages": [{"data": "dGVzdA=="}, {"data": "dGVzdA=="}]}`)),
+			httptest.NewRequest(http.MethodPost, "/v1/projects/test/subscriptions/sub1:pull", bytes.NewBufferString(`{"maxMessages": 10}`)),
+		} {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			bodies = append(bodies, w.Body.String())
+		}
+		return bodies
+	}
+
+	first, second := run(), run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("Expected identical responses, got %s and %s", first[i], second[i])
+		}
+	}
+	if first[2] != `{"messageIds":["1","2"]}`+"\n" {
+		t.Errorf("Expected sequential message IDs, got %s", first[2])
+	}
+}
@@ -0,0 +1,84 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLimitExceeded is returned when a request would take the storage past one
+// of its limits
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Limits bounds the resources of a Storage. Zero means unlimited.
+type Limits struct {
+	MaxTopics        int `json:"maxTopics,omitempty"`
+	MaxSubscriptions int `json:"maxSubscriptions,omitempty"`
+	// MaxBacklog bounds the messages retained by each subscription; a publish
+	// that would exceed it on any subscription of the topic is rejected whole
+	MaxBacklog int `json:"maxBacklog,omitempty"`
+}
+
+// Validate checks that no limit is negative
+func (l Limits) Validate() error {
+	if l.MaxTopics < 0 || l.MaxSubscriptions < 0 || l.MaxBacklog < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// Limiter is implemented by backends that can enforce Limits
+type Limiter interface {
+	Limits() Limits
+	SetLimits(limits Limits)
+}
+
+var _ Limiter = (*Storage)(nil)
+
+// Limits returns the limits of the storage
+func (s *Storage) Limits() Limits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.limits
+}
+
+// SetLimits replaces the limits of the storage. Resources beyond a lowered
+// limit are kept; only new ones are refused.
+func (s *Storage) SetLimits(limits Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+}
+
+// checkTopicLimit is called with mu held before a topic is created
+func (s *Storage) checkTopicLimit() error {
+	if s.limits.MaxTopics > 0 && len(s.topics) >= s.limits.MaxTopics {
+		return fmt.Errorf("%w: at most %d topics", ErrLimitExceeded, s.limits.MaxTopics)
+	}
+	return nil
+}
+
+// checkSubscriptionLimit is called with mu held before a subscription is
+// created
+func (s *Storage) checkSubscriptionLimit() error {
+	if s.limits.MaxSubscriptions > 0 && len(s.subscriptions) >= s.limits.MaxSubscriptions {
+		return fmt.Errorf("%w: at most %d subscriptions", ErrLimitExceeded, s.limits.MaxSubscriptions)
+	}
+	return nil
+}
+
+// checkBacklogLimit is called with mu and the topic's publishMu held before n
+// messages are published to a topic
+func (s *Storage) checkBacklogLimit(topicName string, n int) error {
+	if s.limits.MaxBacklog <= 0 {
+		return nil
+	}
+	for name, state := range s.topicSubs[topicName] {
+		state.mu.Lock()
+		backlog := len(state.messages)
+		state.mu.Unlock()
+		if backlog+n > s.limits.MaxBacklog {
+			return fmt.Errorf("%w: at most %d messages in %s", ErrLimitExceeded, s.limits.MaxBacklog, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package emulator
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Short leases keep the redelivery tests fast
+	leaseDuration = 50 * time.Millisecond
+	os.Exit(m.Run())
+}
@@ -0,0 +1,388 @@
+package emulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric names mirror Cloud Monitoring's pubsub.googleapis.com/* metrics,
+// with the slashes replaced. Labels carry full resource names.
+const (
+	metricTopicSendCount     = "pubsub_topic_send_message_operation_count"
+	metricTopicByteCost      = "pubsub_topic_byte_cost"
+	metricSubUndelivered     = "pubsub_subscription_num_undelivered_messages"
+	metricSubOldestUnacked   = "pubsub_subscription_oldest_unacked_message_age"
+	metricSubOutstanding     = "pubsub_subscription_num_outstanding_messages"
+	metricSubSentCount       = "pubsub_subscription_sent_message_count"
+	metricSubAckCount        = "pubsub_subscription_ack_message_count"
+	metricSubNackCount       = "pubsub_subscription_nack_requests"
+	metricSubExpiredCount    = "pubsub_subscription_expired_ack_deadlines_count"
+	metricSubRedeliveryCount = "pubsub_subscription_redelivered_message_count"
+	metricRequestLatencies   = "pubsub_api_request_latencies_seconds"
+)
+
+// latencyBuckets are the upper bounds of the request latency histogram
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics collects counters from backend events and request latencies and
+// renders them, together with backlog gauges read at scrape time, in the
+// Prometheus text format
+type Metrics struct {
+	storage Backend
+
+	topics        map[string]*topicCounters
+	subscriptions map[string]*subscriptionCounters
+	requests      map[requestKey]*histogram
+	mu            sync.Mutex
+}
+
+type topicCounters struct {
+	messages int64
+	bytes    int64
+}
+
+type subscriptionCounters struct {
+	sent        int64
+	acks        int64
+	nacks       int64
+	expired     int64
+	redelivered int64
+}
+
+type requestKey struct {
+	method string
+	code   int
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics creates a Metrics instance that observes storage
+func NewMetrics(storage Backend) *Metrics {
+	m := &Metrics{
+		storage:       storage,
+		topics:        make(map[string]*topicCounters),
+		subscriptions: make(map[string]*subscriptionCounters),
+		requests:      make(map[requestKey]*histogram),
+	}
+	storage.Observe(m.observe)
+	return m
+}
+
+// observe updates the counters for a backend event
+func (m *Metrics) observe(e Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e.Kind == EventPublish {
+		counters := m.topics[e.Topic]
+		if counters == nil {
+			counters = &topicCounters{}
+			m.topics[e.Topic] = counters
+		}
+		counters.messages++
+		counters.bytes += int64(messageSize(e.Message))
+		return
+	}
+
+	if e.Kind == EventReset {
+		// Drop the series of wiped resources
+		for name := range m.topics {
+			if inProject(name, e.Project) {
+				delete(m.topics, name)
+			}
+		}
+		for name := range m.subscriptions {
+			if inProject(name, e.Project) {
+				delete(m.subscriptions, name)
+			}
+		}
+		return
+	}
+
+	if e.Kind == EventDelete {
+		delete(m.topics, e.Topic)
+		delete(m.subscriptions, e.Subscription)
+		return
+	}
+
+	counters := m.subscriptions[e.Subscription]
+	if counters == nil {
+		counters = &subscriptionCounters{}
+		m.subscriptions[e.Subscription] = counters
+	}
+	switch e.Kind {
+	case EventDeliver:
+		counters.sent++
+		if e.Attempt > 1 {
+			counters.redelivered++
+		}
+	case EventAck:
+		counters.acks++
+	case EventNack:
+		counters.nacks++
+	case EventExpire:
+		counters.expired++
+	}
+}
+
+// published returns the number of messages published to a topic
+func (m *Metrics) published(topicName string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if counters := m.topics[topicName]; counters != nil {
+		return counters.messages
+	}
+	return 0
+}
+
+// acked returns the number of messages acknowledged in a subscription
+func (m *Metrics) acked(subscriptionName string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if counters := m.subscriptions[subscriptionName]; counters != nil {
+		return counters.acks
+	}
+	return 0
+}
+
+// observeRequest records the latency of an API request
+func (m *Metrics) observeRequest(method string, code int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := requestKey{method: method, code: code}
+	h := m.requests[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.requests[key] = h
+	}
+	seconds := latency.Seconds()
+	if i := sort.SearchFloat64s(latencyBuckets, seconds); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// WriteTo renders all metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	out := &metricsWriter{w: bufio.NewWriter(w)}
+
+	// Read gauges before taking m.mu: the backend calls observe with its own
+	// locks held, so holding m.mu while calling into it could deadlock
+	type subscriptionGauges struct {
+		name  string
+		stats *BacklogStats
+	}
+	var gauges []subscriptionGauges
+	for _, sub := range m.storage.ListSubscriptions() {
+		stats, err := m.storage.BacklogStats(sub.Name)
+		if err != nil {
+			continue // deleted meanwhile
+		}
+		gauges = append(gauges, subscriptionGauges{name: sub.Name, stats: stats})
+	}
+	sort.Slice(gauges, func(i, j int) bool { return gauges[i].name < gauges[j].name })
+	now := m.storage.Clock().Now()
+
+	out.header(metricSubUndelivered, "gauge", "Number of unacknowledged messages in a subscription")
+	for _, g := range gauges {
+		out.sample(metricSubUndelivered, float64(g.stats.Backlog), "subscription", g.name)
+	}
+	out.header(metricSubOutstanding, "gauge", "Number of messages leased to subscribers and not yet acknowledged")
+	for _, g := range gauges {
+		out.sample(metricSubOutstanding, float64(g.stats.Leased), "subscription", g.name)
+	}
+	out.header(metricSubOldestUnacked, "gauge", "Age in seconds of the oldest unacknowledged message")
+	for _, g := range gauges {
+		age := 0.0
+		if !g.stats.OldestUnackedAt.IsZero() {
+			age = max(now.Sub(g.stats.OldestUnackedAt).Seconds(), 0)
+		}
+		out.sample(metricSubOldestUnacked, age, "subscription", g.name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	topics := sortedKeys(m.topics)
+	out.header(metricTopicSendCount, "counter", "Number of messages published to a topic")
+	for _, name := range topics {
+		out.sample(metricTopicSendCount, float64(m.topics[name].messages), "topic", name)
+	}
+	out.header(metricTopicByteCost, "counter", "Bytes published to a topic (data and attributes)")
+	for _, name := range topics {
+		out.sample(metricTopicByteCost, float64(m.topics[name].bytes), "topic", name)
+	}
+
+	subscriptions := sortedKeys(m.subscriptions)
+	for _, metric := range []struct {
+		name, help string
+		value      func(*subscriptionCounters) int64
+	}{
+		{metricSubSentCount, "Number of messages delivered by pull", func(c *subscriptionCounters) int64 { return c.sent }},
+		{metricSubAckCount, "Number of messages acknowledged", func(c *subscriptionCounters) int64 { return c.acks }},
+		{metricSubNackCount, "Number of messages negatively acknowledged", func(c *subscriptionCounters) int64 { return c.nacks }},
+		{metricSubExpiredCount, "Number of leases that expired without an ack", func(c *subscriptionCounters) int64 { return c.expired }},
+		{metricSubRedeliveryCount, "Number of deliveries after the first attempt", func(c *subscriptionCounters) int64 { return c.redelivered }},
+	} {
+		out.header(metric.name, "counter", metric.help)
+		for _, name := range subscriptions {
+			out.sample(metric.name, float64(metric.value(m.subscriptions[name])), "subscription", name)
+		}
+	}
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+	out.header(metricRequestLatencies, "histogram", "Latency of Pub/Sub API requests by method and response code")
+	for _, key := range keys {
+		h := m.requests[key]
+		code := strconv.Itoa(key.code)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			out.sample(metricRequestLatencies+"_bucket", float64(cumulative),
+				"method", key.method, "response_code", code, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		out.sample(metricRequestLatencies+"_bucket", float64(h.count), "method", key.method, "response_code", code, "le", "+Inf")
+		out.sample(metricRequestLatencies+"_sum", h.sum, "method", key.method, "response_code", code)
+		out.sample(metricRequestLatencies+"_count", float64(h.count), "method", key.method, "response_code", code)
+	}
+
+	return out.n, out.flush()
+}
+
+// metricsWriter writes the text exposition format, remembering the first
+// error
+type metricsWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (mw *metricsWriter) printf(format string, args ...any) {
+	if mw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(mw.w, format, args...)
+	mw.n += int64(n)
+	mw.err = err
+}
+
+func (mw *metricsWriter) header(name, typ, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample; labels are name/value pairs
+func (mw *metricsWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	if len(labels) > 0 {
+		b.WriteByte('}')
+	}
+	mw.printf("%s %s\n", b.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (mw *metricsWriter) flush() error {
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+// labelEscaper escapes a label value as required by the text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// apiMethod names the Pub/Sub API method a request is routed to, or returns
+// "" for requests outside the Pub/Sub API
+func apiMethod(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case topicPublishRegex.MatchString(path):
+		return "Publish"
+	case subscriptionPullRegex.MatchString(path):
+		return "Pull"
+	case subscriptionAckRegex.MatchString(path):
+		return "Acknowledge"
+	case subscriptionModifyAckRegex.MatchString(path):
+		return "ModifyAckDeadline"
+	case listTopicsRegex.MatchString(path):
+		return "ListTopics"
+	case listSubscriptionsRegex.MatchString(path):
+		return "ListSubscriptions"
+	case topicPathRegex.MatchString(path):
+		return crudMethod(r.Method, "Topic")
+	case subscriptionPathRegex.MatchString(path):
+		return crudMethod(r.Method, "Subscription")
+	}
+	return ""
+}
+
+func crudMethod(httpMethod, resource string) string {
+	switch httpMethod {
+	case http.MethodPut:
+		return "Create" + resource
+	case http.MethodGet:
+		return "Get" + resource
+	case http.MethodDelete:
+		return "Delete" + resource
+	}
+	return ""
+}
+
+// handleMetrics serves the Prometheus scrape endpoint
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.WriteTo(w)
+}
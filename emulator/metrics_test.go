@@ -0,0 +1,108 @@
+package emulator
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	server := NewServer()
+
+	// Setup through the HTTP API so that request latencies are recorded
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPut, "/v1/projects/test/topics/topic1", nil),
+		httptest.NewRequest(http.MethodPut, "/v1/projects/test/subscriptions/sub1", bytes.NewBufferString(`{"topic": "projects/test/topics/topic1"}`)),
+		httptest.NewRequest(http.MethodPost, "/v1/projects/test/topics/topic1:publish", bytes.NewBufferString(`{"messages": [{"data": "dGVzdA==", "attributes": {"k": "v"}}, {"data": "dGVzdA=="}]}`)),
+	} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for %s, got %d", http.StatusOK, req.URL.Path, w.Code)
+		}
+	}
+
+	// Nack one message, let the other expire, then redeliver and ack both
+	pulled, _ := server.storage.Pull("projects/test/subscriptions/sub1", 2)
+	server.storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{pulled[0].AckID}, 0)
+	time.Sleep(ackDeadline() + 10*time.Millisecond)
+	pulled, _ = server.storage.Pull("projects/test/subscriptions/sub1", 2)
+	server.storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Expected text/plain, got %s", contentType)
+	}
+
+	body := w.Body.String()
+	for _, line := range []string{
+		`pubsub_topic_send_message_operation_count{topic="projects/test/topics/topic1"} 2`,
+		`pubsub_topic_byte_cost{topic="projects/test/topics/topic1"} 10`,
+		`pubsub_subscription_num_undelivered_messages{subscription="projects/test/subscriptions/sub1"} 1`,
+		`pubsub_subscription_num_outstanding_messages{subscription="projects/test/subscriptions/sub1"} 1`,
+		`pubsub_subscription_sent_message_count{subscription="projects/test/subscriptions/sub1"} 4`,
+		`pubsub_subscription_ack_message_count{subscription="projects/test/subscriptions/sub1"} 1`,
+		`pubsub_subscription_nack_requests{subscription="projects/test/subscriptions/sub1"} 1`,
+		`pubsub_subscription_expired_ack_deadlines_count{subscription="projects/test/subscriptions/sub1"} 1`,
+		`pubsub_subscription_redelivered_message_count{subscription="projects/test/subscriptions/sub1"} 2`,
+		`pubsub_api_request_latencies_seconds_count{method="Publish",response_code="200"} 1`,
+		`pubsub_api_request_latencies_seconds_bucket{method="CreateTopic",response_code="200",le="+Inf"} 1`,
+		`# TYPE pubsub_api_request_latencies_seconds histogram`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected metrics to contain %q", line)
+		}
+	}
+	if !strings.Contains(body, `pubsub_subscription_oldest_unacked_message_age{subscription="projects/test/subscriptions/sub1"} `) {
+		t.Error("Expected oldest unacked message age")
+	}
+}
+
+func TestMetrics_LabelEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	out := &metricsWriter{w: bufio.NewWriter(&buf)}
+	out.sample("m", 1, "l", "a\"b\\c\nd")
+	out.flush()
+
+	if got := buf.String(); got != "m{l=\"a\\\"b\\\\c\\nd\"} 1\n" {
+		t.Errorf("Expected escaped label, got %q", got)
+	}
+}
+
+func TestMetrics_DropsDeletedResources(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage Backend) {
+		metrics := NewMetrics(storage)
+		storage.CreateTopic("projects/test/topics/topic1")
+		storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+		storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+		pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 1)
+		storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID})
+
+		if err := storage.DeleteSubscription("projects/test/subscriptions/sub1"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := storage.DeleteTopic("projects/test/topics/topic1"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := metrics.WriteTo(&buf); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		for _, name := range []string{"topic1", "sub1"} {
+			if strings.Contains(buf.String(), name) {
+				t.Errorf("Expected no series for deleted %s, got:\n%s", name, buf.String())
+			}
+		}
+	})
+}
@@ -1,8 +1,8 @@
-package main
+package emulator
 
 import (
 	"encoding/base64"
-	"sync"
+	"maps"
 	"time"
 )
 
@@ -78,13 +78,28 @@ type ListSubscriptionsResponse struct {
 	Subscriptions []Subscription `json:"subscriptions"`
 }
 
-// InternalMessage represents a message in the storage layer
+// InternalMessage is a subscription's delivery record for a message in the
+// storage layer. The body is shared with other subscriptions of the topic.
+// It is guarded by the lock of the subscription that owns it.
 type InternalMessage struct {
-	Message   Message
-	AckID     string
-	AckedAt   *time.Time
+	body       *storedMessage
+	AckID      string
+	AckedAt    *time.Time
 	DeadlineAt time.Time
-	mu        sync.Mutex
+
+	// DeliveryAttempts counts how often the message was handed out by Pull
+	DeliveryAttempts int
+
+	// seq orders the records of a subscription; it only grows, so a record
+	// can be found again after others before it were removed
+	seq uint64
+}
+
+// message returns a copy of the message safe to hand out to a consumer
+func (m *InternalMessage) message() Message {
+	msg := m.body.message
+	msg.Attributes = maps.Clone(msg.Attributes)
+	return msg
 }
 
 // Encode data to base64
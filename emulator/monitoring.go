@@ -0,0 +1,474 @@
+package emulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cloud Monitoring metric types answered by the timeSeries endpoint
+const (
+	monitoringNumUndelivered = "pubsub.googleapis.com/subscription/num_undelivered_messages"
+	monitoringOldestUnacked  = "pubsub.googleapis.com/subscription/oldest_unacked_message_age"
+)
+
+// sampleRetention is how long backlog samples are kept
+const sampleRetention = 6 * time.Hour
+
+var timeSeriesRegex = regexp.MustCompile(`^/v3/projects/([^/]+)/timeSeries$`)
+
+// ListTimeSeriesResponse is the response for listing time series, in the
+// shape of the Cloud Monitoring v3 API
+type ListTimeSeriesResponse struct {
+	TimeSeries    []TimeSeries `json:"timeSeries"`
+	NextPageToken string       `json:"nextPageToken,omitempty"`
+}
+
+// TimeSeries is a metric of one monitored resource
+type TimeSeries struct {
+	Metric     MonitoringMetric  `json:"metric"`
+	Resource   MonitoredResource `json:"resource"`
+	MetricKind string            `json:"metricKind"`
+	ValueType  string            `json:"valueType"`
+	Unit       string            `json:"unit,omitempty"`
+	Points     []Point           `json:"points"`
+}
+
+// MonitoringMetric identifies a metric
+type MonitoringMetric struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// MonitoredResource identifies the resource a metric describes
+type MonitoredResource struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+}
+
+// Point is a single value of a time series, newest first
+type Point struct {
+	Interval TimeInterval `json:"interval"`
+	Value    TypedValue   `json:"value"`
+}
+
+// TimeInterval is the time a point covers; gauges have start == end
+type TimeInterval struct {
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime"`
+}
+
+// TypedValue holds a point value. Int64 values are strings in JSON.
+type TypedValue struct {
+	Int64Value  string   `json:"int64Value,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// Sampler periodically records the backlog of every subscription so that
+// the timeSeries endpoint can return curves rather than a single value
+type Sampler struct {
+	storage Backend
+	series  map[string][]backlogSample // key: subscription name, oldest first
+	mu      sync.Mutex
+}
+
+type backlogSample struct {
+	time      time.Time
+	backlog   int64
+	oldestAge int64 // seconds
+}
+
+// NewSampler creates a Sampler for storage. Call Run to start sampling.
+func NewSampler(storage Backend) *Sampler {
+	return &Sampler{
+		storage: storage,
+		series:  make(map[string][]backlogSample),
+	}
+}
+
+// Run samples every interval of the storage's clock until ctx is done. A
+// virtual clock advanced by several intervals is sampled at each of them.
+func (s *Sampler) Run(ctx context.Context, interval time.Duration) {
+	clock := s.storage.Clock()
+	// Start ticking before sampling, so that a virtual clock advanced once a
+	// sample is visible always samples again
+	stop := clock.Every(interval, s.sample)
+	defer stop()
+	s.sample(clock.Now())
+	<-ctx.Done()
+}
+
+// sample records the current backlog of every subscription and drops
+// samples older than the retention
+func (s *Sampler) sample(now time.Time) {
+	current := make(map[string]backlogSample)
+	for _, sub := range s.storage.ListSubscriptions() {
+		stats, err := s.storage.BacklogStats(sub.Name)
+		if err != nil {
+			continue // deleted meanwhile
+		}
+		sample := backlogSample{time: now, backlog: int64(stats.Backlog)}
+		if !stats.OldestUnackedAt.IsZero() {
+			sample.oldestAge = max(int64(now.Sub(stats.OldestUnackedAt).Seconds()), 0)
+		}
+		current[sub.Name] = sample
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, sample := range current {
+		// A tick can overtake the first sample
+		if samples := s.series[name]; len(samples) > 0 && !samples[len(samples)-1].time.Before(sample.time) {
+			continue
+		}
+		s.series[name] = append(s.series[name], sample)
+	}
+	cutoff := now.Add(-sampleRetention)
+	for name, samples := range s.series {
+		i := sort.Search(len(samples), func(i int) bool { return samples[i].time.After(cutoff) })
+		if i == len(samples) {
+			delete(s.series, name)
+		} else if i > 0 {
+			s.series[name] = append([]backlogSample(nil), samples[i:]...)
+		}
+	}
+}
+
+// snapshot returns a copy of the samples of every subscription in project
+func (s *Sampler) snapshot(project string) map[string][]backlogSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	series := make(map[string][]backlogSample)
+	for name, samples := range s.series {
+		if inProject(name, project) {
+			series[name] = append([]backlogSample(nil), samples...)
+		}
+	}
+	return series
+}
+
+// timeSeriesFilter is a parsed Cloud Monitoring filter. Only the subset
+// needed to select Pub/Sub subscription metrics is supported:
+//
+//	metric.type = "pubsub.googleapis.com/subscription/num_undelivered_messages"
+//	AND resource.type = "pubsub_subscription"
+//	AND resource.labels.subscription_id = one_of("a", "b")
+type timeSeriesFilter struct {
+	metricType string
+	resource   map[string]func(string) bool // key: resource label or "type"
+}
+
+var (
+	filterTermRegex   = regexp.MustCompile(`^\s*([\w.]+)\s*=\s*(.*?)\s*$`)
+	filterStringRegex = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+	filterAndRegex    = regexp.MustCompile(`\s+AND\s+`)
+)
+
+func parseTimeSeriesFilter(filter string) (*timeSeriesFilter, error) {
+	parsed := &timeSeriesFilter{resource: make(map[string]func(string) bool)}
+	for _, term := range filterAndRegex.Split(strings.TrimSpace(filter), -1) {
+		matches := filterTermRegex.FindStringSubmatch(term)
+		if matches == nil {
+			return nil, fmt.Errorf("unsupported filter term %q", term)
+		}
+		field, expr := matches[1], matches[2]
+
+		match, err := parseFilterMatcher(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case field == "metric.type":
+			value, err := strconv.Unquote(expr)
+			if err != nil {
+				return nil, fmt.Errorf("metric.type must be compared to a string")
+			}
+			parsed.metricType = value
+		case field == "resource.type":
+			parsed.resource["type"] = match
+		case strings.HasPrefix(field, "resource.label.") || strings.HasPrefix(field, "resource.labels."):
+			label := field[strings.LastIndex(field, ".")+1:]
+			if label != "project_id" && label != "subscription_id" {
+				return nil, fmt.Errorf("unsupported resource label %q", label)
+			}
+			parsed.resource[label] = match
+		default:
+			return nil, fmt.Errorf("unsupported filter field %q", field)
+		}
+	}
+	if parsed.metricType == "" {
+		return nil, errors.New("filter must specify metric.type")
+	}
+	return parsed, nil
+}
+
+// parseFilterMatcher parses the right-hand side of a filter comparison: a
+// string, or starts_with, ends_with or one_of applied to strings
+func parseFilterMatcher(expr string) (func(string) bool, error) {
+	function, args := "", expr
+	if i := strings.Index(expr, "("); i > 0 && strings.HasSuffix(expr, ")") {
+		function, args = expr[:i], expr[i+1:len(expr)-1]
+	}
+
+	var values []string
+	for _, quoted := range filterStringRegex.FindAllString(args, -1) {
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s in filter", quoted)
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("expected a string in filter expression %q", expr)
+	}
+
+	switch function {
+	case "":
+		return func(s string) bool { return s == values[0] }, nil
+	case "starts_with":
+		return func(s string) bool { return strings.HasPrefix(s, values[0]) }, nil
+	case "ends_with":
+		return func(s string) bool { return strings.HasSuffix(s, values[0]) }, nil
+	case "one_of":
+		return func(s string) bool {
+			for _, v := range values {
+				if s == v {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported filter function %q", function)
+}
+
+// matchesResource reports whether a monitored resource passes the filter
+func (f *timeSeriesFilter) matchesResource(resource MonitoredResource) bool {
+	for key, match := range f.resource {
+		value := resource.Type
+		if key != "type" {
+			value = resource.Labels[key]
+		}
+		if !match(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// seriesAggregation is the per-series alignment of a request
+type seriesAggregation struct {
+	period  time.Duration
+	aligner string
+}
+
+func parseSeriesAggregation(r *http.Request) (*seriesAggregation, error) {
+	query := r.URL.Query()
+	agg := &seriesAggregation{aligner: query.Get("aggregation.perSeriesAligner")}
+	if reducer := query.Get("aggregation.crossSeriesReducer"); reducer != "" && reducer != "REDUCE_NONE" {
+		return nil, fmt.Errorf("unsupported crossSeriesReducer %s", reducer)
+	}
+
+	switch agg.aligner {
+	case "", "ALIGN_NONE":
+		agg.aligner = "ALIGN_NONE"
+		return agg, nil
+	case "ALIGN_MEAN", "ALIGN_MAX", "ALIGN_MIN", "ALIGN_NEXT_OLDER":
+	default:
+		return nil, fmt.Errorf("unsupported perSeriesAligner %s", agg.aligner)
+	}
+
+	period, err := time.ParseDuration(query.Get("aggregation.alignmentPeriod"))
+	if err != nil || period <= 0 {
+		return nil, errors.New("aggregation.alignmentPeriod must be a duration such as 60s")
+	}
+	agg.period = period
+	return agg, nil
+}
+
+// points turns the samples within [start, end] into points, newest first
+func (agg *seriesAggregation) points(samples []backlogSample, value func(backlogSample) int64, start, end time.Time) []Point {
+	var selected []backlogSample
+	for _, sample := range samples {
+		if !sample.time.Before(start) && !sample.time.After(end) {
+			selected = append(selected, sample)
+		}
+	}
+	// A point-in-time query returns the latest sample
+	if start.Equal(end) {
+		selected = nil
+		for _, sample := range samples {
+			if !sample.time.After(end) {
+				selected = []backlogSample{sample}
+			}
+		}
+	}
+
+	points := make([]Point, 0, len(selected))
+	if agg.aligner == "ALIGN_NONE" {
+		for i := len(selected) - 1; i >= 0; i-- {
+			t := selected[i].time.UTC().Format(time.RFC3339Nano)
+			points = append(points, Point{
+				Interval: TimeInterval{StartTime: t, EndTime: t},
+				Value:    TypedValue{Int64Value: strconv.FormatInt(value(selected[i]), 10)},
+			})
+		}
+		return points
+	}
+
+	// Align to periods ending at end, walking backwards
+	i := len(selected) - 1
+	for bucketEnd := end; i >= 0; bucketEnd = bucketEnd.Add(-agg.period) {
+		bucketStart := bucketEnd.Add(-agg.period)
+		var bucket []int64
+		for ; i >= 0 && selected[i].time.After(bucketStart); i-- {
+			bucket = append(bucket, value(selected[i])) // newest first
+		}
+		if len(bucket) == 0 {
+			continue
+		}
+
+		t := bucketEnd.UTC().Format(time.RFC3339Nano)
+		point := Point{Interval: TimeInterval{StartTime: t, EndTime: t}}
+		switch agg.aligner {
+		case "ALIGN_MEAN":
+			var sum int64
+			for _, v := range bucket {
+				sum += v
+			}
+			mean := float64(sum) / float64(len(bucket))
+			point.Value.DoubleValue = &mean
+		case "ALIGN_MAX", "ALIGN_MIN":
+			result := bucket[0]
+			for _, v := range bucket {
+				if agg.aligner == "ALIGN_MAX" && v > result || agg.aligner == "ALIGN_MIN" && v < result {
+					result = v
+				}
+			}
+			point.Value.Int64Value = strconv.FormatInt(result, 10)
+		case "ALIGN_NEXT_OLDER":
+			point.Value.Int64Value = strconv.FormatInt(bucket[0], 10)
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// parseInterval reads interval.startTime and interval.endTime. The end
+// defaults to now and the start to the end.
+func parseInterval(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	end := now
+	if value := query.Get("interval.endTime"); value != "" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("interval.endTime must be an RFC 3339 timestamp")
+		}
+		end = t
+	}
+	start := end
+	if value := query.Get("interval.startTime"); value != "" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil || t.After(end) {
+			return time.Time{}, time.Time{}, errors.New("interval.startTime must be an RFC 3339 timestamp not after endTime")
+		}
+		start = t
+	}
+	return start, end, nil
+}
+
+// handleListTimeSeries answers Cloud Monitoring timeSeries.list requests for
+// Pub/Sub subscription backlog metrics from the sampler
+func (s *Server) handleListTimeSeries(w http.ResponseWriter, r *http.Request, projectID string) {
+	query := r.URL.Query()
+
+	filter, err := parseTimeSeriesFilter(query.Get("filter"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	start, end, err := parseInterval(r, s.storage.Clock().Now())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	agg, err := parseSeriesAggregation(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	series := TimeSeries{
+		Metric:     MonitoringMetric{Type: filter.metricType},
+		MetricKind: "GAUGE",
+		ValueType:  "INT64",
+	}
+	var value func(backlogSample) int64
+	switch filter.metricType {
+	case monitoringNumUndelivered:
+		series.Unit = "1"
+		value = func(sample backlogSample) int64 { return sample.backlog }
+	case monitoringOldestUnacked:
+		series.Unit = "s"
+		value = func(sample backlogSample) int64 { return sample.oldestAge }
+	}
+	if agg.aligner == "ALIGN_MEAN" {
+		series.ValueType = "DOUBLE"
+	}
+
+	resp := ListTimeSeriesResponse{TimeSeries: []TimeSeries{}}
+	if value == nil {
+		// Valid filter for a metric without data
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	samples := s.sampler.snapshot(projectID)
+	names := sortedKeys(samples)
+	for _, name := range names {
+		resource := MonitoredResource{
+			Type: "pubsub_subscription",
+			Labels: map[string]string{
+				"project_id":      projectID,
+				"subscription_id": name[strings.LastIndex(name, "/")+1:],
+			},
+		}
+		if !filter.matchesResource(resource) {
+			continue
+		}
+		points := agg.points(samples[name], value, start, end)
+		if len(points) == 0 {
+			continue
+		}
+		ts := series
+		ts.Resource = resource
+		ts.Points = points
+		resp.TimeSeries = append(resp.TimeSeries, ts)
+	}
+
+	// Paginate over series
+	offset := 0
+	if token := query.Get("pageToken"); token != "" {
+		if offset, err = strconv.Atoi(token); err != nil || offset < 0 || offset > len(resp.TimeSeries) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": ErrInvalidPageToken.Error()})
+			return
+		}
+	}
+	resp.TimeSeries = resp.TimeSeries[offset:]
+	if pageSize, err := strconv.Atoi(query.Get("pageSize")); err == nil && pageSize > 0 && pageSize < len(resp.TimeSeries) {
+		resp.TimeSeries = resp.TimeSeries[:pageSize]
+		resp.NextPageToken = strconv.Itoa(offset + pageSize)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,143 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func listTimeSeries(t *testing.T, server *Server, params url.Values) (int, ListTimeSeriesResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/v3/projects/test/timeSeries?"+params.Encode(), nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	var resp ListTimeSeriesResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return w.Code, resp
+}
+
+func TestHandleListTimeSeries(t *testing.T) {
+	server := NewServer()
+
+	// Setup: a backlog that grows over three samples
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub2", "projects/test/topics/topic1")
+	server.storage.CreateSubscription("projects/other/subscriptions/sub1", "projects/test/topics/topic1")
+
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	for i := 0; i < 3; i++ {
+		server.storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+		server.sampler.sample(start.Add(time.Duration(i) * 10 * time.Second))
+	}
+
+	params := url.Values{
+		"filter":             {`metric.type = "pubsub.googleapis.com/subscription/num_undelivered_messages" AND resource.labels.subscription_id = "sub1"`},
+		"interval.startTime": {start.Format(time.RFC3339)},
+		"interval.endTime":   {start.Add(time.Minute).Format(time.RFC3339)},
+	}
+	code, resp := listTimeSeries(t, server, params)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if len(resp.TimeSeries) != 1 {
+		t.Fatalf("Expected 1 time series, got %d", len(resp.TimeSeries))
+	}
+	series := resp.TimeSeries[0]
+	if series.Resource.Labels["subscription_id"] != "sub1" || series.Resource.Labels["project_id"] != "test" {
+		t.Errorf("Expected resource test/sub1, got %v", series.Resource.Labels)
+	}
+	if series.MetricKind != "GAUGE" || series.ValueType != "INT64" {
+		t.Errorf("Expected INT64 gauge, got %s %s", series.ValueType, series.MetricKind)
+	}
+
+	// Newest first
+	var values []string
+	for _, point := range series.Points {
+		values = append(values, point.Value.Int64Value)
+	}
+	if len(values) != 3 || values[0] != "3" || values[2] != "1" {
+		t.Errorf("Expected points 3, 2, 1, got %v", values)
+	}
+
+	// A point-in-time query returns the latest sample
+	delete(params, "interval.startTime")
+	params.Set("filter", `metric.type = "pubsub.googleapis.com/subscription/num_undelivered_messages"`)
+	_, resp = listTimeSeries(t, server, params)
+	if len(resp.TimeSeries) != 2 {
+		t.Fatalf("Expected 2 time series in project test, got %d", len(resp.TimeSeries))
+	}
+	if points := resp.TimeSeries[0].Points; len(points) != 1 || points[0].Value.Int64Value != "3" {
+		t.Errorf("Expected the latest point only, got %+v", points)
+	}
+}
+
+func TestHandleListTimeSeries_Aggregation(t *testing.T) {
+	server := NewServer()
+
+	// Setup
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+	end := time.Now().Truncate(time.Second)
+	for i := 0; i < 4; i++ {
+		server.storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+		server.sampler.sample(end.Add(time.Duration(i-3) * 15 * time.Second))
+	}
+
+	params := url.Values{
+		"filter":                         {`metric.type = "pubsub.googleapis.com/subscription/num_undelivered_messages" AND resource.type = "pubsub_subscription"`},
+		"interval.startTime":             {end.Add(-time.Minute).Format(time.RFC3339)},
+		"interval.endTime":               {end.Format(time.RFC3339)},
+		"aggregation.alignmentPeriod":    {"30s"},
+		"aggregation.perSeriesAligner":   {"ALIGN_MEAN"},
+		"aggregation.crossSeriesReducer": {"REDUCE_NONE"},
+	}
+	code, resp := listTimeSeries(t, server, params)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	points := resp.TimeSeries[0].Points
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 aligned points, got %d", len(points))
+	}
+	if points[0].Value.DoubleValue == nil || *points[0].Value.DoubleValue != 3.5 {
+		t.Errorf("Expected mean 3.5 for the latest period, got %+v", points[0].Value)
+	}
+
+	params.Set("aggregation.perSeriesAligner", "ALIGN_MAX")
+	_, resp = listTimeSeries(t, server, params)
+	if got := resp.TimeSeries[0].Points[1].Value.Int64Value; got != "2" {
+		t.Errorf("Expected max 2 for the earlier period, got %s", got)
+	}
+}
+
+func TestHandleListTimeSeries_InvalidFilter(t *testing.T) {
+	server := NewServer()
+
+	for _, filter := range []string{
+		"",
+		`resource.type = "pubsub_subscription"`,
+		`metric.type = "pubsub.googleapis.com/subscription/num_undelivered_messages" AND metric.labels.x = "y"`,
+		`metric.type = "pubsub.googleapis.com/subscription/num_undelivered_messages" AND resource.labels.subscription_id = has("x")`,
+	} {
+		code, _ := listTimeSeries(t, server, url.Values{"filter": {filter}})
+		if code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for filter %q, got %d", http.StatusBadRequest, filter, code)
+		}
+	}
+
+	// Valid filters for metrics without data return no series
+	code, resp := listTimeSeries(t, server, url.Values{"filter": {`metric.type = "pubsub.googleapis.com/topic/byte_cost"`}})
+	if code != http.StatusOK || len(resp.TimeSeries) != 0 {
+		t.Errorf("Expected empty result, got %d with %d series", code, len(resp.TimeSeries))
+	}
+}
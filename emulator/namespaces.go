@@ -0,0 +1,370 @@
+package emulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// NamespaceHeader selects the namespace of a request, as an alternative to
+// the /ns/{namespace} path prefix
+const NamespaceHeader = "X-Emulator-Namespace"
+
+// namespaceNamePattern matches the names a namespace can have
+const namespaceNamePattern = `[A-Za-z0-9][A-Za-z0-9_.-]{0,62}`
+
+var (
+	namespacePrefixRegex = regexp.MustCompile(`^/ns/([^/]+)(/.*)$`)
+	namespaceNameRegex   = regexp.MustCompile(`^` + namespaceNamePattern + `$`)
+)
+
+// namespace is an isolated emulator within the emulator: its own storage with
+// its own topics, subscriptions and messages, served by its own Server
+type namespace struct {
+	server     *Server
+	storage    Backend
+	createdAt  time.Time
+	stopSample context.CancelFunc
+}
+
+// namespaces holds the namespaces of the top-level Server. They are created
+// by the first request that can add something to them; reads and deletes in
+// a missing namespace see an empty one.
+type namespaces struct {
+	byName   map[string]*namespace
+	deleting map[string]chan struct{} // closed once a deleted namespace is gone
+	mu       sync.Mutex
+
+	// How the storage of a namespace is set up, after the emulator's options
+	open           func(name string) (Backend, error)
+	remove         func(name string) error // deletes what open persisted
+	limits         bool                    // whether what open returns is a Limiter
+	idSeed         uint64
+	sampleInterval time.Duration
+}
+
+func newNamespaces() *namespaces {
+	return &namespaces{
+		byName:   make(map[string]*namespace),
+		deleting: make(map[string]chan struct{}),
+		open:     func(string) (Backend, error) { return NewStorage(), nil },
+		remove:   func(string) error { return nil },
+		limits:   true,
+	}
+}
+
+// configureNamespaces makes namespaces use the backend, data directory, ID
+// seed and sample interval of the emulator, and opens the namespaces
+// persisted in the data directory
+func (s *Server) configureNamespaces(opts Options) error {
+	ns := s.namespaces
+	ns.idSeed = opts.IDSeed
+	ns.sampleInterval = opts.SampleInterval
+	if opts.DataDir == "" {
+		return nil
+	}
+
+	dir := filepath.Join(opts.DataDir, "namespaces")
+	ns.limits = opts.Backend == "memory"
+	ns.open = func(name string) (Backend, error) {
+		return openBackend(opts.Backend, filepath.Join(dir, name), opts.CompactInterval)
+	}
+	ns.remove = func(name string) error {
+		return os.RemoveAll(filepath.Join(dir, name))
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !namespaceNameRegex.MatchString(entry.Name()) {
+			continue
+		}
+		if _, err := s.namespace(entry.Name(), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NamespaceInfo describes a namespace
+type NamespaceInfo struct {
+	Name          string    `json:"name"`
+	Topics        int       `json:"topics"`
+	Subscriptions int       `json:"subscriptions"`
+	Limits        Limits    `json:"limits"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// ListNamespacesResponse is the response for listing namespaces
+type ListNamespacesResponse struct {
+	Namespaces []NamespaceInfo `json:"namespaces"`
+}
+
+// namespaceOf returns the namespace a request is addressed to and the request
+// with the namespace prefix removed from its path and the namespace in its
+// header instead, so that it is recorded. The name is empty for the shared
+// view.
+func namespaceOf(r *http.Request) (string, *http.Request, bool) {
+	name := r.Header.Get(NamespaceHeader)
+	matches := namespacePrefixRegex.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		return name, r, name == "" || namespaceNameRegex.MatchString(name)
+	}
+	if !namespaceNameRegex.MatchString(matches[1]) || (name != "" && name != matches[1]) {
+		return "", r, false
+	}
+
+	stripped := r.WithContext(r.Context())
+	u := *r.URL
+	u.Path, u.RawPath = matches[2], ""
+	stripped.URL = &u
+	stripped.Header = r.Header.Clone()
+	stripped.Header.Set(NamespaceHeader, matches[1])
+	return matches[1], stripped, true
+}
+
+// serveNamespace hands a request to the namespace it is addressed to and
+// reports whether it did so
+func (s *Server) serveNamespace(w http.ResponseWriter, r *http.Request) bool {
+	if s.namespaces == nil {
+		return false // a namespace has no namespaces of its own
+	}
+	name, r, ok := namespaceOf(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid namespace"})
+		return true
+	}
+	if name == "" {
+		return false
+	}
+	create := r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodDelete
+	ns, err := s.namespace(name, create)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return true
+	}
+	if ns == nil {
+		s.emptyNamespace(name).ServeHTTP(w, r)
+		return true
+	}
+	ns.server.ServeHTTP(w, r)
+	return true
+}
+
+// emptyNamespace returns a server for a namespace that does not exist, which
+// answers like a new one but keeps nothing
+func (s *Server) emptyNamespace(name string) *Server {
+	storage := NewStorage()
+	storage.SetClock(s.storage.Clock())
+	server := NewServerWithStorage(storage)
+	server.namespaces = nil
+	if s.tracer != nil {
+		server.EnableTracing(s.tracer.forNamespace(name))
+	}
+	server.recorder = s.recorder
+	return server
+}
+
+// namespace returns a namespace, creating it if create is set, or nil
+func (s *Server) namespace(name string, create bool) (*namespace, error) {
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+
+	// A namespace being deleted is not opened again before its files are
+	// gone, or the delete would remove those of the new one
+	for done := s.namespaces.deleting[name]; done != nil; done = s.namespaces.deleting[name] {
+		s.namespaces.mu.Unlock()
+		<-done
+		s.namespaces.mu.Lock()
+	}
+
+	ns, ok := s.namespaces.byName[name]
+	if ok || !create {
+		return ns, nil
+	}
+
+	storage, err := s.namespaces.open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open namespace %s: %w", name, err)
+	}
+	storage.SetClock(s.storage.Clock())
+	if s.namespaces.idSeed != 0 {
+		storage.SetIDGenerator(NewSequentialIDs(s.namespaces.idSeed))
+	}
+	ns = &namespace{server: NewServerWithStorage(storage), storage: storage, createdAt: time.Now()}
+	ns.server.namespaces = nil
+	if s.tracer != nil {
+		ns.server.EnableTracing(s.tracer.forNamespace(name))
+	}
+	ns.server.recorder = s.recorder
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ns.stopSample = cancel
+	if s.namespaces.sampleInterval > 0 {
+		go ns.server.sampler.Run(ctx, s.namespaces.sampleInterval)
+	}
+
+	s.namespaces.byName[name] = ns
+	logger.Info("namespace created", "operation", "create_namespace", "namespace", name)
+	return ns, nil
+}
+
+// close stops sampling and closes the storage of a namespace
+func (ns *namespace) close() error {
+	ns.stopSample()
+	return ns.storage.Close()
+}
+
+// closeNamespaces closes the storage of every namespace
+func (s *Server) closeNamespaces() error {
+	if s.namespaces == nil {
+		return nil
+	}
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+
+	var errs []error
+	for name, ns := range s.namespaces.byName {
+		if err := ns.close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close namespace %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeTails ends the tail streams of the server and its namespaces
+func (s *Server) closeTails() {
+	s.tails.Close()
+	if s.namespaces == nil {
+		return
+	}
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+	for _, ns := range s.namespaces.byName {
+		ns.server.tails.Close()
+	}
+}
+
+func (ns *namespace) info(name string) NamespaceInfo {
+	info := NamespaceInfo{
+		Name:          name,
+		Topics:        len(ns.storage.ListTopics()),
+		Subscriptions: len(ns.storage.ListSubscriptions()),
+		CreatedAt:     ns.createdAt,
+	}
+	if limiter, ok := ns.storage.(Limiter); ok {
+		info.Limits = limiter.Limits()
+	}
+	return info
+}
+
+func (s *Server) handleListNamespaces(w http.ResponseWriter, r *http.Request) {
+	s.namespaces.mu.Lock()
+	names := make([]string, 0, len(s.namespaces.byName))
+	all := make(map[string]*namespace, len(s.namespaces.byName))
+	for name, ns := range s.namespaces.byName {
+		names = append(names, name)
+		all[name] = ns
+	}
+	s.namespaces.mu.Unlock()
+
+	slices.Sort(names)
+	resp := ListNamespacesResponse{Namespaces: make([]NamespaceInfo, 0, len(names))}
+	for _, name := range names {
+		resp.Namespaces = append(resp.Namespaces, all[name].info(name))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGetNamespace(w http.ResponseWriter, r *http.Request, name string) {
+	ns, _ := s.namespace(name, false)
+	if ns == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "namespace not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, ns.info(name))
+}
+
+// handleDeleteNamespace drops a namespace with everything in it. Requests
+// addressed to it afterwards start over with an empty namespace.
+func (s *Server) handleDeleteNamespace(w http.ResponseWriter, r *http.Request, name string) {
+	s.namespaces.mu.Lock()
+	ns, ok := s.namespaces.byName[name]
+	if !ok {
+		s.namespaces.mu.Unlock()
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "namespace not found"})
+		return
+	}
+	delete(s.namespaces.byName, name)
+	done := make(chan struct{})
+	s.namespaces.deleting[name] = done
+	s.namespaces.mu.Unlock()
+	defer func() {
+		s.namespaces.mu.Lock()
+		delete(s.namespaces.deleting, name)
+		s.namespaces.mu.Unlock()
+		close(done)
+	}()
+
+	// Ends the message spans of the namespace and whatever else observes it
+	err := ns.storage.Reset("")
+	ns.server.tails.Close()
+	if err == nil {
+		err = ns.close()
+	}
+	if err == nil {
+		err = s.namespaces.remove(name)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	logger.Info("namespace deleted", "operation", "delete_namespace", "namespace", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetNamespaceLimits replaces the limits of a namespace, creating it if
+// needed so that limits can be in place before the first request
+func (s *Server) handleSetNamespaceLimits(w http.ResponseWriter, r *http.Request, name string) {
+	var limits Limits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if err := limits.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if !s.namespaces.limits {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "limits are not supported by this backend"})
+		return
+	}
+	ns, err := s.namespace(name, true)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	ns.storage.(Limiter).SetLimits(limits)
+	logger.Info("namespace limits set",
+		"operation", "set_namespace_limits",
+		"namespace", name,
+		"max_topics", limits.MaxTopics,
+		"max_subscriptions", limits.MaxSubscriptions,
+		"max_backlog", limits.MaxBacklog)
+	writeJSON(w, http.StatusOK, ns.info(name))
+}
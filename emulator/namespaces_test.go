@@ -0,0 +1,385 @@
+package emulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveNamespaced sends a request, in a namespace if the header is set
+func serveNamespaced(server *Server, method, path, header, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if header != "" {
+		req.Header.Set(NamespaceHeader, header)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func TestNamespaces_Isolation(t *testing.T) {
+	server := NewServer()
+
+	// The same topic in the shared view and in two namespaces
+	for _, prefix := range []string{"", "/ns/a", "/ns/b"} {
+		if w := serveNamespaced(server, http.MethodPut, prefix+"/v1/projects/test/topics/topic1", "", ""); w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d: %s", prefix, http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+	if w := serveNamespaced(server, http.MethodPut, "/v1/projects/test/topics/topic1", "a", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected the header to address the same namespace as the prefix, got %d", w.Code)
+	}
+
+	serveNamespaced(server, http.MethodPut, "/v1/projects/test/subscriptions/sub1", "a", `{"topic": "projects/test/topics/topic1"}`)
+	serveNamespaced(server, http.MethodPost, "/ns/a/v1/projects/test/topics/topic1:publish", "", `{"messages": [{"data": "aGVsbG8="}]}`)
+
+	w := serveNamespaced(server, http.MethodPost, "/ns/a/v1/projects/test/subscriptions/sub1:pull", "", `{"maxMessages": 10}`)
+	var pulled PullResponse
+	json.NewDecoder(w.Body).Decode(&pulled)
+	if len(pulled.ReceivedMessages) != 1 {
+		t.Errorf("Expected the message in namespace a, got %d", len(pulled.ReceivedMessages))
+	}
+	for _, prefix := range []string{"", "/ns/b"} {
+		if w := serveNamespaced(server, http.MethodGet, prefix+"/v1/projects/test/subscriptions/sub1", "", ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected the subscription of namespace a to be invisible, got %d", prefix, w.Code)
+		}
+	}
+
+	// Admin endpoints work within a namespace too
+	serveNamespaced(server, http.MethodPost, "/ns/b/admin/v1:reset", "", "")
+	if _, err := server.storage.GetTopic("projects/test/topics/topic1"); err != nil {
+		t.Errorf("Expected a namespace reset to keep the shared view, got %v", err)
+	}
+}
+
+func TestNamespaces_InvalidNamespace(t *testing.T) {
+	server := NewServer()
+
+	tests := []struct {
+		path   string
+		header string
+	}{
+		{"/ns/-a/v1/projects/test/topics", ""},
+		{"/v1/projects/test/topics", "a/b"},
+		{"/ns/a/v1/projects/test/topics", "b"},
+	}
+	for _, tt := range tests {
+		if w := serveNamespaced(server, http.MethodGet, tt.path, tt.header, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s %q: expected status %d, got %d", tt.path, tt.header, http.StatusBadRequest, w.Code)
+		}
+	}
+	// Namespaces do not nest
+	if w := serveNamespaced(server, http.MethodGet, "/ns/a/ns/b/v1/projects/test/topics", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestNamespaces_Admin(t *testing.T) {
+	server := NewServer()
+	serveNamespaced(server, http.MethodPut, "/v1/projects/test/topics/topic1", "b", "")
+	serveNamespaced(server, http.MethodPut, "/v1/projects/test/topics/topic1", "a", "")
+
+	w := serveNamespaced(server, http.MethodGet, "/admin/v1/namespaces", "", "")
+	var list ListNamespacesResponse
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Namespaces) != 2 || list.Namespaces[0].Name != "a" || list.Namespaces[0].Topics != 1 {
+		t.Fatalf("Expected namespaces a and b, got %+v", list.Namespaces)
+	}
+
+	if w := serveNamespaced(server, http.MethodDelete, "/admin/v1/namespaces/a", "", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := serveNamespaced(server, http.MethodGet, "/admin/v1/namespaces/a", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the namespace to be gone, got %d", w.Code)
+	}
+	if w := serveNamespaced(server, http.MethodDelete, "/admin/v1/namespaces/a", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := serveNamespaced(server, http.MethodGet, "/v1/projects/test/topics/topic1", "a", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a deleted namespace to start over empty, got %d", w.Code)
+	}
+
+	// The namespaces API only exists in the shared view
+	if w := serveNamespaced(server, http.MethodGet, "/admin/v1/namespaces", "b", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestNamespaces_ReadsDoNotCreate(t *testing.T) {
+	server := NewServer()
+
+	w := serveNamespaced(server, http.MethodGet, "/ns/a/v1/projects/test/topics", "", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"topics":[]}` {
+		t.Errorf("Expected an empty namespace, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serveNamespaced(server, http.MethodDelete, "/v1/projects/test/topics/topic1", "a", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if ns, _ := server.namespace("a", false); ns != nil {
+		t.Fatal("Expected reads and deletes not to create the namespace")
+	}
+
+	serveNamespaced(server, http.MethodPut, "/ns/a/v1/projects/test/topics/topic1", "", "")
+	if ns, _ := server.namespace("a", false); ns == nil {
+		t.Error("Expected creating a topic to create the namespace")
+	}
+}
+
+func TestNamespaces_FaultsAndMetrics(t *testing.T) {
+	server := NewServer()
+	serveNamespaced(server, http.MethodPut, "/ns/a/v1/projects/test/topics/topic1", "", "")
+	serveNamespaced(server, http.MethodPost, "/ns/a/v1/projects/test/topics/topic1:publish", "", `{"messages": [{"data": "aGVsbG8="}]}`)
+
+	// Metrics are served per namespace
+	if w := serveNamespaced(server, http.MethodGet, "/metrics", "", ""); strings.Contains(w.Body.String(), "topic1") {
+		t.Error("Expected the shared metrics not to include the namespace")
+	}
+	if w := serveNamespaced(server, http.MethodGet, "/ns/a/metrics", "", ""); !strings.Contains(w.Body.String(), `topic="projects/test/topics/topic1"`) {
+		t.Errorf("Expected the namespace's metrics to include its topic, got:\n%s", w.Body.String())
+	}
+
+	// So are fault rules
+	serveNamespaced(server, http.MethodPost, "/ns/a/admin/v1/faults", "", `{"methods": ["publish"], "error": "UNAVAILABLE"}`)
+	serveNamespaced(server, http.MethodPut, "/v1/projects/test/topics/topic1", "", "")
+	if w := serveNamespaced(server, http.MethodPost, "/v1/projects/test/topics/topic1:publish", "", `{"messages": [{"data": "aGVsbG8="}]}`); w.Code != http.StatusOK {
+		t.Errorf("Expected the namespace's fault rule not to apply to the shared view, got %d", w.Code)
+	}
+	if w := serveNamespaced(server, http.MethodPost, "/ns/a/v1/projects/test/topics/topic1:publish", "", `{"messages": [{"data": "aGVsbG8="}]}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the namespace's fault rule to apply, got %d", w.Code)
+	}
+}
+
+func TestNamespaces_Limits(t *testing.T) {
+	server := NewServer()
+
+	w := serveNamespaced(server, http.MethodPut, "/admin/v1/namespaces/ci/limits", "", `{"maxTopics": 1, "maxSubscriptions": 1, "maxBacklog": 2}`)
+	var info NamespaceInfo
+	json.NewDecoder(w.Body).Decode(&info)
+	if w.Code != http.StatusOK || info.Limits.MaxTopics != 1 || info.Limits.MaxBacklog != 2 {
+		t.Fatalf("Expected the limits to be set, got %d %+v", w.Code, info)
+	}
+	if w := serveNamespaced(server, http.MethodPut, "/admin/v1/namespaces/ci/limits", "", `{"maxTopics": -1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a negative limit, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	serveNamespaced(server, http.MethodPut, "/v1/projects/test/topics/topic1", "ci", "")
+	if w := serveNamespaced(server, http.MethodPut, "/v1/projects/test/topics/topic2", "ci", ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d beyond maxTopics, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	serveNamespaced(server, http.MethodPut, "/v1/projects/test/subscriptions/sub1", "ci", `{"topic": "projects/test/topics/topic1"}`)
+	if w := serveNamespaced(server, http.MethodPut, "/v1/projects/test/subscriptions/sub2", "ci", `{"topic": "projects/test/topics/topic1"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d beyond maxSubscriptions, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	publish := `{"messages": [{"data": "aGVsbG8="}, {"data": "aGVsbG8="}]}`
+	if w := serveNamespaced(server, http.MethodPost, "/v1/projects/test/topics/topic1:publish", "ci", publish); w.Code != http.StatusOK {
+		t.Errorf("Expected status %d within maxBacklog, got %d", http.StatusOK, w.Code)
+	}
+	if w := serveNamespaced(server, http.MethodPost, "/v1/projects/test/topics/topic1:publish", "ci", publish); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d beyond maxBacklog, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	// The shared view is unlimited
+	for _, name := range []string{"topic1", "topic2"} {
+		if w := serveNamespaced(server, http.MethodPut, "/v1/projects/test/topics/"+name, "", ""); w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+	}
+}
+
+func TestStorage_Limits(t *testing.T) {
+	storage := NewStorage()
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateTopic("projects/test/topics/topic2")
+
+	// Lowering a limit keeps what exists
+	storage.SetLimits(Limits{MaxTopics: 1})
+	if len(storage.ListTopics()) != 2 {
+		t.Errorf("Expected 2 topics, got %d", len(storage.ListTopics()))
+	}
+	if _, err := storage.CreateTopic("projects/test/topics/topic3"); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected ErrLimitExceeded, got %v", err)
+	}
+	storage.DeleteTopic("projects/test/topics/topic2")
+	storage.DeleteTopic("projects/test/topics/topic1")
+	if _, err := storage.CreateTopic("projects/test/topics/topic3"); err != nil {
+		t.Errorf("Expected no error below the limit, got %v", err)
+	}
+}
+
+func TestNamespaces_Options(t *testing.T) {
+	for _, backend := range []string{"memory", "bolt"} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := Options{Backend: backend, DataDir: dir, IDSeed: 1, SampleInterval: time.Minute, VirtualClock: true}
+			emu, err := Start(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			serveNamespaced(emu.server, http.MethodPut, "/ns/a/v1/projects/test/topics/topic1", "", "")
+			serveNamespaced(emu.server, http.MethodPut, "/ns/a/v1/projects/test/subscriptions/sub1", "", `{"topic": "projects/test/topics/topic1"}`)
+			w := serveNamespaced(emu.server, http.MethodPost, "/ns/a/v1/projects/test/topics/topic1:publish", "", `{"messages": [{"data": "aGVsbG8="}]}`)
+			var published PublishResponse
+			json.Unmarshal(w.Body.Bytes(), &published)
+			if len(published.MessageIDs) != 1 || published.MessageIDs[0] != "1" {
+				t.Errorf("Expected sequential message IDs in the namespace, got %v", published.MessageIDs)
+			}
+
+			// The first sample may have been taken before sub1 existed, so
+			// move on to the next until the sampler has started ticking
+			ns, _ := emu.server.namespace("a", false)
+			clock := emu.Storage().Clock().(*VirtualClock)
+			deadline := time.Now().Add(time.Second)
+			for len(ns.server.sampler.snapshot("test")) == 0 && time.Now().Before(deadline) {
+				clock.Advance(time.Minute)
+				time.Sleep(time.Millisecond)
+			}
+			if len(ns.server.sampler.snapshot("test")) == 0 {
+				t.Error("Expected the namespace's backlog to be sampled")
+			}
+
+			// The namespace is persisted next to the shared view
+			if err := emu.Close(); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			emu, err = Start(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer emu.Close()
+
+			if ns, _ = emu.server.namespace("a", false); ns == nil {
+				t.Fatal("Expected the namespace to be reopened")
+			}
+			if stats, err := ns.storage.BacklogStats("projects/test/subscriptions/sub1"); err != nil || stats.Backlog != 1 {
+				t.Errorf("Expected the namespace's backlog to survive a restart, got %+v, %v", stats, err)
+			}
+
+			if w := serveNamespaced(emu.server, http.MethodDelete, "/admin/v1/namespaces/a", "", ""); w.Code != http.StatusNoContent {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+			}
+			if _, err := os.Stat(filepath.Join(dir, "namespaces", "a")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Expected the namespace's data to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNamespaces_Tracing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tracer := NewTracer(exporter)
+	server := NewServer()
+	server.EnableTracing(tracer)
+
+	for _, name := range []string{"a", "b"} {
+		serveNamespaced(server, http.MethodPut, "/v1/projects/test/topics/topic1", name, "")
+		serveNamespaced(server, http.MethodPut, "/v1/projects/test/subscriptions/sub1", name, `{"topic": "projects/test/topics/topic1"}`)
+		serveNamespaced(server, http.MethodPost, "/v1/projects/test/topics/topic1:publish", name, `{"messages": [{"data": "aGVsbG8="}]}`)
+	}
+
+	// Resetting a namespace ends the message spans of that namespace only
+	if w := serveNamespaced(server, http.MethodPost, "/admin/v1:reset", "a", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	a, _ := server.namespace("a", false)
+	b, _ := server.namespace("b", false)
+	if a.server.tracer.open != 0 || b.server.tracer.open != 1 {
+		t.Errorf("Expected open message spans only in b, got %d in a and %d in b", a.server.tracer.open, b.server.tracer.open)
+	}
+
+	// So does deleting it
+	if w := serveNamespaced(server, http.MethodDelete, "/admin/v1/namespaces/b", "", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	if b.server.tracer.open != 0 {
+		t.Errorf("Expected no open message spans in a deleted namespace, got %d", b.server.tracer.open)
+	}
+
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	namespaces := map[string]bool{}
+	for _, message := range spansByName(readTraceFile(t, path))["projects/test/subscriptions/sub1 message"] {
+		namespaces[spanAttribute(message, "emulator.namespace")] = true
+	}
+	if len(namespaces) != 2 || !namespaces["a"] || !namespaces["b"] {
+		t.Errorf("Expected the message spans of namespaces a and b, got %v", namespaces)
+	}
+}
+
+func TestNamespaces_DeleteWhileRecreating(t *testing.T) {
+	for _, backend := range []string{"memory", "bolt"} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := Options{Backend: backend, DataDir: dir}
+			emu, err := Start(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			for i := 0; i < 100; i++ {
+				serveNamespaced(emu.server, http.MethodPut, "/ns/a/v1/projects/test/topics/topic1", "", "")
+
+				// The topic is created either before the namespace is deleted,
+				// and goes with it, or in a new namespace afterwards
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					serveNamespaced(emu.server, http.MethodDelete, "/admin/v1/namespaces/a", "", "")
+				}()
+				serveNamespaced(emu.server, http.MethodPut, "/ns/a/v1/projects/test/topics/topic2", "", "")
+				<-done
+
+				if ns, _ := emu.server.namespace("a", false); ns != nil {
+					if _, err := os.Stat(filepath.Join(dir, "namespaces", "a")); err != nil {
+						t.Fatalf("Expected the recreated namespace to keep its data, got %v", err)
+					}
+				}
+			}
+
+			serveNamespaced(emu.server, http.MethodPut, "/ns/a/v1/projects/test/topics/topic3", "", "")
+			if err := emu.Close(); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			emu, err = Start(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer emu.Close()
+			if w := serveNamespaced(emu.server, http.MethodGet, "/ns/a/v1/projects/test/topics/topic3", "", ""); w.Code != http.StatusOK {
+				t.Errorf("Expected the namespace to survive a restart, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestNamespaces_LimitsUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	emu, err := Start(context.Background(), Options{Backend: "bolt", DataDir: dir})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer emu.Close()
+
+	if w := serveNamespaced(emu.server, http.MethodPut, "/admin/v1/namespaces/ci/limits", "", `{"maxTopics": 1}`); w.Code != http.StatusNotImplemented {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotImplemented, w.Code)
+	}
+	if ns, _ := emu.server.namespace("ci", false); ns != nil {
+		t.Error("Expected no namespace to be created")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "namespaces", "ci")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected nothing to be persisted, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package emulator
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// Message states reported by PeekMessages and accepted by PeekFilter
+const (
+	PeekStateAvailable = "available" // deliverable by the next pull
+	PeekStateLeased    = "leased"    // handed out and waiting for an ack
+)
+
+const (
+	defaultPeekPageSize = 100
+	maxPeekPageSize     = 1000
+	peekPreviewBytes    = 256
+)
+
+// PeekFilter selects the messages returned by PeekMessages. Empty fields
+// match everything.
+type PeekFilter struct {
+	State      string
+	Attributes map[string]string // all must match
+}
+
+// PeekedMessage describes a message in a subscription's backlog without
+// leasing it
+type PeekedMessage struct {
+	MessageID        string            `json:"messageId"`
+	PublishTime      string            `json:"publishTime"`
+	Attributes       map[string]string `json:"attributes,omitempty"`
+	Data             string            `json:"data"`        // base64 encoded
+	DataPreview      string            `json:"dataPreview"` // decoded, truncated text
+	AckID            string            `json:"ackId"`
+	State            string            `json:"state"`
+	DeadlineAt       *time.Time        `json:"deadlineAt,omitempty"`
+	DeliveryAttempts int               `json:"deliveryAttempts"`
+}
+
+// PeekMessagesResponse is the response for peeking at a subscription
+type PeekMessagesResponse struct {
+	Messages      []PeekedMessage `json:"messages"`
+	NextPageToken string          `json:"nextPageToken,omitempty"`
+}
+
+// MessagePeeker is implemented by backends that can list a subscription's
+// backlog without changing it
+type MessagePeeker interface {
+	PeekMessages(subscriptionName string, filter PeekFilter, pageSize int, pageToken string) ([]PeekedMessage, string, error)
+}
+
+var _ MessagePeeker = (*Storage)(nil)
+
+// PeekMessages lists the messages of a subscription in delivery order without
+// touching their leases. The page token identifies the last message returned,
+// so the next page starts right after it even if messages were acknowledged
+// in between.
+func (s *Storage) PeekMessages(subscriptionName string, filter PeekFilter, pageSize int, pageToken string) ([]PeekedMessage, string, error) {
+	var after uint64
+	if pageToken != "" {
+		var err error
+		after, err = strconv.ParseUint(pageToken, 10, 64)
+		if err != nil {
+			return nil, "", ErrInvalidPageToken
+		}
+	}
+	if pageSize <= 0 {
+		pageSize = defaultPeekPageSize
+	}
+	pageSize = min(pageSize, maxPeekPageSize)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, err := s.lockSubscription(subscriptionName)
+	if err != nil {
+		return nil, "", err
+	}
+	defer state.mu.Unlock()
+
+	// Records are kept in seq order
+	start, _ := slices.BinarySearchFunc(state.messages, after+1, func(msg *InternalMessage, seq uint64) int {
+		return cmp.Compare(msg.seq, seq)
+	})
+
+	now := s.clock.Now()
+	peeked := make([]PeekedMessage, 0, min(pageSize, len(state.messages)))
+	var last uint64
+	for _, msg := range state.messages[start:] {
+		if msg.AckedAt != nil || !filter.matches(msg, now) {
+			continue
+		}
+		if len(peeked) == pageSize {
+			return peeked, strconv.FormatUint(last, 10), nil
+		}
+		peeked = append(peeked, peekMessage(msg, now))
+		last = msg.seq
+	}
+	return peeked, "", nil
+}
+
+// peekState returns the state of a delivery record at now
+func peekState(msg *InternalMessage, now time.Time) string {
+	if msg.DeadlineAt.Before(now) {
+		return PeekStateAvailable
+	}
+	return PeekStateLeased
+}
+
+func (f PeekFilter) matches(msg *InternalMessage, now time.Time) bool {
+	if f.State != "" && f.State != peekState(msg, now) {
+		return false
+	}
+	for key, value := range f.Attributes {
+		if v, ok := msg.body.message.Attributes[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func peekMessage(msg *InternalMessage, now time.Time) PeekedMessage {
+	m := msg.message()
+	peeked := PeekedMessage{
+		MessageID:        m.MessageID,
+		PublishTime:      m.PublishTime,
+		Attributes:       m.Attributes,
+		Data:             m.Data,
+		DataPreview:      dataPreview(m.Data),
+		AckID:            msg.AckID,
+		State:            peekState(msg, now),
+		DeliveryAttempts: msg.DeliveryAttempts,
+	}
+	if !msg.DeadlineAt.IsZero() {
+		deadline := msg.DeadlineAt
+		peeked.DeadlineAt = &deadline
+	}
+	return peeked
+}
+
+// dataPreview decodes message data for display. Binary payloads are shown
+// with replacement characters; long payloads are truncated.
+func dataPreview(data string) string {
+	decoded, err := DecodeData(data)
+	if err != nil {
+		return ""
+	}
+	truncated := len(decoded) > peekPreviewBytes
+	if truncated {
+		decoded = decoded[:peekPreviewBytes]
+		// Do not leave a character cut in half at the end
+		for i := len(decoded) - 1; i >= 0 && i >= len(decoded)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(decoded[i]) {
+				if !utf8.FullRune(decoded[i:]) {
+					decoded = decoded[:i]
+				}
+				break
+			}
+		}
+	}
+	preview := strings.ToValidUTF8(string(decoded), "�")
+	if truncated {
+		preview += "…"
+	}
+	return preview
+}
@@ -0,0 +1,201 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStorage_PeekMessages(t *testing.T) {
+	storage := NewStorage()
+
+	// Setup
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{
+		{Data: EncodeData([]byte("first")), Attributes: map[string]string{"env": "prod"}},
+		{Data: EncodeData([]byte("second")), Attributes: map[string]string{"env": "dev"}},
+		{Data: EncodeData([]byte("third")), Attributes: map[string]string{"env": "prod"}},
+	})
+	storage.Pull("projects/test/subscriptions/sub1", 1)
+
+	peeked, next, err := storage.PeekMessages("projects/test/subscriptions/sub1", PeekFilter{}, 0, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(peeked) != 3 || next != "" {
+		t.Fatalf("Expected 3 messages and no next page, got %d and %q", len(peeked), next)
+	}
+	if peeked[0].State != PeekStateLeased || peeked[0].DeliveryAttempts != 1 || peeked[0].DeadlineAt == nil {
+		t.Errorf("Expected first message to be leased once, got %+v", peeked[0])
+	}
+	if peeked[1].State != PeekStateAvailable || peeked[1].DeadlineAt != nil {
+		t.Errorf("Expected second message to be available, got %+v", peeked[1])
+	}
+	if peeked[1].DataPreview != "second" {
+		t.Errorf("Expected preview 'second', got %q", peeked[1].DataPreview)
+	}
+
+	// Peeking does not lease
+	pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+	if len(pulled) != 2 {
+		t.Errorf("Expected 2 messages after peek, got %d", len(pulled))
+	}
+}
+
+func TestStorage_PeekMessages_Filter(t *testing.T) {
+	storage := NewStorage()
+
+	// Setup
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{
+		{Data: "dGVzdDE=", Attributes: map[string]string{"env": "prod"}},
+		{Data: "dGVzdDI=", Attributes: map[string]string{"env": "dev"}},
+		{Data: "dGVzdDM=", Attributes: map[string]string{"env": "prod"}},
+	})
+	storage.Pull("projects/test/subscriptions/sub1", 1)
+
+	filter := PeekFilter{State: PeekStateAvailable, Attributes: map[string]string{"env": "prod"}}
+	peeked, _, err := storage.PeekMessages("projects/test/subscriptions/sub1", filter, 0, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(peeked) != 1 || peeked[0].Data != "dGVzdDM=" {
+		t.Errorf("Expected only the third message, got %+v", peeked)
+	}
+}
+
+func TestStorage_PeekMessages_Pagination(t *testing.T) {
+	storage := NewStorage()
+
+	// Setup
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	messages := make([]PubSubMessage, 5)
+	for i := range messages {
+		messages[i] = PubSubMessage{Data: "dGVzdA=="}
+	}
+	ids, _ := storage.Publish("projects/test/topics/topic1", messages)
+
+	var seen []string
+	token := ""
+	for page := 0; ; page++ {
+		peeked, next, err := storage.PeekMessages("projects/test/subscriptions/sub1", PeekFilter{}, 2, token)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(peeked) > 2 {
+			t.Fatalf("Expected at most 2 messages per page, got %d", len(peeked))
+		}
+		for _, m := range peeked {
+			seen = append(seen, m.MessageID)
+		}
+		if next == "" {
+			break
+		}
+		if page > 5 {
+			t.Fatal("Expected pagination to end")
+		}
+		token = next
+	}
+
+	if strings.Join(seen, ",") != strings.Join(ids, ",") {
+		t.Errorf("Expected messages %v in order, got %v", ids, seen)
+	}
+
+	if _, _, err := storage.PeekMessages("projects/test/subscriptions/sub1", PeekFilter{}, 2, "bogus"); err != ErrInvalidPageToken {
+		t.Errorf("Expected ErrInvalidPageToken, got %v", err)
+	}
+}
+
+func TestStorage_PeekMessages_PaginationAfterAck(t *testing.T) {
+	storage := NewStorage()
+
+	// Setup
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	messages := make([]PubSubMessage, 6)
+	for i := range messages {
+		messages[i] = PubSubMessage{Data: "dGVzdA=="}
+	}
+	ids, _ := storage.Publish("projects/test/topics/topic1", messages)
+
+	first, token, _ := storage.PeekMessages("projects/test/subscriptions/sub1", PeekFilter{}, 3, "")
+	if len(first) != 3 || token == "" {
+		t.Fatalf("Expected a first page of 3, got %d", len(first))
+	}
+
+	// Acknowledging messages of the first page must not shift the second
+	storage.Acknowledge("projects/test/subscriptions/sub1", []string{first[0].AckID, first[2].AckID})
+
+	second, token, _ := storage.PeekMessages("projects/test/subscriptions/sub1", PeekFilter{}, 3, token)
+	var seen []string
+	for _, m := range second {
+		seen = append(seen, m.MessageID)
+	}
+	if strings.Join(seen, ",") != strings.Join(ids[3:], ",") || token != "" {
+		t.Errorf("Expected messages %v, got %v", ids[3:], seen)
+	}
+}
+
+func TestDataPreview(t *testing.T) {
+	long := strings.Repeat("é", peekPreviewBytes) // two bytes per rune
+
+	preview := dataPreview(EncodeData([]byte(long)))
+	if preview != strings.Repeat("é", peekPreviewBytes/2)+"…" {
+		t.Errorf("Expected truncated preview, got %q", preview)
+	}
+	if preview := dataPreview(EncodeData([]byte{0xff, 'a'})); preview != "�a" {
+		t.Errorf("Expected replacement character for binary data, got %q", preview)
+	}
+}
+
+func TestHandlePeekMessages(t *testing.T) {
+	server := NewServer()
+
+	// Setup
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	server.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	server.storage.Publish("projects/test/topics/topic1", []PubSubMessage{
+		{Data: "dGVzdDE=", Attributes: map[string]string{"env": "prod"}},
+		{Data: "dGVzdDI=", Attributes: map[string]string{"env": "dev"}},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/v1/projects/test/subscriptions/sub1/messages?attribute=env=dev&state=available", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp PeekMessagesResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Messages) != 1 || resp.Messages[0].DataPreview != "test2" {
+		t.Errorf("Expected the dev message, got %+v", resp.Messages)
+	}
+
+	for _, path := range []string{
+		"/admin/v1/projects/test/subscriptions/sub1/messages?state=unknown",
+		"/admin/v1/projects/test/subscriptions/sub1/messages?attribute=env",
+		"/admin/v1/projects/test/subscriptions/sub1/messages?pageToken=x",
+	} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, path, w.Code)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/v1/projects/test/subscriptions/missing/messages", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
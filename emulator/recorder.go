@@ -0,0 +1,273 @@
+package emulator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecordedCall is one Pub/Sub API call in a recording. Bodies are kept as
+// JSON; a body that is not valid JSON is stored as a JSON string.
+type RecordedCall struct {
+	Time         time.Time       `json:"time"`
+	Method       string          `json:"method"` // API method, e.g. Publish
+	HTTPMethod   string          `json:"httpMethod"`
+	Path         string          `json:"path"`                // including the query
+	Namespace    string          `json:"namespace,omitempty"` // sent as NamespaceHeader on replay
+	Resource     string          `json:"resource"`
+	RequestBody  json.RawMessage `json:"requestBody,omitempty"`
+	Status       int             `json:"status"` // 0 if the response was dropped
+	ResponseBody json.RawMessage `json:"responseBody,omitempty"`
+	DurationMs   float64         `json:"durationMs"`
+}
+
+// Recorder appends every Pub/Sub API call to an NDJSON file, one
+// RecordedCall per line, in the order the calls complete
+type Recorder struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewRecorder creates a recorder appending to the file at path
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	return &Recorder{file: file}, nil
+}
+
+// Close closes the recording
+func (rec *Recorder) Close() error {
+	return rec.file.Close()
+}
+
+// capture starts recording a call. It returns the writer the call must be
+// handled with and a function that writes the call once it is done.
+func (rec *Recorder) capture(w http.ResponseWriter, r *http.Request, method string) (http.ResponseWriter, func()) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Error("failed to record request", "operation", "record", "path", r.URL.Path, "error", err.Error())
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	call := RecordedCall{
+		Time:        time.Now(),
+		Method:      method,
+		HTTPMethod:  r.Method,
+		Path:        r.URL.RequestURI(),
+		Namespace:   r.Header.Get(NamespaceHeader),
+		Resource:    apiResource(r.URL.Path),
+		RequestBody: rawBody(body),
+	}
+	capture := &responseCapture{ResponseWriter: w}
+	return capture, func() {
+		call.Status = capture.status
+		call.ResponseBody = rawBody(capture.body.Bytes())
+		call.DurationMs = float64(time.Since(call.Time)) / float64(time.Millisecond)
+		if err := rec.write(call); err != nil {
+			logger.Error("failed to record call", "operation", "record", "path", r.URL.Path, "error", err.Error())
+		}
+	}
+}
+
+func (rec *Recorder) write(call RecordedCall) error {
+	line, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	_, err = rec.file.Write(append(line, '\n'))
+	return err
+}
+
+// responseCapture passes a response through and keeps a copy of it
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// rawBody stores a body in a recording
+func rawBody(body []byte) json.RawMessage {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
+
+// bodyBytes is the inverse of rawBody. Pub/Sub bodies are JSON objects, so a
+// string can only be a body that was not JSON.
+func bodyBytes(raw json.RawMessage) []byte {
+	var text string
+	if len(raw) > 0 && raw[0] == '"' && json.Unmarshal(raw, &text) == nil {
+		return []byte(text)
+	}
+	return raw
+}
+
+// ReadRecording reads the calls of a recording
+func ReadRecording(path string) ([]RecordedCall, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var calls []RecordedCall
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var call RecordedCall
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		calls = append(calls, call)
+	}
+	return calls, scanner.Err()
+}
+
+// ReplayOptions controls a replay
+type ReplayOptions struct {
+	// Speed scales the pauses between calls: 1 replays in real time, 10 ten
+	// times as fast, and 0 issues the calls back to back
+	Speed float64
+	// IgnoreFields names JSON fields left out when comparing responses, at
+	// any depth, such as publishTime
+	IgnoreFields []string
+	Client       *http.Client
+}
+
+// Divergence is a replayed call whose response differs from the recording
+type Divergence struct {
+	Index  int // of the call in the recording
+	Call   RecordedCall
+	Status int
+	Body   json.RawMessage
+	Reason string
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("#%d %s %s: %s", d.Index+1, d.Call.Method, d.Call.Resource, d.Reason)
+}
+
+// Replay re-issues recorded calls against the emulator at target, one after
+// the other and paced like the recording, and returns the calls whose
+// responses differ from the recorded ones
+func Replay(ctx context.Context, calls []RecordedCall, target string, opts ReplayOptions) ([]Divergence, error) {
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	target = strings.TrimSuffix(target, "/")
+
+	var divergences []Divergence
+	start := time.Now()
+	for i, call := range calls {
+		if opts.Speed > 0 {
+			due := start.Add(time.Duration(float64(call.Time.Sub(calls[0].Time)) / opts.Speed))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return divergences, ctx.Err()
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, call.HTTPMethod, target+call.Path, bytes.NewReader(bodyBytes(call.RequestBody)))
+		if err != nil {
+			return divergences, err
+		}
+		if len(call.RequestBody) > 0 {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if call.Namespace != "" {
+			req.Header.Set(NamespaceHeader, call.Namespace)
+		}
+
+		status, body := 0, []byte(nil)
+		resp, err := client.Do(req)
+		if err == nil {
+			status = resp.StatusCode
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if err != nil && ctx.Err() != nil {
+			return divergences, ctx.Err()
+		}
+
+		if reason := compareResponse(call, status, rawBody(body), opts.IgnoreFields); reason != "" {
+			divergences = append(divergences, Divergence{Index: i, Call: call, Status: status, Body: rawBody(body), Reason: reason})
+		}
+	}
+	return divergences, nil
+}
+
+// compareResponse describes how a replayed response differs from the
+// recorded one, or returns "" if it does not
+func compareResponse(call RecordedCall, status int, body json.RawMessage, ignore []string) string {
+	if status != call.Status {
+		return fmt.Sprintf("status %d, recorded %d", status, call.Status)
+	}
+	var got, want any
+	if json.Unmarshal(body, &got) != nil || json.Unmarshal(call.ResponseBody, &want) != nil {
+		if !bytes.Equal(body, call.ResponseBody) {
+			return fmt.Sprintf("response %s, recorded %s", body, call.ResponseBody)
+		}
+		return ""
+	}
+	if !reflect.DeepEqual(withoutFields(got, ignore), withoutFields(want, ignore)) {
+		return fmt.Sprintf("response %s, recorded %s", body, call.ResponseBody)
+	}
+	return ""
+}
+
+// withoutFields removes the named fields from a decoded JSON value
+func withoutFields(v any, fields []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for _, field := range fields {
+			delete(v, field)
+		}
+		for key, value := range v {
+			v[key] = withoutFields(value, fields)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = withoutFields(value, fields)
+		}
+	}
+	return v
+}
@@ -0,0 +1,161 @@
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordSession runs a publish, pull and ack against a recording server with
+// sequential IDs and returns the recording
+func recordSession(t *testing.T) []RecordedCall {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	server := NewServer()
+	server.storage.SetIDGenerator(NewSequentialIDs(1))
+	server.EnableRecording(recorder)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPut, "/v1/projects/test/topics/topic1", nil),
+		httptest.NewRequest(http.MethodPut, "/v1/projects/test/subscriptions/sub1", bytes.NewBufferString(`{"topic": "projects/test/topics/topic1"}`)),
+		httptest.NewRequest(http.MethodPost, "/v1/projects/test/topics/topic1:publish", bytes.NewBufferString(`{"messages": [{"data": "dGVzdA=="}]}`)),
+		httptest.NewRequest(http.MethodPost, "/v1/projects/test/subscriptions/sub1:pull", bytes.NewBufferString(`{"maxMessages": 10}`)),
+		httptest.NewRequest(http.MethodPost, "/v1/projects/test/subscriptions/sub1:acknowledge", bytes.NewBufferString(`{"ackIds": ["`+NewSequentialIDs(1).AckID("projects/test/subscriptions/sub1", "1")+`"]}`)),
+		httptest.NewRequest(http.MethodGet, "/v1/projects/test/topics/missing", nil),
+		httptest.NewRequest(http.MethodGet, "/admin/v1/clock", nil),
+	} {
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	calls, err := ReadRecording(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return calls
+}
+
+func TestRecorder(t *testing.T) {
+	calls := recordSession(t)
+
+	// The admin call is not part of the Pub/Sub API
+	if len(calls) != 6 {
+		t.Fatalf("Expected 6 calls, got %d", len(calls))
+	}
+	publish := calls[2]
+	if publish.Method != "Publish" || publish.HTTPMethod != http.MethodPost || publish.Resource != "projects/test/topics/topic1" {
+		t.Errorf("Expected the publish call, got %+v", publish)
+	}
+	if string(publish.RequestBody) != `{"messages":[{"data":"dGVzdA=="}]}` {
+		t.Errorf("Expected the request body, got %s", publish.RequestBody)
+	}
+	if publish.Status != http.StatusOK || string(publish.ResponseBody) != `{"messageIds":["1"]}` {
+		t.Errorf("Expected the response, got %d %s", publish.Status, publish.ResponseBody)
+	}
+	if calls[5].Status != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, calls[5].Status)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	calls := recordSession(t)
+
+	server := NewServer()
+	server.storage.SetIDGenerator(NewSequentialIDs(1))
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	divergences, err := Replay(context.Background(), calls, httpServer.URL, ReplayOptions{IgnoreFields: []string{"publishTime"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Errorf("Expected no divergence, got %v", divergences)
+	}
+
+	// Replaying again finds the resources already there and the message acked
+	divergences, _ = Replay(context.Background(), calls, httpServer.URL, ReplayOptions{IgnoreFields: []string{"publishTime"}})
+	if len(divergences) != 4 {
+		t.Fatalf("Expected 4 divergences, got %v", divergences)
+	}
+	if divergences[0].Index != 0 || divergences[0].Status != http.StatusConflict {
+		t.Errorf("Expected the topic creation to conflict, got %v", divergences[0])
+	}
+}
+
+func TestReplay_Namespace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	server := NewServer()
+	server.EnableRecording(recorder)
+	serveNamespaced(server, http.MethodPut, "/ns/a/v1/projects/test/topics/topic1", "", "")
+	serveNamespaced(server, http.MethodPut, "/v1/projects/test/topics/topic2", "b", "")
+	recorder.Close()
+
+	calls, err := ReadRecording(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(calls) != 2 || calls[0].Namespace != "a" || calls[1].Namespace != "b" {
+		t.Fatalf("Expected the namespaces to be recorded, got %+v", calls)
+	}
+
+	replayed := NewServer()
+	httpServer := httptest.NewServer(replayed)
+	defer httpServer.Close()
+	if _, err := Replay(context.Background(), calls, httpServer.URL, ReplayOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(replayed.storage.ListTopics()) != 0 {
+		t.Error("Expected nothing to be replayed into the shared view")
+	}
+	for name, topic := range map[string]string{"a": "topic1", "b": "topic2"} {
+		ns, _ := replayed.namespace(name, false)
+		if ns == nil {
+			t.Fatalf("Expected namespace %s to be created", name)
+		}
+		if _, err := ns.storage.GetTopic("projects/test/topics/" + topic); err != nil {
+			t.Errorf("Expected %s in namespace %s, got %v", topic, name, err)
+		}
+	}
+}
+
+func TestReplay_Pacing(t *testing.T) {
+	start := time.Now()
+	calls := []RecordedCall{
+		{Time: start, HTTPMethod: http.MethodGet, Path: "/v1/projects/test/topics", Status: http.StatusOK, ResponseBody: []byte(`{"topics":[]}`)},
+		{Time: start.Add(200 * time.Millisecond), HTTPMethod: http.MethodGet, Path: "/v1/projects/test/topics", Status: http.StatusOK, ResponseBody: []byte(`{"topics":[]}`)},
+	}
+	httpServer := httptest.NewServer(NewServer())
+	defer httpServer.Close()
+
+	for _, tc := range []struct {
+		speed    float64
+		min, max time.Duration
+	}{
+		{speed: 1, min: 200 * time.Millisecond, max: time.Second},
+		{speed: 4, min: 50 * time.Millisecond, max: 190 * time.Millisecond},
+	} {
+		began := time.Now()
+		divergences, err := Replay(context.Background(), calls, httpServer.URL, ReplayOptions{Speed: tc.speed})
+		if err != nil || len(divergences) != 0 {
+			t.Fatalf("Expected no error or divergence, got %v %v", err, divergences)
+		}
+		if elapsed := time.Since(began); elapsed < tc.min || elapsed > tc.max {
+			t.Errorf("Expected speed %v to take %v to %v, got %v", tc.speed, tc.min, tc.max, elapsed)
+		}
+	}
+}
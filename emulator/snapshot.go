@@ -0,0 +1,254 @@
+package emulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+	"time"
+)
+
+// StateFormatVersion is the version of the StateSnapshot document format.
+// It is bumped on incompatible changes so that old exports are rejected
+// rather than misread.
+const StateFormatVersion = 1
+
+var ErrInvalidState = errors.New("invalid state document")
+
+// StateSnapshot is a point-in-time copy of everything held by Storage,
+// including unacked messages and their lease deadlines. It is used for the
+// journal snapshot file, checkpoints and state export.
+type StateSnapshot struct {
+	Version       int                    `json:"version"`
+	Sequence      uint64                 `json:"sequence,omitempty"` // last journal record included
+	Topics        []Topic                `json:"topics"`
+	Subscriptions []SubscriptionSnapshot `json:"subscriptions"`
+}
+
+// SubscriptionSnapshot is a subscription together with its backlog
+type SubscriptionSnapshot struct {
+	Name     string            `json:"name"`
+	Topic    string            `json:"topic"`
+	Messages []MessageSnapshot `json:"messages"`
+}
+
+// MessageSnapshot is a single delivery record of a subscription
+type MessageSnapshot struct {
+	Message          Message   `json:"message"`
+	AckID            string    `json:"ackId"`
+	DeadlineAt       time.Time `json:"deadlineAt,omitzero"`
+	DeliveryAttempts int       `json:"deliveryAttempts,omitempty"`
+}
+
+// snapshot copies the current state. The caller must hold s.mu exclusively.
+func (s *Storage) snapshot() *StateSnapshot {
+	snap := &StateSnapshot{
+		Version:       StateFormatVersion,
+		Topics:        make([]Topic, 0, len(s.topics)),
+		Subscriptions: make([]SubscriptionSnapshot, 0, len(s.subscriptions)),
+	}
+
+	for _, state := range s.topics {
+		snap.Topics = append(snap.Topics, *state.topic)
+	}
+	sort.Slice(snap.Topics, func(i, j int) bool {
+		return snap.Topics[i].Name < snap.Topics[j].Name
+	})
+
+	for _, state := range s.subscriptions {
+		sub := SubscriptionSnapshot{
+			Name:     state.subscription.Name,
+			Topic:    state.subscription.Topic,
+			Messages: make([]MessageSnapshot, 0, len(state.messages)),
+		}
+		for _, msg := range state.messages {
+			if msg.AckedAt != nil {
+				continue
+			}
+			sub.Messages = append(sub.Messages, MessageSnapshot{
+				Message:          msg.message(),
+				AckID:            msg.AckID,
+				DeadlineAt:       msg.DeadlineAt,
+				DeliveryAttempts: msg.DeliveryAttempts,
+			})
+		}
+		snap.Subscriptions = append(snap.Subscriptions, sub)
+	}
+	sort.Slice(snap.Subscriptions, func(i, j int) bool {
+		return snap.Subscriptions[i].Name < snap.Subscriptions[j].Name
+	})
+
+	return snap
+}
+
+// restore replaces the current state with a snapshot. Message bodies shared
+// by several subscriptions are stored once again. The caller must hold s.mu
+// exclusively.
+func (s *Storage) restore(snap *StateSnapshot) {
+	s.topics = make(map[string]*topicState, len(snap.Topics))
+	s.subscriptions = make(map[string]*subscriptionState, len(snap.Subscriptions))
+	s.topicSubs = make(map[string]map[string]*subscriptionState)
+
+	for _, topic := range snap.Topics {
+		s.applyCreateTopic(topic.Name)
+	}
+
+	for _, sub := range snap.Subscriptions {
+		s.applyCreateSubscription(sub.Name, sub.Topic)
+		state := s.subscriptions[sub.Name]
+
+		// Subscriptions may outlive their topic; keep their bodies in a
+		// detached topic state so that release still works
+		topic, exists := s.topics[sub.Topic]
+		if !exists {
+			topic = &topicState{
+				topic:    &Topic{Name: sub.Topic},
+				messages: make(map[string]*storedMessage),
+			}
+		}
+
+		for _, m := range sub.Messages {
+			body, exists := topic.messages[m.Message.MessageID]
+			if !exists {
+				msg := m.Message
+				msg.Attributes = maps.Clone(msg.Attributes)
+				body = &storedMessage{message: msg, topic: topic}
+				topic.messages[msg.MessageID] = body
+			}
+			body.refs.Add(1)
+			state.appendMessages(&InternalMessage{
+				body:             body,
+				AckID:            m.AckID,
+				DeadlineAt:       m.DeadlineAt,
+				DeliveryAttempts: m.DeliveryAttempts,
+			})
+		}
+	}
+}
+
+// StateExporter is implemented by backends that can export their complete
+// state as a StateSnapshot document and import one
+type StateExporter interface {
+	ExportState() *StateSnapshot
+	ImportState(snap *StateSnapshot, replace bool) error
+}
+
+var _ StateExporter = (*Storage)(nil)
+
+// Validate checks that a document can be imported
+func (snap *StateSnapshot) Validate() error {
+	if snap.Version != StateFormatVersion {
+		return fmt.Errorf("%w: unsupported version %d (expected %d)", ErrInvalidState, snap.Version, StateFormatVersion)
+	}
+	for _, topic := range snap.Topics {
+		if topic.Name == "" {
+			return fmt.Errorf("%w: topic without name", ErrInvalidState)
+		}
+	}
+	for _, sub := range snap.Subscriptions {
+		if sub.Name == "" || sub.Topic == "" {
+			return fmt.Errorf("%w: subscription needs name and topic", ErrInvalidState)
+		}
+		ackIDs := make(map[string]bool, len(sub.Messages))
+		for _, m := range sub.Messages {
+			if m.AckID == "" || m.Message.MessageID == "" {
+				return fmt.Errorf("%w: message without ack ID or message ID in %s", ErrInvalidState, sub.Name)
+			}
+			if ackIDs[m.AckID] {
+				return fmt.Errorf("%w: duplicate ack ID %s in %s", ErrInvalidState, m.AckID, sub.Name)
+			}
+			ackIDs[m.AckID] = true
+		}
+	}
+	return nil
+}
+
+// ExportState returns a copy of all topics, subscriptions and retained
+// messages with their lease and delivery metadata
+func (s *Storage) ExportState() *StateSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot()
+}
+
+// ImportState loads a document. With replace, the current state is discarded;
+// otherwise missing topics and subscriptions are added and messages are
+// appended to existing subscriptions unless their ack ID is already present.
+func (s *Storage) ImportState(snap *StateSnapshot, replace bool) error {
+	if err := snap.Validate(); err != nil {
+		return err
+	}
+
+	return s.replaceState(func() *StateSnapshot {
+		if replace {
+			return snap
+		}
+		return mergeSnapshots(s.snapshot(), snap)
+	})
+}
+
+// mergeSnapshots adds the contents of incoming to current
+func mergeSnapshots(current, incoming *StateSnapshot) *StateSnapshot {
+	topics := make(map[string]bool, len(current.Topics))
+	for _, topic := range current.Topics {
+		topics[topic.Name] = true
+	}
+	for _, topic := range incoming.Topics {
+		if !topics[topic.Name] {
+			current.Topics = append(current.Topics, topic)
+			topics[topic.Name] = true
+		}
+	}
+
+	subs := make(map[string]int, len(current.Subscriptions))
+	for i, sub := range current.Subscriptions {
+		subs[sub.Name] = i
+	}
+	for _, sub := range incoming.Subscriptions {
+		i, exists := subs[sub.Name]
+		if !exists {
+			current.Subscriptions = append(current.Subscriptions, sub)
+			continue
+		}
+
+		existing := &current.Subscriptions[i]
+		ackIDs := make(map[string]bool, len(existing.Messages))
+		for _, m := range existing.Messages {
+			ackIDs[m.AckID] = true
+		}
+		for _, m := range sub.Messages {
+			if !ackIDs[m.AckID] {
+				existing.Messages = append(existing.Messages, m)
+			}
+		}
+	}
+	return current
+}
+
+// ReadStateFile reads an exported state document
+func ReadStateFile(path string) (*StateSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	var snap StateSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to decode state file: %w", err)
+	}
+	return &snap, nil
+}
+
+// WriteStateFile writes a state document in its stable, indented form, so
+// that exports diff cleanly when checked in as fixtures
+func WriteStateFile(path string, snap *StateSnapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorage_ExportImportRoundTrip(t *testing.T) {
+	storage := NewStorage()
+
+	// Setup
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{
+		{Data: "dGVzdDE=", Attributes: map[string]string{"key": "value"}},
+		{Data: "dGVzdDI="},
+	})
+	leased, _ := storage.Pull("projects/test/subscriptions/sub1", 1)
+	storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{leased[0].AckID}, 60)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := WriteStateFile(path, storage.ExportState()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	doc, err := ReadStateFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if doc.Version != StateFormatVersion {
+		t.Errorf("Expected version %d, got %d", StateFormatVersion, doc.Version)
+	}
+
+	fresh := NewStorage()
+	if err := fresh.ImportState(doc, true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The leased message keeps its lease and delivery count
+	pulled, _ := fresh.Pull("projects/test/subscriptions/sub1", 10)
+	if len(pulled) != 1 {
+		t.Fatalf("Expected 1 unleased message, got %d", len(pulled))
+	}
+	if pulled[0].Message.Data != "dGVzdDI=" {
+		t.Errorf("Expected data 'dGVzdDI=', got %s", pulled[0].Message.Data)
+	}
+	exported := fresh.ExportState()
+	if attempts := exported.Subscriptions[0].Messages[0].DeliveryAttempts; attempts != 1 {
+		t.Errorf("Expected 1 delivery attempt, got %d", attempts)
+	}
+	if key := exported.Subscriptions[0].Messages[0].Message.Attributes["key"]; key != "value" {
+		t.Errorf("Expected attribute key='value', got %s", key)
+	}
+}
+
+func TestStorage_ImportMerge(t *testing.T) {
+	source := NewStorage()
+	source.CreateTopic("projects/test/topics/topic1")
+	source.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	source.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdDE="}})
+	doc := source.ExportState()
+
+	target := NewStorage()
+	target.CreateTopic("projects/test/topics/topic1")
+	target.CreateTopic("projects/test/topics/local")
+	target.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	target.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "bG9jYWw="}})
+
+	// Merging twice only adds the imported messages once
+	for i := 0; i < 2; i++ {
+		if err := target.ImportState(doc, false); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if _, err := target.GetTopic("projects/test/topics/local"); err != nil {
+		t.Errorf("Expected local topic to be kept, got %v", err)
+	}
+	pulled, _ := target.Pull("projects/test/subscriptions/sub1", 10)
+	if len(pulled) != 2 {
+		t.Errorf("Expected local and imported message, got %d", len(pulled))
+	}
+}
+
+func TestStorage_ImportInvalidVersion(t *testing.T) {
+	storage := NewStorage()
+
+	err := storage.ImportState(&StateSnapshot{Version: StateFormatVersion + 1}, true)
+	if err == nil {
+		t.Error("Expected error for unsupported version, got nil")
+	}
+}
+
+func TestHandleExportImportState(t *testing.T) {
+	source := NewServer()
+	source.storage.CreateTopic("projects/test/topics/topic1")
+	source.storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	source.storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/v1/state", nil)
+	w := httptest.NewRecorder()
+	source.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var doc StateSnapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(doc.Subscriptions) != 1 || len(doc.Subscriptions[0].Messages) != 1 {
+		t.Fatalf("Expected 1 subscription with 1 message, got %+v", doc.Subscriptions)
+	}
+
+	target := NewServer()
+	target.storage.CreateTopic("projects/test/topics/other")
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/v1/state:import?mode=replace", bytes.NewReader(w.Body.Bytes()))
+	w = httptest.NewRecorder()
+	target.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if topics := target.storage.ListTopics(); len(topics) != 1 {
+		t.Errorf("Expected 1 topic after replace, got %d", len(topics))
+	}
+	pulled, _ := target.storage.Pull("projects/test/subscriptions/sub1", 10)
+	if len(pulled) != 1 {
+		t.Errorf("Expected 1 imported message, got %d", len(pulled))
+	}
+}
+
+func TestHandleImportState_Invalid(t *testing.T) {
+	server := NewServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/v1/state:import", bytes.NewBufferString(`{"version": 99}`))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/v1/state:import?mode=overwrite", bytes.NewBufferString(`{"version": 1}`))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
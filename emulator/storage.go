@@ -0,0 +1,777 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrTopicNotFound             = errors.New("topic not found")
+	ErrTopicAlreadyExists        = errors.New("topic already exists")
+	ErrSubscriptionNotFound      = errors.New("subscription not found")
+	ErrSubscriptionAlreadyExists = errors.New("subscription already exists")
+)
+
+// Storage is an in-memory storage for Pub/Sub entities.
+//
+// Locking is two-level: mu guards the registry maps and is only held
+// exclusively while topics or subscriptions are created or deleted. Message
+// operations hold mu shared and take the lock of each subscription they touch,
+// so traffic on one subscription never waits for another.
+//
+// Every mutation is described by a walRecord which is written to the journal
+// (when persistence is enabled) before it is applied, so that replaying the
+// journal goes through exactly the same code.
+type Storage struct {
+	topics        map[string]*topicState
+	subscriptions map[string]*subscriptionState
+	topicSubs     map[string]map[string]*subscriptionState // key: topic name
+	mu            sync.RWMutex
+
+	journal        *journal // nil unless running with a data directory
+	stopCompaction chan struct{}
+	compactionDone chan struct{}
+
+	checkpoints   map[string]*StateSnapshot
+	checkpointsMu sync.Mutex
+
+	events eventBus
+	clock  Clock
+	ids    IDGenerator
+	limits Limits
+}
+
+// topicState holds a topic and the bodies of its retained messages
+type topicState struct {
+	topic    *Topic
+	messages map[string]*storedMessage // key: message ID
+	mu       sync.Mutex
+
+	// publishMu serializes publishes to the topic, so that they are
+	// journaled in the same order as they are appended to the subscriptions
+	publishMu sync.Mutex
+}
+
+// storedMessage is a published message body. It is stored once per topic and
+// shared by the delivery records of every subscription it was fanned out to.
+// The body is never mutated after publish.
+type storedMessage struct {
+	message Message
+	refs    atomic.Int64
+	topic   *topicState
+}
+
+// release drops one subscription's reference and forgets the body once the
+// last subscription is done with it
+func (m *storedMessage) release() {
+	if m.refs.Add(-1) != 0 {
+		return
+	}
+	m.topic.mu.Lock()
+	delete(m.topic.messages, m.message.MessageID)
+	m.topic.mu.Unlock()
+}
+
+// subscriptionState holds a subscription and its backlog
+type subscriptionState struct {
+	subscription *Subscription
+	messages     []*InternalMessage
+	chaos        *chaosState // nil unless a chaos policy is set
+	lastSeq      uint64      // seq of the last record appended to messages
+	mu           sync.Mutex
+}
+
+// appendMessages adds delivery records to the backlog. The caller must hold
+// the subscription lock or have exclusive access.
+func (state *subscriptionState) appendMessages(msgs ...*InternalMessage) {
+	for _, msg := range msgs {
+		state.lastSeq++
+		msg.seq = state.lastSeq
+	}
+	state.messages = append(state.messages, msgs...)
+}
+
+// NewStorage creates a new Storage instance
+func NewStorage() *Storage {
+	return &Storage{
+		topics:        make(map[string]*topicState),
+		subscriptions: make(map[string]*subscriptionState),
+		topicSubs:     make(map[string]map[string]*subscriptionState),
+		checkpoints:   make(map[string]*StateSnapshot),
+		clock:         systemClock{},
+		ids:           randomIDs{},
+	}
+}
+
+// record writes a mutation to the journal if persistence is enabled
+func (s *Storage) record(rec *walRecord) error {
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.append(rec)
+}
+
+// Observe registers an observer for message events
+func (s *Storage) Observe(fn Observer) {
+	s.events.add(fn)
+}
+
+// Clock returns the clock the storage reads time from
+func (s *Storage) Clock() Clock {
+	return s.clock
+}
+
+// SetClock replaces the clock. It must be called before the storage is used.
+func (s *Storage) SetClock(clock Clock) {
+	s.clock = clock
+}
+
+// SetIDGenerator replaces the generator of message and ack IDs. It must be
+// called before the storage is used.
+func (s *Storage) SetIDGenerator(ids IDGenerator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if seq, ok := ids.(*SequentialIDs); ok {
+		for _, topic := range s.topics {
+			topic.mu.Lock()
+			for id := range topic.messages {
+				seq.skipPast(id)
+			}
+			topic.mu.Unlock()
+		}
+	}
+	s.ids = ids
+}
+
+// CreateTopic creates a new topic
+func (s *Storage) CreateTopic(name string) (*Topic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.topics[name]; exists {
+		return nil, ErrTopicAlreadyExists
+	}
+	if err := s.checkTopicLimit(); err != nil {
+		return nil, err
+	}
+
+	if err := s.record(&walRecord{Op: walOpCreateTopic, Name: name}); err != nil {
+		return nil, err
+	}
+	return s.applyCreateTopic(name), nil
+}
+
+func (s *Storage) applyCreateTopic(name string) *Topic {
+	topic := &Topic{Name: name}
+	s.topics[name] = &topicState{
+		topic:    topic,
+		messages: make(map[string]*storedMessage),
+	}
+	return topic
+}
+
+// GetTopic retrieves a topic by name
+func (s *Storage) GetTopic(name string) (*Topic, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, exists := s.topics[name]
+	if !exists {
+		return nil, ErrTopicNotFound
+	}
+	return state.topic, nil
+}
+
+// DeleteTopic deletes a topic
+func (s *Storage) DeleteTopic(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.topics[name]; !exists {
+		return ErrTopicNotFound
+	}
+
+	if err := s.record(&walRecord{Op: walOpDeleteTopic, Name: name}); err != nil {
+		return err
+	}
+	s.applyDeleteTopic(name)
+	s.events.emit(Event{Kind: EventDelete, Time: s.clock.Now(), Topic: name})
+	return nil
+}
+
+func (s *Storage) applyDeleteTopic(name string) {
+	delete(s.topics, name)
+}
+
+// ListTopics returns all topics
+func (s *Storage) ListTopics() []*Topic {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	topics := make([]*Topic, 0, len(s.topics))
+	for _, state := range s.topics {
+		topics = append(topics, state.topic)
+	}
+	return topics
+}
+
+// CreateSubscription creates a new subscription
+func (s *Storage) CreateSubscription(name, topicName string) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.subscriptions[name]; exists {
+		return nil, ErrSubscriptionAlreadyExists
+	}
+
+	if _, exists := s.topics[topicName]; !exists {
+		return nil, ErrTopicNotFound
+	}
+	if err := s.checkSubscriptionLimit(); err != nil {
+		return nil, err
+	}
+
+	if err := s.record(&walRecord{Op: walOpCreateSubscription, Name: name, Topic: topicName}); err != nil {
+		return nil, err
+	}
+	return s.applyCreateSubscription(name, topicName), nil
+}
+
+func (s *Storage) applyCreateSubscription(name, topicName string) *Subscription {
+	subscription := &Subscription{
+		Name:  name,
+		Topic: topicName,
+	}
+	state := &subscriptionState{
+		subscription: subscription,
+		messages:     make([]*InternalMessage, 0),
+	}
+	s.subscriptions[name] = state
+	if s.topicSubs[topicName] == nil {
+		s.topicSubs[topicName] = make(map[string]*subscriptionState)
+	}
+	s.topicSubs[topicName][name] = state
+	return subscription
+}
+
+// GetSubscription retrieves a subscription by name
+func (s *Storage) GetSubscription(name string) (*Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, exists := s.subscriptions[name]
+	if !exists {
+		return nil, ErrSubscriptionNotFound
+	}
+	return state.subscription, nil
+}
+
+// DeleteSubscription deletes a subscription
+func (s *Storage) DeleteSubscription(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.subscriptions[name]; !exists {
+		return ErrSubscriptionNotFound
+	}
+
+	if err := s.record(&walRecord{Op: walOpDeleteSubscription, Name: name}); err != nil {
+		return err
+	}
+	s.applyDeleteSubscription(name)
+	s.events.emit(Event{Kind: EventDelete, Time: s.clock.Now(), Subscription: name})
+	return nil
+}
+
+func (s *Storage) applyDeleteSubscription(name string) {
+	state := s.subscriptions[name]
+
+	// Nothing else can hold the subscription lock while the registry is locked
+	for _, msg := range state.messages {
+		msg.body.release()
+	}
+
+	delete(s.subscriptions, name)
+	if subs := s.topicSubs[state.subscription.Topic]; subs != nil {
+		delete(subs, name)
+		if len(subs) == 0 {
+			delete(s.topicSubs, state.subscription.Topic)
+		}
+	}
+}
+
+// ListSubscriptions returns all subscriptions
+func (s *Storage) ListSubscriptions() []*Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscriptions := make([]*Subscription, 0, len(s.subscriptions))
+	for _, state := range s.subscriptions {
+		subscriptions = append(subscriptions, state.subscription)
+	}
+	return subscriptions
+}
+
+// Publish publishes messages to a topic
+func (s *Storage) Publish(topicName string, messages []PubSubMessage) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	topic, exists := s.topics[topicName]
+	if !exists {
+		return nil, ErrTopicNotFound
+	}
+	topic.publishMu.Lock()
+	defer topic.publishMu.Unlock()
+
+	if err := s.checkBacklogLimit(topicName, len(messages)); err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	rec := &walRecord{
+		Op:          walOpPublish,
+		Topic:       topicName,
+		PublishTime: now.Format(time.RFC3339),
+		Messages:    make([]walMessage, len(messages)),
+		Deliveries:  make(map[string][]string),
+	}
+
+	// Generate message IDs first
+	messageIDs := make([]string, len(messages))
+	for i, pubsubMsg := range messages {
+		messageIDs[i] = s.ids.MessageID()
+		rec.Messages[i] = walMessage{
+			MessageID:  messageIDs[i],
+			Data:       pubsubMsg.Data,
+			Attributes: pubsubMsg.Attributes,
+		}
+	}
+
+	// One ack ID per message for every subscription of this topic
+	for subName := range s.topicSubs[topicName] {
+		ackIDs := make([]string, len(messages))
+		for i := range ackIDs {
+			ackIDs[i] = s.ids.AckID(subName, messageIDs[i])
+		}
+		rec.Deliveries[subName] = ackIDs
+	}
+
+	// Journal before the messages become visible so that any later lease or
+	// ack on them is always recorded after the publish
+	if err := s.record(rec); err != nil {
+		return nil, err
+	}
+	s.applyPublish(rec)
+
+	if s.events.active() {
+		fanout := slices.Sorted(maps.Keys(rec.Deliveries))
+		for _, m := range rec.Messages {
+			s.events.emit(Event{
+				Kind:      EventPublish,
+				Time:      now,
+				Topic:     topicName,
+				MessageID: m.MessageID,
+				Fanout:    fanout,
+				Message: &Message{
+					Data:        m.Data,
+					Attributes:  maps.Clone(m.Attributes),
+					MessageID:   m.MessageID,
+					PublishTime: rec.PublishTime,
+				},
+			})
+		}
+	}
+
+	return messageIDs, nil
+}
+
+// applyPublish stores the message bodies and fans them out. The caller must
+// hold s.mu for reading.
+func (s *Storage) applyPublish(rec *walRecord) {
+	topic, exists := s.topics[rec.Topic]
+	if !exists || len(rec.Deliveries) == 0 {
+		return
+	}
+
+	// Store each body once; subscriptions only get lightweight delivery records
+	bodies := make([]*storedMessage, len(rec.Messages))
+	for i, m := range rec.Messages {
+		bodies[i] = &storedMessage{
+			message: Message{
+				Data:        m.Data,
+				Attributes:  maps.Clone(m.Attributes),
+				MessageID:   m.MessageID,
+				PublishTime: rec.PublishTime,
+			},
+			topic: topic,
+		}
+	}
+
+	// Fan out to every subscription of this topic, locking one at a time
+	for subName, ackIDs := range rec.Deliveries {
+		state, exists := s.subscriptions[subName]
+		if !exists {
+			continue
+		}
+
+		internalMsgs := make([]*InternalMessage, len(bodies))
+		for i, body := range bodies {
+			body.refs.Add(1)
+			// Messages are immediately visible (deadline in the past)
+			// The deadline will be set when the message is first pulled
+			internalMsgs[i] = &InternalMessage{
+				body:       body,
+				AckID:      ackIDs[i],
+				DeadlineAt: time.Time{}, // Zero time, always in the past
+			}
+		}
+
+		state.mu.Lock()
+		state.appendMessages(internalMsgs...)
+		state.mu.Unlock()
+	}
+
+	topic.mu.Lock()
+	for _, body := range bodies {
+		if body.refs.Load() > 0 {
+			topic.messages[body.message.MessageID] = body
+		}
+	}
+	topic.mu.Unlock()
+}
+
+// Reset deletes every topic and subscription of a project, or everything if
+// project is empty
+func (s *Storage) Reset(project string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.record(&walRecord{Op: walOpReset, Name: project}); err != nil {
+		return err
+	}
+	s.applyReset(project)
+	s.events.emit(Event{Kind: EventReset, Time: s.clock.Now(), Project: project})
+	return nil
+}
+
+func (s *Storage) applyReset(project string) {
+	if project == "" {
+		s.topics = make(map[string]*topicState)
+		s.subscriptions = make(map[string]*subscriptionState)
+		s.topicSubs = make(map[string]map[string]*subscriptionState)
+		return
+	}
+
+	for name := range s.subscriptions {
+		if inProject(name, project) {
+			s.applyDeleteSubscription(name)
+		}
+	}
+	for name := range s.topics {
+		if inProject(name, project) {
+			s.applyDeleteTopic(name)
+		}
+	}
+}
+
+// lockSubscription looks up a subscription and locks it. The caller must hold
+// s.mu for reading and unlock the returned state when done.
+func (s *Storage) lockSubscription(name string) (*subscriptionState, error) {
+	state, exists := s.subscriptions[name]
+	if !exists {
+		return nil, ErrSubscriptionNotFound
+	}
+	state.mu.Lock()
+	return state, nil
+}
+
+// Pull retrieves messages from a subscription
+func (s *Storage) Pull(subscriptionName string, maxMessages int) ([]ReceivedMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, err := s.lockSubscription(subscriptionName)
+	if err != nil {
+		return nil, err
+	}
+	defer state.mu.Unlock()
+
+	receivedMessages := make([]ReceivedMessage, 0, maxMessages)
+	now := s.clock.Now()
+
+	// Set ack deadline - message won't be redelivered until this time
+	deadline := now.Add(ackDeadline())
+
+	// Only return messages that are not acked and whose deadline has passed
+	// (deadline is zero/past for new messages, making them immediately visible).
+	// Under a chaos policy all of them are candidates for a random pick.
+	var available []*InternalMessage
+	for _, msg := range state.messages {
+		if len(available) >= maxMessages && state.chaos == nil {
+			break
+		}
+		if msg.AckedAt == nil && msg.DeadlineAt.Before(now) {
+			available = append(available, msg)
+		}
+	}
+	if state.chaos != nil {
+		available = state.chaos.deliverable(available, maxMessages, now)
+	}
+
+	rec := &walRecord{Op: walOpLease, Name: subscriptionName}
+	observed := s.events.active()
+	var events []Event
+	for _, msg := range available {
+		leaseDeadline := deadline
+		if state.chaos != nil {
+			leaseDeadline = state.chaos.leaseDeadline(deadline, now)
+		}
+		receivedMessages = append(receivedMessages, ReceivedMessage{
+			AckID:   msg.AckID,
+			Message: msg.message(),
+		})
+		rec.Leases = append(rec.Leases, walLease{AckID: msg.AckID, DeadlineAt: leaseDeadline, Delivered: true})
+		if observed {
+			events = appendDeliveryEvents(events, subscriptionName, msg.body.message.MessageID, msg.AckID,
+				msg.DeliveryAttempts, msg.DeadlineAt, leaseDeadline, now)
+		}
+	}
+
+	if len(rec.Leases) == 0 {
+		return receivedMessages, nil
+	}
+	if err := s.record(rec); err != nil {
+		return nil, err
+	}
+	applyLeases(state, rec.Leases)
+
+	for _, e := range events {
+		s.events.emit(e)
+	}
+	return receivedMessages, nil
+}
+
+// appendDeliveryEvents adds the events for leasing a message to a subscriber
+// until deadline: an expire event if the previous lease ran out, then the
+// delivery itself
+func appendDeliveryEvents(events []Event, subscriptionName, messageID, ackID string, attempts int, previous, deadline, now time.Time) []Event {
+	e := Event{Time: now, Subscription: subscriptionName, MessageID: messageID, AckID: ackID}
+	if attempts > 0 && !previous.IsZero() {
+		expire := e
+		expire.Kind = EventExpire
+		events = append(events, expire)
+	}
+	e.Kind = EventDeliver
+	e.Attempt = attempts + 1
+	e.Deadline = deadline
+	return append(events, e)
+}
+
+// applyLeases sets the deadlines of leased messages. The caller must hold the
+// subscription lock.
+func applyLeases(state *subscriptionState, leases []walLease) {
+	byAckID := make(map[string]walLease, len(leases))
+	for _, lease := range leases {
+		byAckID[lease.AckID] = lease
+	}
+
+	for _, msg := range state.messages {
+		if lease, ok := byAckID[msg.AckID]; ok && msg.AckedAt == nil {
+			msg.DeadlineAt = lease.DeadlineAt
+			if lease.Delivered {
+				msg.DeliveryAttempts++
+			}
+		}
+	}
+}
+
+// Acknowledge acknowledges messages
+func (s *Storage) Acknowledge(subscriptionName string, ackIDs []string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, err := s.lockSubscription(subscriptionName)
+	if err != nil {
+		return err
+	}
+	defer state.mu.Unlock()
+
+	now := s.clock.Now()
+	if state.chaos != nil {
+		var lost []string
+		ackIDs, lost = state.chaos.splitAcks(ackIDs)
+		if err := s.loseAcks(state, lost); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.acknowledge(state, ackIDs, now)
+	return err
+}
+
+// acknowledge journals and applies an acknowledgement and returns the number
+// of messages it removed. The caller must hold the subscription lock.
+func (s *Storage) acknowledge(state *subscriptionState, ackIDs []string, now time.Time) (int, error) {
+	name := state.subscription.Name
+	if err := s.record(&walRecord{Op: walOpAcknowledge, Name: name, AckIDs: ackIDs}); err != nil {
+		return 0, err
+	}
+	acked := applyAcknowledge(state, ackIDs, now)
+	for _, msg := range acked {
+		s.events.emit(Event{
+			Kind:         EventAck,
+			Time:         now,
+			Subscription: name,
+			MessageID:    msg.body.message.MessageID,
+			AckID:        msg.AckID,
+		})
+	}
+	return len(acked), nil
+}
+
+// Purge acknowledges every message in a subscription's backlog, leased or
+// not, and returns how many there were. Chaos policies do not apply.
+func (s *Storage) Purge(subscriptionName string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, err := s.lockSubscription(subscriptionName)
+	if err != nil {
+		return 0, err
+	}
+	defer state.mu.Unlock()
+
+	var ackIDs []string
+	for _, msg := range state.messages {
+		if msg.AckedAt == nil {
+			ackIDs = append(ackIDs, msg.AckID)
+		}
+	}
+	if len(ackIDs) == 0 {
+		return 0, nil
+	}
+	return s.acknowledge(state, ackIDs, s.clock.Now())
+}
+
+// applyAcknowledge drops acknowledged messages from the backlog and returns
+// them. The caller must hold the subscription lock.
+func applyAcknowledge(state *subscriptionState, ackIDs []string, now time.Time) []*InternalMessage {
+	ackIDSet := make(map[string]bool)
+	for _, id := range ackIDs {
+		ackIDSet[id] = true
+	}
+
+	newMessages := make([]*InternalMessage, 0, len(state.messages))
+	var acked []*InternalMessage
+
+	for _, msg := range state.messages {
+		if ackIDSet[msg.AckID] && msg.AckedAt == nil {
+			msg.AckedAt = &now
+			acked = append(acked, msg)
+		}
+		// Keep only non-acked messages
+		if msg.AckedAt == nil {
+			newMessages = append(newMessages, msg)
+		} else {
+			msg.body.release()
+		}
+	}
+
+	state.messages = newMessages
+	return acked
+}
+
+// ModifyAckDeadline modifies the acknowledgement deadline for messages
+func (s *Storage) ModifyAckDeadline(subscriptionName string, ackIDs []string, ackDeadlineSeconds int) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, err := s.lockSubscription(subscriptionName)
+	if err != nil {
+		return err
+	}
+	defer state.mu.Unlock()
+
+	ackIDSet := make(map[string]bool)
+	for _, id := range ackIDs {
+		ackIDSet[id] = true
+	}
+
+	// If ackDeadlineSeconds is 0, make the message immediately available for redelivery
+	deadline := time.Time{} // Zero time, always in the past
+	if ackDeadlineSeconds != 0 {
+		deadline = s.clock.Now().Add(time.Duration(ackDeadlineSeconds) * time.Second)
+	}
+
+	kind := EventModAck
+	if ackDeadlineSeconds == 0 {
+		kind = EventNack
+	}
+
+	rec := &walRecord{Op: walOpLease, Name: subscriptionName}
+	var events []Event
+	for _, msg := range state.messages {
+		if ackIDSet[msg.AckID] && msg.AckedAt == nil {
+			rec.Leases = append(rec.Leases, walLease{AckID: msg.AckID, DeadlineAt: deadline})
+			events = append(events, Event{
+				Kind:         kind,
+				Subscription: subscriptionName,
+				MessageID:    msg.body.message.MessageID,
+				AckID:        msg.AckID,
+				Deadline:     deadline,
+			})
+		}
+	}
+
+	if len(rec.Leases) == 0 {
+		return fmt.Errorf("no matching messages found for provided ack IDs")
+	}
+
+	if err := s.record(rec); err != nil {
+		return err
+	}
+	applyLeases(state, rec.Leases)
+
+	now := s.clock.Now()
+	for _, e := range events {
+		e.Time = now
+		s.events.emit(e)
+	}
+	return nil
+}
+
+// BacklogStats summarizes the unacknowledged messages of a subscription
+func (s *Storage) BacklogStats(subscriptionName string) (*BacklogStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, err := s.lockSubscription(subscriptionName)
+	if err != nil {
+		return nil, err
+	}
+	defer state.mu.Unlock()
+
+	stats := &BacklogStats{}
+	now := s.clock.Now()
+	for _, msg := range state.messages {
+		if msg.AckedAt != nil {
+			continue
+		}
+		stats.Backlog++
+		if !msg.DeadlineAt.Before(now) {
+			stats.Leased++
+		}
+		publishTime, err := time.Parse(time.RFC3339, msg.body.message.PublishTime)
+		if err == nil && (stats.OldestUnackedAt.IsZero() || publishTime.Before(stats.OldestUnackedAt)) {
+			stats.OldestUnackedAt = publishTime
+		}
+	}
+	return stats, nil
+}
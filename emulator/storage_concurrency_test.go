@@ -0,0 +1,194 @@
+package emulator
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run with -race to catch unsynchronized access between subscriptions.
+
+func TestStorage_ConcurrentPublishAndPull(t *testing.T) {
+	storage := NewStorage()
+
+	const (
+		topicCount      = 4
+		subsPerTopic    = 8
+		publishers      = 4
+		messagesPerPub  = 50
+		expectedPerSub  = publishers * messagesPerPub
+		pullBatchSize   = 7
+		concurrentPulls = 2
+	)
+
+	var subNames []string
+	for i := 0; i < topicCount; i++ {
+		topicName := fmt.Sprintf("projects/test/topics/topic%d", i)
+		storage.CreateTopic(topicName)
+		for j := 0; j < subsPerTopic; j++ {
+			subName := fmt.Sprintf("projects/test/subscriptions/topic%d-sub%d", i, j)
+			storage.CreateSubscription(subName, topicName)
+			subNames = append(subNames, subName)
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	// Publishers on every topic
+	for i := 0; i < topicCount; i++ {
+		topicName := fmt.Sprintf("projects/test/topics/topic%d", i)
+		for p := 0; p < publishers; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for m := 0; m < messagesPerPub; m++ {
+					if _, err := storage.Publish(topicName, []PubSubMessage{{Data: "dGVzdA=="}}); err != nil {
+						t.Errorf("Publish failed: %v", err)
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	// Consumers pull and acknowledge until every subscription drained its share
+	received := make(map[string]map[string]bool)
+	for _, subName := range subNames {
+		received[subName] = make(map[string]bool)
+	}
+	var receivedMu sync.Mutex
+	for _, subName := range subNames {
+		for c := 0; c < concurrentPulls; c++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				deadline := time.Now().Add(10 * time.Second)
+				for time.Now().Before(deadline) {
+					pulled, err := storage.Pull(subName, pullBatchSize)
+					if err != nil {
+						t.Errorf("Pull failed: %v", err)
+						return
+					}
+					ackIDs := make([]string, 0, len(pulled))
+					receivedMu.Lock()
+					for _, msg := range pulled {
+						received[subName][msg.Message.MessageID] = true
+						ackIDs = append(ackIDs, msg.AckID)
+					}
+					done := len(received[subName]) == expectedPerSub
+					receivedMu.Unlock()
+					if len(ackIDs) > 0 {
+						if err := storage.Acknowledge(subName, ackIDs); err != nil {
+							t.Errorf("Acknowledge failed: %v", err)
+							return
+						}
+					}
+					if done {
+						return
+					}
+					if len(pulled) == 0 {
+						// Spinning on an empty subscription would starve the
+						// publishers on a single CPU
+						time.Sleep(time.Millisecond)
+					}
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+
+	for _, subName := range subNames {
+		if got := len(received[subName]); got != expectedPerSub {
+			t.Errorf("Expected %d distinct messages in %s, got %d", expectedPerSub, subName, got)
+		}
+	}
+}
+
+func TestStorage_ConcurrentRegistryAndMessageOperations(t *testing.T) {
+	storage := NewStorage()
+	storage.CreateTopic("projects/test/topics/stable")
+	storage.CreateSubscription("projects/test/subscriptions/stable", "projects/test/topics/stable")
+
+	var wg sync.WaitGroup
+
+	// Churn topics and subscriptions while messages flow on a stable pair
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				topicName := fmt.Sprintf("projects/test/topics/churn%d-%d", i, j)
+				subName := fmt.Sprintf("projects/test/subscriptions/churn%d-%d", i, j)
+				storage.CreateTopic(topicName)
+				storage.CreateSubscription(subName, topicName)
+				storage.Publish(topicName, []PubSubMessage{{Data: "dGVzdA=="}})
+				storage.Pull(subName, 10)
+				storage.ListTopics()
+				storage.ListSubscriptions()
+				storage.DeleteSubscription(subName)
+				storage.DeleteTopic(topicName)
+			}
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				storage.Publish("projects/test/topics/stable", []PubSubMessage{{Data: "dGVzdA=="}})
+				pulled, err := storage.Pull("projects/test/subscriptions/stable", 5)
+				if err != nil {
+					t.Errorf("Pull failed: %v", err)
+					return
+				}
+				for _, msg := range pulled {
+					storage.ModifyAckDeadline("projects/test/subscriptions/stable", []string{msg.AckID}, 0)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if topics := storage.ListTopics(); len(topics) != 1 {
+		t.Errorf("Expected only the stable topic to remain, got %d topics", len(topics))
+	}
+	if subs := storage.ListSubscriptions(); len(subs) != 1 {
+		t.Errorf("Expected only the stable subscription to remain, got %d subscriptions", len(subs))
+	}
+}
+
+func TestStorage_PublishDoesNotWaitForUnrelatedSubscription(t *testing.T) {
+	storage := NewStorage()
+	storage.CreateTopic("projects/test/topics/busy")
+	storage.CreateTopic("projects/test/topics/quiet")
+	storage.CreateSubscription("projects/test/subscriptions/busy", "projects/test/topics/busy")
+	storage.CreateSubscription("projects/test/subscriptions/quiet", "projects/test/topics/quiet")
+
+	// Hold the busy subscription's lock as a stalled consumer would
+	busy := storage.subscriptions["projects/test/subscriptions/busy"]
+	busy.mu.Lock()
+	defer busy.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		if _, err := storage.Publish("projects/test/topics/quiet", []PubSubMessage{{Data: "dGVzdA=="}}); err != nil {
+			done <- err
+			return
+		}
+		_, err := storage.Pull("projects/test/subscriptions/quiet", 1)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish and pull on an unrelated subscription blocked on a locked subscription")
+	}
+}
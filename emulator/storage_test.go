@@ -0,0 +1,68 @@
+package emulator
+
+import (
+	"testing"
+)
+
+func TestStorage_PublishSharesBodyAcrossSubscriptions(t *testing.T) {
+	storage := NewStorage()
+
+	// Setup
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub2", "projects/test/topics/topic1")
+
+	messageIDs, _ := storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+
+	// Both delivery records should point to the single stored body
+	body1 := storage.subscriptions["projects/test/subscriptions/sub1"].messages[0].body
+	body2 := storage.subscriptions["projects/test/subscriptions/sub2"].messages[0].body
+	if body1 != body2 {
+		t.Error("Expected subscriptions to share one message body")
+	}
+
+	topic := storage.topics["projects/test/topics/topic1"]
+	if len(topic.messages) != 1 {
+		t.Fatalf("Expected 1 retained body, got %d", len(topic.messages))
+	}
+
+	// The body stays retained until every subscription is done with it
+	pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+	storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID})
+	if _, ok := topic.messages[messageIDs[0]]; !ok {
+		t.Error("Expected body to be retained while sub2 still holds it")
+	}
+
+	storage.DeleteSubscription("projects/test/subscriptions/sub2")
+	if len(topic.messages) != 0 {
+		t.Errorf("Expected body to be released, got %d retained", len(topic.messages))
+	}
+}
+
+func TestStorage_AttributesNotSharedWithCallers(t *testing.T) {
+	storage := NewStorage()
+
+	// Setup
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub2", "projects/test/topics/topic1")
+
+	attributes := map[string]string{"key": "value"}
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA==", Attributes: attributes}})
+
+	// Mutating the publisher's map must not affect stored messages
+	attributes["key"] = "changed"
+
+	pulled1, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+	if pulled1[0].Message.Attributes["key"] != "value" {
+		t.Errorf("Expected attribute key='value', got %s", pulled1[0].Message.Attributes["key"])
+	}
+
+	// Mutating one consumer's copy must not affect another subscription
+	pulled1[0].Message.Attributes["key"] = "changed"
+
+	pulled2, _ := storage.Pull("projects/test/subscriptions/sub2", 10)
+	if pulled2[0].Message.Attributes["key"] != "value" {
+		t.Errorf("Expected attribute key='value', got %s", pulled2[0].Message.Attributes["key"])
+	}
+}
@@ -0,0 +1,210 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// tailBufferSize is how many messages a tail stream buffers for a slow viewer
+// before it starts dropping them
+const tailBufferSize = 256
+
+// TailedMessage is a message as sent by a tail stream, with its data decoded
+type TailedMessage struct {
+	MessageID   string            `json:"messageId"`
+	PublishTime string            `json:"publishTime"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	// Data is the payload itself if it is JSON, a string if it is other
+	// text, and base64 encoded otherwise
+	Data     json.RawMessage `json:"data"`
+	Encoding string          `json:"encoding,omitempty"` // "base64" for binary data
+}
+
+// TailDropped is sent by a tail stream in place of the messages a slow viewer
+// missed because its buffer was full
+type TailDropped struct {
+	Dropped int64 `json:"dropped"`
+}
+
+// TailReset is the last event of a tail stream whose topic was wiped by a
+// reset
+type TailReset struct {
+	Project string `json:"project,omitempty"` // empty for a full reset
+}
+
+// tailViewer is a client watching the messages published to a topic
+type tailViewer struct {
+	topic      string
+	attributes map[string]string // all must match
+	messages   chan *Message
+	dropped    atomic.Int64
+	reset      chan string // receives the project when the topic is reset
+}
+
+// Tails fans the messages published to topics out to tail streams. Publishers
+// never wait for viewers: a viewer whose buffer is full misses messages.
+type Tails struct {
+	viewers map[*tailViewer]struct{}
+	closed  chan struct{}
+	close   sync.Once
+	mu      sync.RWMutex
+}
+
+// NewTails creates a Tails that observes storage
+func NewTails(storage Backend) *Tails {
+	t := &Tails{viewers: make(map[*tailViewer]struct{}), closed: make(chan struct{})}
+	storage.Observe(t.observe)
+	return t
+}
+
+// observe hands a published message to the viewers of its topic
+func (t *Tails) observe(e Event) {
+	if e.Kind != EventPublish {
+		return
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for v := range t.viewers {
+		if v.topic != e.Topic || !v.matches(e.Message) {
+			continue
+		}
+		select {
+		case v.messages <- e.Message:
+		default:
+			v.dropped.Add(1)
+		}
+	}
+}
+
+// Close ends all tail streams, e.g. before the HTTP server shuts down
+func (t *Tails) Close() {
+	t.close.Do(func() { close(t.closed) })
+}
+
+// reset ends the streams of the topics of a project, or of all topics if
+// project is empty
+func (t *Tails) reset(project string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for v := range t.viewers {
+		if inProject(v.topic, project) {
+			v.reset <- project
+			delete(t.viewers, v)
+		}
+	}
+}
+
+func (t *Tails) add(v *tailViewer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.viewers[v] = struct{}{}
+}
+
+func (t *Tails) remove(v *tailViewer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.viewers, v)
+}
+
+func (v *tailViewer) matches(msg *Message) bool {
+	for key, value := range v.attributes {
+		if actual, ok := msg.Attributes[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// tailMessage decodes a message for a tail stream
+func tailMessage(msg *Message) TailedMessage {
+	tailed := TailedMessage{MessageID: msg.MessageID, PublishTime: msg.PublishTime, Attributes: msg.Attributes}
+	decoded, err := DecodeData(msg.Data)
+	switch {
+	case err == nil && len(decoded) > 0 && json.Valid(decoded):
+		tailed.Data = decoded
+	case err == nil && utf8.Valid(decoded):
+		tailed.Data, _ = json.Marshal(string(decoded))
+	default:
+		tailed.Data, _ = json.Marshal(msg.Data)
+		tailed.Encoding = "base64"
+	}
+	return tailed
+}
+
+// handleTailTopic streams the messages published to a topic from now on as
+// Server-Sent Events until the client goes away or the topic is reset. Query
+// parameters of the form attribute=key=value only pass messages with those
+// attributes.
+func (s *Server) handleTailTopic(w http.ResponseWriter, r *http.Request, topicName string) {
+	viewer := &tailViewer{topic: topicName, messages: make(chan *Message, tailBufferSize), reset: make(chan string, 1)}
+	for _, attr := range r.URL.Query()["attribute"] {
+		key, value, found := strings.Cut(attr, "=")
+		if !found {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "attribute must be key=value"})
+			return
+		}
+		if viewer.attributes == nil {
+			viewer.attributes = make(map[string]string)
+		}
+		viewer.attributes[key] = value
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming is not supported"})
+		return
+	}
+	if _, err := s.storage.GetTopic(topicName); err != nil {
+		if err == ErrTopicNotFound {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		} else {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return
+	}
+
+	s.tails.add(viewer)
+	defer s.tails.remove(viewer)
+	logger.Info("tail started", "operation", "tail", "topic", topicName)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// A comment line, so that clients see the stream is open
+	fmt.Fprint(w, ": tailing "+topicName+"\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case msg := <-viewer.messages:
+			if dropped := viewer.dropped.Swap(0); dropped > 0 {
+				writeEvent(w, "dropped", "", TailDropped{Dropped: dropped})
+			}
+			tailed := tailMessage(msg)
+			writeEvent(w, "message", tailed.MessageID, tailed)
+			flusher.Flush()
+		case project := <-viewer.reset:
+			writeEvent(w, "reset", "", TailReset{Project: project})
+			flusher.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.tails.closed:
+			return
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event with a JSON payload
+func writeEvent(w http.ResponseWriter, event, id string, v any) {
+	data, _ := json.Marshal(v)
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,185 @@
+package emulator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTail opens a tail stream and returns a reader positioned after the
+// comment that opens it
+func openTail(t *testing.T, url string) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	readEvent(t, reader)
+	return reader
+}
+
+// readEvent reads the lines of the next event
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if line == "\n" {
+			return lines
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func TestHandleTailTopic(t *testing.T) {
+	storage := NewStorage()
+	storage.SetIDGenerator(NewSequentialIDs(1))
+	storage.CreateTopic("projects/test/topics/topic1")
+	ts := httptest.NewServer(NewServerWithStorage(storage))
+	t.Cleanup(ts.Close) // runs after the stream is cancelled
+
+	reader := openTail(t, ts.URL+"/admin/v1/projects/test/topics/topic1:tail?attribute=env=prod")
+
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{
+		{Data: EncodeData([]byte(`{"id": 7}`)), Attributes: map[string]string{"env": "dev"}},
+		{Data: EncodeData([]byte(`{"id": 8}`)), Attributes: map[string]string{"env": "prod"}},
+		{Data: EncodeData([]byte{0xff, 0xfe}), Attributes: map[string]string{"env": "prod"}},
+	})
+
+	lines := readEvent(t, reader)
+	if len(lines) != 3 || lines[0] != "id: 2" || lines[1] != "event: message" {
+		t.Fatalf("Expected the second message, got %q", lines)
+	}
+	var msg TailedMessage
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &msg); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(msg.Data) != `{"id":8}` || msg.Attributes["env"] != "prod" || msg.Encoding != "" {
+		t.Errorf("Expected the decoded JSON payload, got %s", lines[2])
+	}
+
+	lines = readEvent(t, reader)
+	if len(lines) != 3 || !strings.Contains(lines[2], `"data":"//4="`) || !strings.Contains(lines[2], `"encoding":"base64"`) {
+		t.Errorf("Expected base64 for binary data, got %q", lines)
+	}
+}
+
+func TestHandleTailTopic_Errors(t *testing.T) {
+	server := NewServer()
+	server.storage.CreateTopic("projects/test/topics/topic1")
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/admin/v1/projects/test/topics/missing:tail", http.StatusNotFound},
+		{http.MethodGet, "/admin/v1/projects/test/topics/topic1:tail?attribute=env", http.StatusBadRequest},
+		{http.MethodPost, "/admin/v1/projects/test/topics/topic1:tail", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.status, w.Code)
+		}
+	}
+}
+
+func TestTails_SlowViewer(t *testing.T) {
+	storage := NewStorage()
+	storage.CreateTopic("projects/test/topics/topic1")
+	tails := NewTails(storage)
+	viewer := &tailViewer{topic: "projects/test/topics/topic1", messages: make(chan *Message, 2)}
+	tails.add(viewer)
+
+	// Nobody reads from the viewer, yet publishing must not block
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 5 {
+			storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: EncodeData([]byte("hello"))}})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected publishing not to wait for the viewer")
+	}
+	if len(viewer.messages) != 2 || viewer.dropped.Load() != 3 {
+		t.Errorf("Expected 2 buffered and 3 dropped messages, got %d and %d", len(viewer.messages), viewer.dropped.Load())
+	}
+
+	tails.remove(viewer)
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: EncodeData([]byte("hello"))}})
+	if viewer.dropped.Load() != 3 {
+		t.Errorf("Expected a removed viewer to be skipped, got %d dropped", viewer.dropped.Load())
+	}
+}
+
+func TestEmulator_CloseEndsTails(t *testing.T) {
+	emu, err := Start(context.Background(), Options{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	emu.Storage().CreateTopic("projects/test/topics/topic1")
+	openTail(t, emu.URL()+"/admin/v1/projects/test/topics/topic1:tail")
+
+	closed := make(chan error)
+	go func() { closed <- emu.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Close to end the tail stream")
+	}
+}
+
+func TestHandleTailTopic_EndsOnReset(t *testing.T) {
+	server := NewServer()
+	server.storage.CreateTopic("projects/test/topics/topic1")
+	server.storage.CreateTopic("projects/other/topics/topic1")
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close) // runs after the streams are cancelled
+
+	reset := openTail(t, ts.URL+"/admin/v1/projects/test/topics/topic1:tail")
+	kept := openTail(t, ts.URL+"/admin/v1/projects/other/topics/topic1:tail")
+
+	resp, err := http.Post(ts.URL+"/admin/v1/projects/test:reset", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	resp.Body.Close()
+
+	lines := readEvent(t, reset)
+	if len(lines) != 2 || lines[0] != "event: reset" || lines[1] != `data: {"project":"test"}` {
+		t.Errorf("Expected a reset event, got %q", lines)
+	}
+	if _, err := reset.ReadString('\n'); err != io.EOF {
+		t.Errorf("Expected the stream to end, got %v", err)
+	}
+
+	server.storage.Publish("projects/other/topics/topic1", []PubSubMessage{{Data: EncodeData([]byte("hello"))}})
+	if lines := readEvent(t, kept); len(lines) != 3 || lines[1] != "event: message" {
+		t.Errorf("Expected the stream of another project to go on, got %q", lines)
+	}
+}
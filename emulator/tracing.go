@@ -0,0 +1,632 @@
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds and status codes of the OTLP data model
+const (
+	spanKindServer   = 2
+	spanKindConsumer = 5
+
+	spanStatusOK    = 1
+	spanStatusError = 2
+)
+
+const (
+	// maxOpenMessageSpans bounds the number of messages traced at once; new
+	// messages are not traced while the limit is reached, with a warning
+	// logged each time it is reached
+	maxOpenMessageSpans = 100000
+
+	traceBatchSize     = 512
+	traceQueueSize     = 4096
+	traceFlushInterval = time.Second
+)
+
+// spanContext identifies a span within a trace
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+func (sc spanContext) valid() bool {
+	return sc.traceID != [16]byte{} && sc.spanID != [8]byte{}
+}
+
+// parseTraceparent parses a W3C traceparent header value
+func parseTraceparent(value string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	traceID, err1 := hex.DecodeString(parts[1])
+	spanID, err2 := hex.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || len(traceID) != 16 || len(spanID) != 8 {
+		return sc, false
+	}
+	copy(sc.traceID[:], traceID)
+	copy(sc.spanID[:], spanID)
+	return sc, sc.valid()
+}
+
+// publisherContext extracts the trace context a client library propagated in
+// message attributes. The Go client writes googclient_traceparent; older
+// clients used googclient_OpenTelemetry* attributes.
+func publisherContext(attributes map[string]string) (spanContext, bool) {
+	if sc, ok := parseTraceparent(attributes["googclient_traceparent"]); ok {
+		return sc, true
+	}
+	for key, value := range attributes {
+		if strings.HasPrefix(key, "googclient_OpenTelemetry") {
+			if sc, ok := parseTraceparent(value); ok {
+				return sc, true
+			}
+		}
+	}
+	return spanContext{}, false
+}
+
+// span is a span being recorded
+type span struct {
+	name       string
+	kind       int
+	ctx        spanContext
+	parent     [8]byte
+	start      time.Time
+	attributes map[string]any
+	events     []spanEvent
+	links      []spanContext
+}
+
+type spanEvent struct {
+	name       string
+	time       time.Time
+	attributes map[string]any
+}
+
+// Tracer records spans for API calls and for the lifecycle of every message
+// and exports them in batches in the OTLP JSON encoding.
+//
+// For each message and subscription it fanned out to, a message span runs
+// from publish to ack, parented to the publisher's context. Each delivery is
+// a child span that ends with the ack, nack or expiry of that lease.
+//
+// The servers of namespaces each trace with their own Tracer, which keeps
+// track of the messages of that namespace only but shares the exporters.
+type Tracer struct {
+	pipeline  *tracePipeline
+	namespace string // "" for the shared view
+
+	messages map[string]map[string]*messageTrace // by message ID, then subscription
+	open     int                                 // number of message spans in messages
+	capped   bool                                // whether messages go untraced over the limit
+	mu       sync.Mutex
+}
+
+// messageTrace holds the open spans of a message in one subscription
+type messageTrace struct {
+	span     *span
+	delivery *span // current lease, if any
+}
+
+// tracePipeline batches the finished spans of one or more tracers and hands
+// them to the exporters
+type tracePipeline struct {
+	exporters []SpanExporter
+	queue     chan otlpSpan
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTracer creates a Tracer exporting to the given exporters
+func NewTracer(exporters ...SpanExporter) *Tracer {
+	p := &tracePipeline{
+		exporters: exporters,
+		queue:     make(chan otlpSpan, traceQueueSize),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go p.run()
+	return &Tracer{pipeline: p, messages: make(map[string]map[string]*messageTrace)}
+}
+
+// forNamespace returns a Tracer for the server of a namespace. It exports
+// through t and is closed with it.
+func (t *Tracer) forNamespace(name string) *Tracer {
+	return &Tracer{pipeline: t.pipeline, namespace: name, messages: make(map[string]map[string]*messageTrace)}
+}
+
+// Close flushes pending spans and stops the exporter. Message spans that are
+// still open (unacked messages) are not exported.
+func (t *Tracer) Close() error {
+	p := t.pipeline
+	p.closeOnce.Do(func() { close(p.stop) })
+	<-p.done
+
+	var firstErr error
+	for _, exporter := range p.exporters {
+		if err := exporter.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func newSpanContext(parent spanContext) spanContext {
+	sc := spanContext{traceID: parent.traceID}
+	if !parent.valid() {
+		putUint64s(sc.traceID[:], rand.Uint64(), rand.Uint64())
+	}
+	putUint64s(sc.spanID[:], rand.Uint64())
+	return sc
+}
+
+func putUint64s(b []byte, values ...uint64) {
+	for i, v := range values {
+		for j := 0; j < 8; j++ {
+			b[i*8+j] = byte(v >> (56 - 8*j))
+		}
+	}
+}
+
+func (t *Tracer) startSpan(name string, kind int, parent spanContext, start time.Time, attributes map[string]any) *span {
+	sp := &span{
+		name:       name,
+		kind:       kind,
+		ctx:        newSpanContext(parent),
+		start:      start,
+		attributes: attributes,
+	}
+	if parent.valid() {
+		sp.parent = parent.spanID
+	}
+	if t.namespace != "" {
+		attributes["emulator.namespace"] = t.namespace
+	}
+	return sp
+}
+
+// end queues a finished span for export without ever blocking; spans are
+// dropped if the exporter cannot keep up
+func (t *Tracer) end(sp *span, end time.Time, status int) {
+	select {
+	case t.pipeline.queue <- sp.otlp(end, status):
+	default:
+	}
+}
+
+type spanContextKey struct{}
+
+// startRequest starts a span for an API call, continuing the caller's trace
+// if the request carries a traceparent header
+func (t *Tracer) startRequest(r *http.Request, method string) (*span, *http.Request) {
+	parent, _ := parseTraceparent(r.Header.Get("traceparent"))
+	sp := t.startSpan("pubsub."+method, spanKindServer, parent, time.Now(), map[string]any{
+		"rpc.system":          "pubsub-emulator",
+		"rpc.method":          method,
+		"http.request.method": r.Method,
+		"url.path":            r.URL.Path,
+	})
+	return sp, r.WithContext(context.WithValue(r.Context(), spanContextKey{}, sp.ctx))
+}
+
+// endRequest finishes the span of an API call
+func (t *Tracer) endRequest(sp *span, status int) {
+	sp.attributes["http.response.status_code"] = status
+	code := spanStatusOK
+	if status >= 500 {
+		code = spanStatusError
+	}
+	t.end(sp, time.Now(), code)
+}
+
+// linkRequest links the message spans of the given messages in all
+// subscriptions, or their current delivery spans in one subscription, to
+// the API call in ctx
+func (t *Tracer) linkRequest(ctx context.Context, messageIDs []string, subscriptionName string) {
+	if t == nil {
+		return
+	}
+	request, ok := ctx.Value(spanContextKey{}).(spanContext)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range messageIDs {
+		for subscription, trace := range t.messages[id] {
+			if subscriptionName == "" {
+				trace.span.links = append(trace.span.links, request)
+			} else if subscription == subscriptionName && trace.delivery != nil {
+				trace.delivery.links = append(trace.delivery.links, request)
+			}
+		}
+	}
+}
+
+// linkPublish links the message spans of published messages to the Publish
+// call in ctx
+func (t *Tracer) linkPublish(ctx context.Context, messageIDs []string) {
+	t.linkRequest(ctx, messageIDs, "")
+}
+
+// linkPull links the delivery spans of pulled messages to the Pull call in ctx
+func (t *Tracer) linkPull(ctx context.Context, subscriptionName string, messages []ReceivedMessage) {
+	if t == nil || len(messages) == 0 {
+		return
+	}
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.Message.MessageID
+	}
+	t.linkRequest(ctx, ids, subscriptionName)
+}
+
+// observe records message lifecycle events. It is registered with the
+// backend and therefore must not block.
+func (t *Tracer) observe(e Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch e.Kind {
+	case EventPublish:
+		if len(e.Fanout) == 0 {
+			return
+		}
+		if t.open+len(e.Fanout) > maxOpenMessageSpans {
+			if !t.capped {
+				logger.Warn("too many open message spans, not tracing new messages",
+					"operation", "trace_message",
+					"open_spans", t.open,
+					"limit", maxOpenMessageSpans)
+				t.capped = true
+			}
+			return
+		}
+		t.capped = false
+		parent, _ := publisherContext(e.Message.Attributes)
+		traces := make(map[string]*messageTrace, len(e.Fanout))
+		for _, subscription := range e.Fanout {
+			traces[subscription] = &messageTrace{span: t.startSpan(subscription+" message", spanKindConsumer, parent, e.Time, map[string]any{
+				"messaging.system":                  "gcp_pubsub",
+				"messaging.destination.name":        e.Topic,
+				"messaging.message.id":              e.MessageID,
+				"messaging.gcp_pubsub.subscription": subscription,
+				"messaging.message.body.size":       messageSize(e.Message),
+			})}
+		}
+		t.messages[e.MessageID] = traces
+		t.open += len(traces)
+		return
+	case EventReset:
+		for id, traces := range t.messages {
+			for subscription, trace := range traces {
+				if inProject(subscription, e.Project) {
+					t.endMessage(trace, e.Time, "reset")
+					t.forget(id, subscription)
+				}
+			}
+		}
+		return
+	case EventDelete:
+		if e.Subscription == "" {
+			return // messages outlive their topic in its subscriptions
+		}
+		for id, traces := range t.messages {
+			if trace := traces[e.Subscription]; trace != nil {
+				t.endMessage(trace, e.Time, "deleted")
+				t.forget(id, e.Subscription)
+			}
+		}
+		return
+	}
+
+	trace := t.messages[e.MessageID][e.Subscription]
+	if trace == nil {
+		return // published before tracing started, or over the limit
+	}
+
+	switch e.Kind {
+	case EventDeliver:
+		trace.span.events = append(trace.span.events, spanEvent{name: "deliver", time: e.Time, attributes: map[string]any{
+			"messaging.gcp_pubsub.message.delivery_attempt": e.Attempt,
+		}})
+		trace.delivery = t.startSpan(e.Subscription+" deliver", spanKindConsumer, trace.span.ctx, e.Time, map[string]any{
+			"messaging.system":                              "gcp_pubsub",
+			"messaging.message.id":                          e.MessageID,
+			"messaging.gcp_pubsub.message.ack_id":           e.AckID,
+			"messaging.gcp_pubsub.message.delivery_attempt": e.Attempt,
+		})
+	case EventModAck:
+		if trace.delivery != nil {
+			trace.delivery.events = append(trace.delivery.events, spanEvent{name: "modack", time: e.Time})
+		}
+	case EventNack, EventExpire:
+		trace.span.events = append(trace.span.events, spanEvent{name: string(e.Kind), time: e.Time})
+		t.endDelivery(trace, e.Time, string(e.Kind))
+	case EventAck:
+		t.endMessage(trace, e.Time, "ack")
+		t.forget(e.MessageID, e.Subscription)
+	}
+}
+
+// forget drops the spans of a message in a subscription. The caller must
+// hold t.mu.
+func (t *Tracer) forget(messageID, subscription string) {
+	delete(t.messages[messageID], subscription)
+	if len(t.messages[messageID]) == 0 {
+		delete(t.messages, messageID)
+	}
+	t.open--
+}
+
+// endDelivery ends the current lease span of a message. The caller must hold
+// t.mu.
+func (t *Tracer) endDelivery(trace *messageTrace, end time.Time, outcome string) {
+	if trace.delivery == nil {
+		return
+	}
+	trace.delivery.attributes["messaging.gcp_pubsub.outcome"] = outcome
+	t.end(trace.delivery, end, spanStatusOK)
+	trace.delivery = nil
+}
+
+// endMessage ends the delivery and message spans. The caller must hold t.mu.
+func (t *Tracer) endMessage(trace *messageTrace, end time.Time, outcome string) {
+	t.endDelivery(trace, end, outcome)
+	trace.span.attributes["messaging.gcp_pubsub.outcome"] = outcome
+	t.end(trace.span, end, spanStatusOK)
+}
+
+// run batches finished spans and hands them to the exporters
+func (p *tracePipeline) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	var batch []otlpSpan
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		for _, exporter := range p.exporters {
+			if err := exporter.export(batch); err != nil {
+				logger.Error("failed to export spans",
+					"operation", "export_spans",
+					"span_count", len(batch),
+					"error", err.Error())
+			}
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case sp := <-p.queue:
+			batch = append(batch, sp)
+			if len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-p.stop:
+			for {
+				select {
+				case sp := <-p.queue:
+					batch = append(batch, sp)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// OTLP JSON encoding (opentelemetry-proto, ExportTraceServiceRequest)
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func otlpAttributes(attributes map[string]any) []otlpKeyValue {
+	keys := sortedKeys(attributes)
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		var value otlpAnyValue
+		switch v := attributes[key].(type) {
+		case int:
+			s := strconv.Itoa(v)
+			value.IntValue = &s
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		kvs = append(kvs, otlpKeyValue{Key: key, Value: value})
+	}
+	return kvs
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlp converts a finished span to its export form
+func (sp *span) otlp(end time.Time, status int) otlpSpan {
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(sp.ctx.traceID[:]),
+		SpanID:            hex.EncodeToString(sp.ctx.spanID[:]),
+		Name:              sp.name,
+		Kind:              sp.kind,
+		StartTimeUnixNano: unixNano(sp.start),
+		EndTimeUnixNano:   unixNano(end),
+		Attributes:        otlpAttributes(sp.attributes),
+		Status:            otlpStatus{Code: status},
+	}
+	if sp.parent != [8]byte{} {
+		out.ParentSpanID = hex.EncodeToString(sp.parent[:])
+	}
+	for _, e := range sp.events {
+		out.Events = append(out.Events, otlpEvent{TimeUnixNano: unixNano(e.time), Name: e.name, Attributes: otlpAttributes(e.attributes)})
+	}
+	for _, link := range sp.links {
+		out.Links = append(out.Links, otlpLink{TraceID: hex.EncodeToString(link.traceID[:]), SpanID: hex.EncodeToString(link.spanID[:])})
+	}
+	return out
+}
+
+func newExportRequest(spans []otlpSpan) otlpExportRequest {
+	serviceName := "pubsub-emulator"
+	return otlpExportRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: otlpAnyValue{StringValue: &serviceName}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/kyontan/cloud-pubsub-emulator-lite"},
+			Spans: spans,
+		}},
+	}}}
+}
+
+// SpanExporter sends batches of finished spans somewhere. Exporters are
+// created with NewOTLPExporter and NewFileExporter.
+type SpanExporter interface {
+	export(spans []otlpSpan) error
+	close() error
+}
+
+// otlpHTTPExporter posts spans to an OTLP/HTTP collector using JSON
+type otlpHTTPExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLPExporter creates an exporter for a collector such as
+// http://localhost:4318
+func NewOTLPExporter(endpoint string) SpanExporter {
+	return &otlpHTTPExporter{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *otlpHTTPExporter) export(spans []otlpSpan) error {
+	body, err := json.Marshal(newExportRequest(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *otlpHTTPExporter) close() error {
+	return nil
+}
+
+// fileSpanExporter appends one export request per line, the format read by
+// the collector's otlpjsonfile receiver
+type fileSpanExporter struct {
+	file *os.File
+}
+
+// NewFileExporter creates an exporter appending to the file at path
+func NewFileExporter(path string) (SpanExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	return &fileSpanExporter{file: file}, nil
+}
+
+func (e *fileSpanExporter) export(spans []otlpSpan) error {
+	line, err := json.Marshal(newExportRequest(spans))
+	if err != nil {
+		return err
+	}
+	_, err = e.file.Write(append(line, '\n'))
+	return err
+}
+
+func (e *fileSpanExporter) close() error {
+	return e.file.Close()
+}
@@ -0,0 +1,310 @@
+package emulator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const testTraceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{testTraceparent, true},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01", false},
+		{"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false},
+		{"00-0af7651916cd43dd-b7ad6b7169203331-01", false},
+		{"not a traceparent", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if _, ok := parseTraceparent(tt.value); ok != tt.valid {
+			t.Errorf("Expected parseTraceparent(%q) valid=%v, got %v", tt.value, tt.valid, ok)
+		}
+	}
+}
+
+func TestPublisherContext(t *testing.T) {
+	sc, ok := publisherContext(map[string]string{"googclient_traceparent": testTraceparent})
+	if !ok {
+		t.Fatal("Expected a context from googclient_traceparent")
+	}
+	if got := hex.EncodeToString(sc.spanID[:]); got != "b7ad6b7169203331" {
+		t.Errorf("Expected span ID b7ad6b7169203331, got %s", got)
+	}
+
+	if _, ok := publisherContext(map[string]string{"googclient_OpenTelemetrySpanContext": testTraceparent}); !ok {
+		t.Error("Expected a context from googclient_OpenTelemetry attributes")
+	}
+	if _, ok := publisherContext(map[string]string{"other": testTraceparent}); ok {
+		t.Error("Expected no context from unrelated attributes")
+	}
+}
+
+// readTraceFile returns all spans in a file written by the file exporter
+func readTraceFile(t *testing.T, path string) []otlpSpan {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer file.Close()
+
+	var spans []otlpSpan
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var req otlpExportRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatalf("Expected valid JSON, got %v", err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func spansByName(spans []otlpSpan) map[string][]otlpSpan {
+	byName := make(map[string][]otlpSpan)
+	for _, sp := range spans {
+		byName[sp.Name] = append(byName[sp.Name], sp)
+	}
+	return byName
+}
+
+func spanAttribute(sp otlpSpan, key string) string {
+	for _, kv := range sp.Attributes {
+		if kv.Key == key {
+			if kv.Value.StringValue != nil {
+				return *kv.Value.StringValue
+			}
+			if kv.Value.IntValue != nil {
+				return *kv.Value.IntValue
+			}
+		}
+	}
+	return ""
+}
+
+func TestTracing_MessageLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tracer := NewTracer(exporter)
+
+	server := NewServer()
+	server.EnableTracing(tracer)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for %s, got %d", http.StatusOK, path, w.Code)
+		}
+		return w
+	}
+
+	do(http.MethodPut, "/v1/projects/test/topics/topic1", "")
+	do(http.MethodPut, "/v1/projects/test/subscriptions/sub1", `{"topic": "projects/test/topics/topic1"}`)
+	do(http.MethodPut, "/v1/projects/test/subscriptions/sub2", `{"topic": "projects/test/topics/topic1"}`)
+	do(http.MethodPost, "/v1/projects/test/topics/topic1:publish",
+		`{"messages": [{"data": "dGVzdA==", "attributes": {"googclient_traceparent": "`+testTraceparent+`"}}]}`)
+
+	// Nack the first delivery in sub1, then ack the redelivery
+	var pull PullResponse
+	json.Unmarshal(do(http.MethodPost, "/v1/projects/test/subscriptions/sub1:pull", `{"maxMessages": 1}`).Body.Bytes(), &pull)
+	do(http.MethodPost, "/v1/projects/test/subscriptions/sub1:modifyAckDeadline",
+		`{"ackIds": ["`+pull.ReceivedMessages[0].AckID+`"], "ackDeadlineSeconds": 0}`)
+	json.Unmarshal(do(http.MethodPost, "/v1/projects/test/subscriptions/sub1:pull", `{"maxMessages": 1}`).Body.Bytes(), &pull)
+	do(http.MethodPost, "/v1/projects/test/subscriptions/sub1:acknowledge",
+		`{"ackIds": ["`+pull.ReceivedMessages[0].AckID+`"]}`)
+
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	spans := spansByName(readTraceFile(t, path))
+
+	// sub2 never acked, so only sub1's message span is exported
+	messages := spans["projects/test/subscriptions/sub1 message"]
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message span, got %d", len(messages))
+	}
+	if len(spans["projects/test/subscriptions/sub2 message"]) != 0 {
+		t.Error("Expected the unacked message span not to be exported")
+	}
+	message := messages[0]
+	if message.TraceID != "0af7651916cd43dd8448eb211c80319c" || message.ParentSpanID != "b7ad6b7169203331" {
+		t.Errorf("Expected the message span parented to the publisher, got trace %s parent %s", message.TraceID, message.ParentSpanID)
+	}
+	if got := spanAttribute(message, "messaging.gcp_pubsub.outcome"); got != "ack" {
+		t.Errorf("Expected outcome ack, got %q", got)
+	}
+	if len(message.Events) != 3 {
+		t.Errorf("Expected deliver, nack and deliver events, got %d events", len(message.Events))
+	}
+
+	publishes := spans["pubsub.Publish"]
+	if len(publishes) != 1 {
+		t.Fatalf("Expected 1 Publish span, got %d", len(publishes))
+	}
+	if len(message.Links) != 1 || message.Links[0].SpanID != publishes[0].SpanID {
+		t.Errorf("Expected the message span linked to the Publish call, got %+v", message.Links)
+	}
+
+	deliveries := spans["projects/test/subscriptions/sub1 deliver"]
+	if len(deliveries) != 2 {
+		t.Fatalf("Expected 2 delivery spans, got %d", len(deliveries))
+	}
+	pulls := spans["pubsub.Pull"]
+	outcomes := map[string]bool{}
+	for _, delivery := range deliveries {
+		if delivery.ParentSpanID != message.SpanID || delivery.TraceID != message.TraceID {
+			t.Errorf("Expected the delivery span to be a child of the message span")
+		}
+		outcomes[spanAttribute(delivery, "messaging.gcp_pubsub.outcome")] = true
+		if len(delivery.Links) != 1 {
+			t.Errorf("Expected the delivery span linked to its Pull call, got %d links", len(delivery.Links))
+			continue
+		}
+		linked := false
+		for _, pull := range pulls {
+			linked = linked || pull.SpanID == delivery.Links[0].SpanID
+		}
+		if !linked {
+			t.Errorf("Expected the delivery span linked to a Pull span")
+		}
+	}
+	if !outcomes["nack"] || !outcomes["ack"] {
+		t.Errorf("Expected a nacked and an acked delivery, got %v", outcomes)
+	}
+
+	for _, name := range []string{"pubsub.CreateTopic", "pubsub.CreateSubscription", "pubsub.ModifyAckDeadline", "pubsub.Acknowledge"} {
+		if len(spans[name]) == 0 {
+			t.Errorf("Expected a span for %s", name)
+		}
+	}
+}
+
+func TestTracing_RequestParent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tracer := NewTracer(exporter)
+	server := NewServer()
+	server.EnableTracing(tracer)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/projects/test/topics/missing", nil)
+	req.Header.Set("traceparent", testTraceparent)
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Close()
+
+	spans := spansByName(readTraceFile(t, path))["pubsub.GetTopic"]
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 GetTopic span, got %d", len(spans))
+	}
+	if spans[0].TraceID != "0af7651916cd43dd8448eb211c80319c" || spans[0].ParentSpanID != "b7ad6b7169203331" {
+		t.Errorf("Expected the span to continue the caller's trace, got trace %s parent %s", spans[0].TraceID, spans[0].ParentSpanID)
+	}
+	if got := spanAttribute(spans[0], "http.response.status_code"); got != "404" {
+		t.Errorf("Expected status code 404, got %q", got)
+	}
+}
+
+func TestTracing_OTLPExporter(t *testing.T) {
+	var (
+		requests []otlpExportRequest
+		mu       sync.Mutex
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected export request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req otlpExportRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("Expected valid JSON, got %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(NewOTLPExporter(collector.URL + "/"))
+	server := NewServer()
+	server.EnableTracing(tracer)
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/v1/projects/test/topics/topic1", nil))
+	tracer.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 export request, got %d", len(requests))
+	}
+	resource := requests[0].ResourceSpans[0]
+	if got := *resource.Resource.Attributes[0].Value.StringValue; got != "pubsub-emulator" {
+		t.Errorf("Expected service name pubsub-emulator, got %s", got)
+	}
+	if spans := resource.ScopeSpans[0].Spans; len(spans) != 1 || spans[0].Name != "pubsub.CreateTopic" || spans[0].Kind != spanKindServer {
+		t.Errorf("Expected a CreateTopic server span, got %+v", spans)
+	}
+}
+
+func TestTracing_DeleteSubscriptionEndsSpans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tracer := NewTracer(exporter)
+
+	storage := NewStorage()
+	storage.Observe(tracer.observe)
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+	storage.Pull("projects/test/subscriptions/sub1", 1)
+
+	if err := storage.DeleteSubscription("projects/test/subscriptions/sub1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if tracer.open != 0 || len(tracer.messages) != 0 {
+		t.Errorf("Expected no open message spans, got %d", tracer.open)
+	}
+
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	spans := spansByName(readTraceFile(t, path))
+	messages := spans["projects/test/subscriptions/sub1 message"]
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message span, got %d", len(messages))
+	}
+	if got := spanAttribute(messages[0], "messaging.gcp_pubsub.outcome"); got != "deleted" {
+		t.Errorf("Expected outcome deleted, got %q", got)
+	}
+	if deliveries := spans["projects/test/subscriptions/sub1 deliver"]; len(deliveries) != 1 {
+		t.Errorf("Expected the open delivery span to end, got %d", len(deliveries))
+	}
+}
@@ -0,0 +1,42 @@
+package emulator
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// uiFiles holds the web UI, a single page that talks to the Pub/Sub and admin
+// APIs of this server
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// isUIPath reports whether a request targets the web UI
+func isUIPath(path string) bool {
+	return path == "/ui" || strings.HasPrefix(path, "/ui/")
+}
+
+// serveUI serves the embedded web UI under /ui/
+func (s *Server) serveUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == "/ui" {
+		// Left relative for the browser to resolve, so that the UI of a
+		// namespace stays under its /ns prefix (http.Redirect would resolve
+		// it against the path with the prefix already removed)
+		w.Header().Set("Location", "ui/")
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.StripPrefix("/ui", http.FileServerFS(files)).ServeHTTP(w, r)
+}
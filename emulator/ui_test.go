@@ -0,0 +1,48 @@
+package emulator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeUI(t *testing.T) {
+	server := NewServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/ui/", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("Expected HTML, got %s", contentType)
+	}
+	if !strings.Contains(w.Body.String(), "/admin/v1/projects") {
+		t.Error("Expected the UI page to be served")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/ui", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusMovedPermanently {
+		t.Errorf("Expected status %d, got %d", http.StatusMovedPermanently, w.Code)
+	}
+
+	// Relative, so that it keeps a namespace prefix
+	req = httptest.NewRequest(http.MethodGet, "/ns/a/ui", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if location := w.Header().Get("Location"); location != "ui/" {
+		t.Errorf("Expected a relative redirect, got %q", location)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/ui/", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
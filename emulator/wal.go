@@ -0,0 +1,527 @@
+package emulator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Journal operations
+const (
+	walOpCreateTopic        = "createTopic"
+	walOpDeleteTopic        = "deleteTopic"
+	walOpCreateSubscription = "createSubscription"
+	walOpDeleteSubscription = "deleteSubscription"
+	walOpPublish            = "publish"
+	walOpLease              = "lease"
+	walOpAcknowledge        = "acknowledge"
+	walOpReset              = "reset"
+)
+
+const (
+	snapshotFileName = "snapshot.json"
+	segmentPrefix    = "wal-"
+	segmentSuffix    = ".log"
+)
+
+// walRecord describes a single mutation of Storage. Records carry the
+// outcome of an operation (generated IDs, computed deadlines) rather than the
+// request, so replaying them is deterministic.
+type walRecord struct {
+	Seq         uint64              `json:"seq"`
+	Op          string              `json:"op"`
+	Name        string              `json:"name,omitempty"`  // topic, subscription or project
+	Topic       string              `json:"topic,omitempty"` // topic of a subscription or publish
+	PublishTime string              `json:"publishTime,omitempty"`
+	Messages    []walMessage        `json:"messages,omitempty"`
+	Deliveries  map[string][]string `json:"deliveries,omitempty"` // key: subscription name, value: ack IDs
+	Leases      []walLease          `json:"leases,omitempty"`
+	AckIDs      []string            `json:"ackIds,omitempty"`
+}
+
+// walMessage is a published message body
+type walMessage struct {
+	MessageID  string            `json:"messageId"`
+	Data       string            `json:"data"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// walLease is a new ack deadline for a delivery record
+type walLease struct {
+	AckID      string    `json:"ackId"`
+	DeadlineAt time.Time `json:"deadlineAt"`
+	Delivered  bool      `json:"delivered,omitempty"` // leased by a pull rather than a deadline change
+}
+
+// journal is an append-only write-ahead log split into segments, compacted
+// periodically into a snapshot.
+//
+// Each line of a segment is "<crc32 hex> <json record>". A torn or corrupt
+// line at the end of the last segment (from a crash mid-write) is truncated
+// on startup; records up to it are kept.
+type journal struct {
+	dir    string
+	file   *os.File
+	offset int64
+	seq    uint64
+	broken error // set when a failed write may have left garbage behind
+	mu     sync.Mutex
+
+	compactMu   sync.Mutex // serializes compactions
+	snapshotSeq uint64     // last record covered by the snapshot file
+}
+
+// OpenStorage creates a Storage persisted in dataDir, restoring any state
+// left there by a previous run. Compaction into a snapshot runs every
+// compactInterval; pass 0 to only compact on Close.
+func OpenStorage(dataDir string, compactInterval time.Duration) (*Storage, error) {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	s := NewStorage()
+	j := &journal{dir: dataDir}
+
+	snap, err := readSnapshot(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if snap != nil {
+		s.restore(snap)
+		j.seq = snap.Sequence
+		j.snapshotSeq = snap.Sequence
+	}
+
+	segments, err := listSegments(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if err := s.replaySegment(j, segment, last); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := j.openSegment(); err != nil {
+		return nil, err
+	}
+	s.journal = j
+
+	if compactInterval > 0 {
+		s.stopCompaction = make(chan struct{})
+		s.compactionDone = make(chan struct{})
+		go s.compactLoop(compactInterval)
+	}
+
+	return s, nil
+}
+
+// Close compacts the journal and releases its files. It is a no-op for
+// storage without a data directory.
+func (s *Storage) Close() error {
+	if s.journal == nil {
+		return nil
+	}
+	if s.stopCompaction != nil {
+		close(s.stopCompaction)
+		<-s.compactionDone
+	}
+	compactErr := s.Compact()
+
+	s.journal.mu.Lock()
+	defer s.journal.mu.Unlock()
+	return errors.Join(compactErr, s.journal.file.Close())
+}
+
+func (s *Storage) compactLoop(interval time.Duration) {
+	defer close(s.compactionDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Compact(); err != nil {
+				logger.Error("failed to compact journal",
+					"operation", "compact",
+					"error", err.Error())
+			}
+		case <-s.stopCompaction:
+			return
+		}
+	}
+}
+
+// Compact writes the current state to a snapshot and drops the journal
+// segments it covers
+func (s *Storage) Compact() error {
+	if s.journal == nil {
+		return nil
+	}
+	s.journal.compactMu.Lock()
+	defer s.journal.compactMu.Unlock()
+
+	// A damaged segment must stay the last one, where startup truncates it
+	if err := s.journal.err(); err != nil {
+		return fmt.Errorf("journal unusable after an earlier failure: %w", err)
+	}
+
+	if seq, _ := s.journal.position(); seq == s.journal.snapshotSeq {
+		return nil
+	}
+
+	// With the registry locked exclusively no mutation is in flight, so the
+	// snapshot matches the journal position exactly
+	s.mu.Lock()
+	snap := s.snapshot()
+	snap.Sequence, _ = s.journal.position()
+	err := s.journal.rotate()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := writeSnapshot(s.journal.dir, snap); err != nil {
+		return err
+	}
+	s.journal.snapshotSeq = snap.Sequence
+	return s.journal.dropCoveredSegments()
+}
+
+// replaceState swaps the whole state for the snapshot that next returns; next
+// is called with s.mu held exclusively. With persistence the new state is
+// written as the snapshot file rather than journaled, so restoring a large
+// fixture costs one snapshot write instead of a journal record of that size
+// plus a later compaction.
+func (s *Storage) replaceState(next func() *StateSnapshot) error {
+	if s.journal == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.restore(next())
+		return nil
+	}
+
+	s.journal.compactMu.Lock()
+	defer s.journal.compactMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.journal.err(); err != nil {
+		return fmt.Errorf("journal unusable after an earlier failure: %w", err)
+	}
+	snap := next()
+
+	// Records after the current position are replayed on top of the new
+	// state, those before it are superseded by it
+	seq, _ := s.journal.position()
+	if err := s.journal.rotate(); err != nil {
+		return err
+	}
+	doc := *snap
+	doc.Sequence = seq
+	if err := writeSnapshot(s.journal.dir, &doc); err != nil {
+		return err
+	}
+	s.journal.snapshotSeq = seq
+	s.restore(snap)
+
+	// Leftover segments are skipped on replay, so failing to remove them
+	// does not undo the restore
+	if err := s.journal.dropCoveredSegments(); err != nil {
+		logger.Warn("failed to remove journal segments",
+			"operation", "restore",
+			"error", err.Error())
+	}
+	return nil
+}
+
+// dropCoveredSegments removes the segments older than the current one, which
+// the snapshot file covers. The caller must hold compactMu.
+func (j *journal) dropCoveredSegments() error {
+	segments, err := listSegments(j.dir)
+	if err != nil {
+		return err
+	}
+	_, current := j.position()
+	for _, segment := range segments {
+		if segment != current {
+			if err := os.Remove(segment); err != nil {
+				return fmt.Errorf("failed to remove journal segment: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// append assigns the next sequence number to rec and durably writes it
+func (j *journal) append(rec *walRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.broken != nil {
+		return fmt.Errorf("journal unusable after an earlier failure: %w", j.broken)
+	}
+
+	rec.Seq = j.seq + 1
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.Write(line); err != nil {
+		// Drop the partial line so later records are not hidden behind it.
+		// The segment is opened with O_APPEND, so writes continue at the new
+		// end without seeking.
+		if truncErr := j.file.Truncate(j.offset); truncErr != nil {
+			j.broken = truncErr
+		}
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		// Whether the record reached the disk is unknown, so nothing written
+		// after it could be trusted either
+		j.broken = err
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+
+	j.offset += int64(len(line))
+	j.seq = rec.Seq
+	return nil
+}
+
+// err returns the failure that made the journal unusable, if any
+func (j *journal) err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.broken
+}
+
+// position returns the last written sequence number and the current segment
+func (j *journal) position() (uint64, string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq, j.file.Name()
+}
+
+// rotate starts a new segment after the last written record
+func (j *journal) rotate() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.file.Close(); err != nil {
+		return fmt.Errorf("failed to close journal segment: %w", err)
+	}
+	return j.openSegment()
+}
+
+// openSegment opens the segment starting after the last written record. The
+// caller must hold j.mu or have exclusive access.
+func (j *journal) openSegment() error {
+	name := filepath.Join(j.dir, fmt.Sprintf("%s%020d%s", segmentPrefix, j.seq+1, segmentSuffix))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal segment: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open journal segment: %w", err)
+	}
+	j.file = file
+	j.offset = info.Size()
+	return syncDir(j.dir)
+}
+
+// replaySegment applies the records of a segment not yet covered by the
+// snapshot. A damaged tail is truncated if this is the last segment.
+func (s *Storage) replaySegment(j *journal, path string, last bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read journal segment: %w", err)
+	}
+
+	var offset int64
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+
+		rec, decodeErr := decodeRecord(line)
+		if err != nil || decodeErr != nil {
+			if !last {
+				return fmt.Errorf("corrupt journal segment %s at offset %d", path, offset)
+			}
+			logger.Warn("truncating damaged journal tail",
+				"operation", "replay",
+				"segment", path,
+				"offset", offset)
+			if err := os.Truncate(path, offset); err != nil {
+				return fmt.Errorf("failed to truncate journal segment: %w", err)
+			}
+			return nil
+		}
+		offset += int64(len(line))
+
+		if rec.Seq <= j.seq {
+			continue
+		}
+		s.replay(rec)
+		j.seq = rec.Seq
+	}
+}
+
+// replay applies a journal record. It runs before the storage is shared, so
+// no locks are taken.
+func (s *Storage) replay(rec *walRecord) {
+	switch rec.Op {
+	case walOpCreateTopic:
+		s.applyCreateTopic(rec.Name)
+	case walOpDeleteTopic:
+		s.applyDeleteTopic(rec.Name)
+	case walOpCreateSubscription:
+		s.applyCreateSubscription(rec.Name, rec.Topic)
+	case walOpDeleteSubscription:
+		if _, exists := s.subscriptions[rec.Name]; exists {
+			s.applyDeleteSubscription(rec.Name)
+		}
+	case walOpPublish:
+		s.applyPublish(rec)
+	case walOpLease:
+		if state, exists := s.subscriptions[rec.Name]; exists {
+			applyLeases(state, rec.Leases)
+		}
+	case walOpAcknowledge:
+		if state, exists := s.subscriptions[rec.Name]; exists {
+			applyAcknowledge(state, rec.AckIDs, s.clock.Now())
+		}
+	case walOpReset:
+		s.applyReset(rec.Name)
+	}
+}
+
+func encodeRecord(rec *walRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode journal record: %w", err)
+	}
+	var sum [4]byte
+	crc := crc32.ChecksumIEEE(payload)
+	sum[0], sum[1], sum[2], sum[3] = byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc)
+
+	line := make([]byte, 0, len(payload)+10)
+	line = hex.AppendEncode(line, sum[:])
+	line = append(line, ' ')
+	line = append(line, payload...)
+	return append(line, '\n'), nil
+}
+
+func decodeRecord(line []byte) (*walRecord, error) {
+	checksum, payload, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !ok {
+		return nil, errors.New("malformed journal record")
+	}
+	sum, err := hex.DecodeString(string(checksum))
+	if err != nil || len(sum) != 4 {
+		return nil, errors.New("malformed journal checksum")
+	}
+	crc := uint32(sum[0])<<24 | uint32(sum[1])<<16 | uint32(sum[2])<<8 | uint32(sum[3])
+	if crc32.ChecksumIEEE(payload) != crc {
+		return nil, errors.New("journal checksum mismatch")
+	}
+
+	var rec walRecord
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// listSegments returns the journal segments in dir, oldest first
+func listSegments(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	var segments []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, segmentPrefix) && strings.HasSuffix(name, segmentSuffix) {
+			segments = append(segments, filepath.Join(dir, name))
+		}
+	}
+	// Sequence numbers are zero-padded, so lexical order is numeric order
+	sort.Strings(segments)
+	return segments, nil
+}
+
+func readSnapshot(dir string) (*StateSnapshot, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snap StateSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// writeSnapshot atomically replaces the snapshot file
+func writeSnapshot(dir string, snap *StateSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	tmp := filepath.Join(dir, snapshotFileName+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, snapshotFileName)); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes renames and newly created files in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,300 @@
+package emulator
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOpenStorage_RestoresAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Setup
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateTopic("projects/test/topics/deleted")
+	storage.DeleteTopic("projects/test/topics/deleted")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{
+		{Data: "dGVzdDE=", Attributes: map[string]string{"key": "value"}},
+		{Data: "dGVzdDI="},
+	})
+	pulled, _ := storage.Pull("projects/test/subscriptions/sub1", 10)
+	storage.Acknowledge("projects/test/subscriptions/sub1", []string{pulled[0].AckID})
+	storage.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{pulled[1].AckID}, 60)
+
+	// Reopen without Close, as after a crash
+	restored, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if topics := restored.ListTopics(); len(topics) != 1 {
+		t.Errorf("Expected 1 topic, got %d", len(topics))
+	}
+	if _, err := restored.GetSubscription("projects/test/subscriptions/sub1"); err != nil {
+		t.Fatalf("Expected subscription to be restored, got %v", err)
+	}
+
+	// The unacked message is still leased for 60 seconds
+	again, _ := restored.Pull("projects/test/subscriptions/sub1", 10)
+	if len(again) != 0 {
+		t.Errorf("Expected 0 messages (lease restored), got %d", len(again))
+	}
+
+	// Releasing the lease with the original ack ID redelivers it
+	if err := restored.ModifyAckDeadline("projects/test/subscriptions/sub1", []string{pulled[1].AckID}, 0); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	again, _ = restored.Pull("projects/test/subscriptions/sub1", 10)
+	if len(again) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(again))
+	}
+	if again[0].Message.Data != "dGVzdDI=" {
+		t.Errorf("Expected data 'dGVzdDI=', got %s", again[0].Message.Data)
+	}
+	if again[0].Message.MessageID != pulled[1].Message.MessageID {
+		t.Errorf("Expected message ID %s, got %s", pulled[1].Message.MessageID, again[0].Message.MessageID)
+	}
+}
+
+func TestOpenStorage_CompactionAndRestore(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Setup
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub2", "projects/test/topics/topic1")
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdDE="}})
+
+	if err := storage.Compact(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Records after the snapshot must be replayed on top of it
+	storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdDI="}})
+
+	segments, _ := listSegments(dir)
+	if len(segments) != 1 {
+		t.Errorf("Expected 1 journal segment after compaction, got %d", len(segments))
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	restored, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer restored.Close()
+
+	for _, sub := range []string{"projects/test/subscriptions/sub1", "projects/test/subscriptions/sub2"} {
+		pulled, _ := restored.Pull(sub, 10)
+		if len(pulled) != 2 {
+			t.Errorf("Expected 2 messages in %s, got %d", sub, len(pulled))
+		}
+	}
+
+	// Fan-out bodies are shared again after restore
+	body1 := restored.subscriptions["projects/test/subscriptions/sub1"].messages[0].body
+	body2 := restored.subscriptions["projects/test/subscriptions/sub2"].messages[0].body
+	if body1 != body2 {
+		t.Error("Expected restored subscriptions to share one message body")
+	}
+}
+
+func TestOpenStorage_TruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	storage.CreateTopic("projects/test/topics/topic1")
+
+	// Simulate a crash in the middle of writing a record
+	_, segment := storage.journal.position()
+	f, _ := os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString(`0badc0de {"seq":2,"op":"createTo`)
+	f.Close()
+
+	restored, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if topics := restored.ListTopics(); len(topics) != 1 {
+		t.Errorf("Expected 1 topic, got %d", len(topics))
+	}
+
+	// New records are written after the intact ones
+	restored.CreateTopic("projects/test/topics/topic2")
+	restored.Close()
+
+	restored, err = OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer restored.Close()
+	if topics := restored.ListTopics(); len(topics) != 2 {
+		t.Errorf("Expected 2 topics, got %d", len(topics))
+	}
+}
+
+func TestOpenStorage_PeriodicCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenStorage(dir, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer storage.Close()
+
+	storage.CreateTopic("projects/test/topics/topic1")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if snap, _ := readSnapshot(dir); snap != nil && len(snap.Topics) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected snapshot in %s to contain the topic", filepath.Join(dir, snapshotFileName))
+}
+
+func TestJournal_BrokenAfterFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	storage.CreateTopic("projects/test/topics/topic1")
+
+	// A write that fails and cannot be cleaned up after
+	_, segment := storage.journal.position()
+	storage.journal.file.Close()
+	if _, err := storage.CreateTopic("projects/test/topics/topic2"); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+
+	// Later records are refused even once the file works again
+	storage.journal.file, _ = os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0o644)
+	if _, err := storage.CreateTopic("projects/test/topics/topic3"); err == nil {
+		t.Error("Expected the broken journal to refuse records")
+	}
+	if topics := storage.ListTopics(); len(topics) != 1 {
+		t.Errorf("Expected only the journaled topic, got %d", len(topics))
+	}
+	if err := storage.Close(); err == nil {
+		t.Error("Expected Close to report the broken journal")
+	}
+
+	restored, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer restored.Close()
+	if topics := restored.ListTopics(); len(topics) != 1 {
+		t.Errorf("Expected 1 topic after a restart, got %d", len(topics))
+	}
+}
+
+func TestOpenStorage_KeepsConcurrentPublishOrder(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	storage.CreateTopic("projects/test/topics/topic1")
+	storage.CreateSubscription("projects/test/subscriptions/sub1", "projects/test/topics/topic1")
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 25 {
+				storage.Publish("projects/test/topics/topic1", []PubSubMessage{{Data: "dGVzdA=="}})
+			}
+		}()
+	}
+	wg.Wait()
+
+	order := func(s *Storage) []string {
+		peeked, _, err := s.PeekMessages("projects/test/subscriptions/sub1", PeekFilter{}, maxPeekPageSize, "")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		ids := make([]string, len(peeked))
+		for i, msg := range peeked {
+			ids[i] = msg.MessageID
+		}
+		return ids
+	}
+	live := order(storage)
+
+	// Reopen without Close, as after a crash, so the journal is replayed
+	restored, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer restored.Close()
+	if replayed := order(restored); !slices.Equal(live, replayed) {
+		t.Errorf("Expected the replayed backlog in the live order")
+	}
+}
+
+func TestOpenStorage_RestoreWritesSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	storage.CreateTopic("projects/test/topics/fixture")
+	storage.SaveCheckpoint("fixture")
+	storage.CreateTopic("projects/test/topics/scratch")
+
+	if err := storage.RestoreCheckpoint("fixture"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The restored state is the snapshot, not a journal record
+	segments, _ := listSegments(dir)
+	if len(segments) != 1 {
+		t.Fatalf("Expected only the current segment, got %v", segments)
+	}
+	if info, _ := os.Stat(segments[0]); info.Size() != 0 {
+		t.Errorf("Expected an empty journal after the restore, got %d bytes", info.Size())
+	}
+
+	// Later records are replayed on top of it
+	storage.CreateTopic("projects/test/topics/after")
+	restored, err := OpenStorage(dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer restored.Close()
+	var names []string
+	for _, topic := range restored.ListTopics() {
+		names = append(names, topic.Name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"projects/test/topics/after", "projects/test/topics/fixture"}) {
+		t.Errorf("Expected the fixture and the later topic, got %v", names)
+	}
+}
@@ -2,4 +2,10 @@ module github.com/kyontan/cloud-pubsub-emulator-lite
 
 go 1.25.1
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/google/uuid v1.6.0
+	go.etcd.io/bbolt v1.4.3
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.29.0 // indirect
@@ -1,30 +1,106 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kyontan/cloud-pubsub-emulator-lite/emulator"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	// Command-line flags
+	var opts emulator.Options
 	host := flag.String("h", "", "host to listen on (default: all interfaces)")
 	port := flag.String("p", "8085", "port to listen on")
+	flag.StringVar(&opts.DataDir, "data-dir", "", "directory to persist state in (default: in-memory only)")
+	flag.DurationVar(&opts.CompactInterval, "compact-interval", time.Minute, "how often to compact the journal into a snapshot (with -data-dir)")
+	flag.StringVar(&opts.Backend, "backend", "memory", "storage backend: memory or bolt (bolt requires -data-dir)")
+	flag.StringVar(&opts.ConfigPath, "config", "", "YAML or JSON file declaring topics and subscriptions to create at startup")
+	flag.BoolVar(&opts.WatchConfig, "watch-config", false, "re-apply the -config file whenever it changes")
+	flag.BoolVar(&opts.PruneConfig, "config-prune", false, "with -watch-config, delete resources removed from the config file")
+	flag.StringVar(&opts.ImportPath, "import", "", "state file (from -export or /admin/v1/state) to load at startup")
+	flag.StringVar(&opts.ImportMode, "import-mode", "merge", "how -import is applied: merge or replace")
+	flag.StringVar(&opts.ExportPath, "export", "", "file to write the complete state to on shutdown")
+	flag.DurationVar(&opts.SampleInterval, "sample-interval", 10*time.Second, "how often to sample backlogs for the Cloud Monitoring timeSeries API (0 disables)")
+	flag.BoolVar(&opts.VirtualClock, "virtual-clock", false, "only move time when /admin/v1/clock:advance or :set is called")
+	flag.Uint64Var(&opts.IDSeed, "id-seed", 0, "generate sequential message IDs starting at this number and derived ack IDs (0: random UUIDs)")
+	flag.StringVar(&opts.OTLPEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector to export traces to, e.g. http://localhost:4318")
+	flag.StringVar(&opts.TraceFile, "trace-file", "", "file to append traces to as OTLP JSON lines")
+	flag.StringVar(&opts.RecordPath, "record", "", "file to append every Pub/Sub API call and its response to as JSON lines")
 	flag.Parse()
+	opts.Addr = fmt.Sprintf("%s:%s", *host, *port)
 
-	server := NewServer()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", server.handleHealthCheck)
-	mux.Handle("/", server)
-
-	addr := fmt.Sprintf("%s:%s", *host, *port)
-	slog.Info("starting server", "addr", addr)
+	emu, err := emulator.Start(ctx, opts)
+	if err != nil {
+		slog.Error("failed to start emulator", "addr", opts.Addr, "error", err.Error())
+		os.Exit(1)
+	}
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		slog.Error("failed to start server", "error", err.Error())
+	<-ctx.Done()
+	if err := emu.Close(); err != nil {
+		slog.Error("failed to shut down cleanly", "error", err.Error())
 		os.Exit(1)
 	}
 }
+
+// runReplay implements the replay command, which re-issues a recording made
+// with -record against an emulator and reports the calls whose responses
+// differ. It returns the exit code.
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: pubsub-emulator replay [flags] recording.jsonl")
+		flags.PrintDefaults()
+	}
+	target := flags.String("target", "http://localhost:8085", "emulator to replay against")
+	speed := flags.Float64("speed", 1, "pacing relative to the recording: 1 is real time, 10 ten times as fast, 0 without pauses")
+	ignore := flags.String("ignore-fields", "publishTime", "comma-separated response fields to leave out of the comparison")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *speed < 0 {
+		flags.Usage()
+		return 2
+	}
+
+	calls, err := emulator.ReadRecording(flags.Arg(0))
+	if err != nil {
+		slog.Error("failed to read recording", "path", flags.Arg(0), "error", err.Error())
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := emulator.ReplayOptions{Speed: *speed}
+	if *ignore != "" {
+		opts.IgnoreFields = strings.Split(*ignore, ",")
+	}
+	divergences, err := emulator.Replay(ctx, calls, *target, opts)
+	for _, d := range divergences {
+		fmt.Println(d)
+	}
+	if err != nil {
+		slog.Error("replay failed", "target", *target, "error", err.Error())
+		return 1
+	}
+	fmt.Printf("replayed %d calls, %d diverged\n", len(calls), len(divergences))
+	if len(divergences) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kyontan/cloud-pubsub-emulator-lite/emulator"
+)
+
+// topPeekSize is how many messages of a subscription the console lists
+const topPeekSize = 500
+
+// runTop implements top, a live console for a running emulator. Everything
+// it shows comes from the admin API's introspection of the storage, so
+// browsing never pulls and never changes a lease.
+func runTop(args []string) int {
+	flags, project := newCommandFlags("top", "top [flags]")
+	interval := flags.Duration("interval", time.Second, "how often to refresh")
+	flags.Parse(args)
+	if flags.NArg() != 0 || *interval <= 0 {
+		flags.Usage()
+		return 2
+	}
+
+	restore, err := rawTerminal()
+	if err != nil {
+		return fail(fmt.Errorf("top needs an interactive terminal: %w", err))
+	}
+	defer restore()
+	// Alternate screen without a cursor, restored on exit
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m := newTopModel(newClient(*project))
+	m.width, m.height = terminalSize()
+	m.refresh(ctx)
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		fmt.Print("\x1b[H\x1b[2J" + m.render())
+		select {
+		case <-ticker.C:
+			m.width, m.height = terminalSize()
+			m.refresh(ctx)
+		case key, ok := <-keys:
+			if !ok || m.handleKey(ctx, key) {
+				return 0
+			}
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+// topModel is the state of the console: the overview of all topics and
+// subscriptions, or the messages of one subscription
+type topModel struct {
+	client *client
+
+	topics        []emulator.TopicOverview
+	subscriptions []emulator.SubscriptionOverview
+	counts        map[string]int64   // published or acked, by resource name
+	rates         map[string]float64 // per second since the previous refresh
+	refreshedAt   time.Time
+	selected      int // index into subscriptions
+
+	subscription string // the subscription drilled into, or ""
+	messages     []emulator.PeekedMessage
+	cursor       int // index into messages
+
+	prompt       *string // message being typed for publishing, or nil
+	confirmPurge bool
+	status       string // outcome of the last action
+
+	width, height int
+}
+
+func newTopModel(c *client) *topModel {
+	return &topModel{client: c, counts: make(map[string]int64), rates: make(map[string]float64), width: 80, height: 24}
+}
+
+// refresh reloads the overview and, in the subscription view, its messages
+func (m *topModel) refresh(ctx context.Context) {
+	var resp emulator.ListProjectsResponse
+	if err := m.client.do(ctx, http.MethodGet, "/admin/v1/projects", nil, &resp); err != nil {
+		m.status = err.Error()
+		return
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(m.refreshedAt).Seconds()
+	counts := make(map[string]int64)
+	m.topics, m.subscriptions = nil, nil
+	for _, p := range resp.Projects {
+		if m.client.project != "" && p.ID != m.client.project {
+			continue
+		}
+		for _, topic := range p.Topics {
+			m.topics = append(m.topics, topic)
+			counts[topic.Name] = topic.Published
+		}
+		for _, sub := range p.Subscriptions {
+			m.subscriptions = append(m.subscriptions, sub)
+			counts[sub.Name] = sub.Acked
+		}
+	}
+	for name, count := range counts {
+		previous, ok := m.counts[name]
+		if ok && !m.refreshedAt.IsZero() && count >= previous {
+			m.rates[name] = float64(count-previous) / elapsed
+		} else {
+			m.rates[name] = 0
+		}
+	}
+	m.counts, m.refreshedAt = counts, now
+	m.selected = clamp(m.selected, len(m.subscriptions))
+
+	if m.subscription == "" {
+		return
+	}
+	var peeked emulator.PeekMessagesResponse
+	path := "/admin/v1/" + m.subscription + "/messages?pageSize=" + strconv.Itoa(topPeekSize)
+	if err := m.client.do(ctx, http.MethodGet, path, nil, &peeked); err != nil {
+		m.status = err.Error()
+		m.messages = nil
+	} else {
+		m.messages = peeked.Messages
+	}
+	m.cursor = clamp(m.cursor, len(m.messages))
+}
+
+// clamp keeps an index within a list of n entries
+func clamp(i, n int) int {
+	return max(min(i, n-1), 0)
+}
+
+// handleKey applies a key press and reports whether to quit
+func (m *topModel) handleKey(ctx context.Context, key string) bool {
+	if m.prompt != nil {
+		switch key {
+		case "enter":
+			m.publish(ctx, *m.prompt)
+			m.prompt = nil
+		case "esc":
+			m.prompt = nil
+		case "backspace":
+			if text := *m.prompt; text != "" {
+				_, size := utf8.DecodeLastRuneInString(text)
+				*m.prompt = text[:len(text)-size]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				*m.prompt += key
+			}
+		}
+		return false
+	}
+	if m.confirmPurge {
+		m.confirmPurge = false
+		if key == "y" {
+			m.purge(ctx)
+		} else {
+			m.status = "purge cancelled"
+		}
+		return false
+	}
+
+	switch key {
+	case "q", "ctrl-c":
+		return true
+	case "up", "k":
+		if m.subscription == "" {
+			m.selected = clamp(m.selected-1, len(m.subscriptions))
+		} else {
+			m.cursor = clamp(m.cursor-1, len(m.messages))
+		}
+	case "down", "j":
+		if m.subscription == "" {
+			m.selected = clamp(m.selected+1, len(m.subscriptions))
+		} else {
+			m.cursor = clamp(m.cursor+1, len(m.messages))
+		}
+	case "enter":
+		if m.subscription == "" && len(m.subscriptions) > 0 {
+			m.subscription, m.cursor, m.status = m.subscriptions[m.selected].Name, 0, ""
+			m.refresh(ctx)
+		}
+	case "esc", "backspace":
+		m.subscription, m.messages, m.status = "", nil, ""
+	case "n":
+		if m.subscription != "" && len(m.messages) > 0 {
+			m.nack(ctx, m.messages[m.cursor])
+		}
+	case "p":
+		if m.subscription != "" {
+			m.prompt = new(string)
+		}
+	case "P":
+		if m.subscription != "" {
+			m.confirmPurge = true
+		}
+	}
+	return false
+}
+
+// topic returns the topic of the subscription drilled into
+func (m *topModel) topic() string {
+	for _, sub := range m.subscriptions {
+		if sub.Name == m.subscription {
+			return sub.Topic
+		}
+	}
+	return ""
+}
+
+func (m *topModel) publish(ctx context.Context, text string) {
+	req := emulator.PublishRequest{Messages: []emulator.PubSubMessage{{Data: emulator.EncodeData([]byte(text))}}}
+	var resp emulator.PublishResponse
+	if err := m.client.do(ctx, http.MethodPost, "/v1/"+m.topic()+":publish", req, &resp); err != nil {
+		m.status = err.Error()
+		return
+	}
+	m.status = "published message " + strings.Join(resp.MessageIDs, ", ")
+	m.refresh(ctx)
+}
+
+func (m *topModel) nack(ctx context.Context, msg emulator.PeekedMessage) {
+	req := emulator.ModifyAckDeadlineRequest{AckIDs: []string{msg.AckID}, AckDeadlineSeconds: 0}
+	if err := m.client.do(ctx, http.MethodPost, "/v1/"+m.subscription+":modifyAckDeadline", req, nil); err != nil {
+		m.status = err.Error()
+		return
+	}
+	m.status = "nacked message " + msg.MessageID
+	m.refresh(ctx)
+}
+
+func (m *topModel) purge(ctx context.Context) {
+	var resp emulator.PurgeResponse
+	if err := m.client.do(ctx, http.MethodPost, "/admin/v1/"+m.subscription+":purge", nil, &resp); err != nil {
+		m.status = err.Error()
+		return
+	}
+	m.status = fmt.Sprintf("purged %d messages", resp.Purged)
+	m.refresh(ctx)
+}
+
+// render draws the current view, with lines ending in \r\n for a terminal in
+// raw mode
+func (m *topModel) render() string {
+	var body bytes.Buffer
+	tw := tabwriter.NewWriter(&body, 0, 0, 2, ' ', 0)
+	var help string
+
+	if m.subscription == "" {
+		fmt.Fprintf(&body, "pubsub-emulator top  %s  %s\n\n", m.client.baseURL, m.refreshedAt.Format(time.TimeOnly))
+		fmt.Fprintln(tw, "  TOPIC\tPUB/S")
+		for _, topic := range m.topics {
+			fmt.Fprintf(tw, "  %s\t%.1f\n", topic.Name, m.rates[topic.Name])
+		}
+		tw.Flush()
+		fmt.Fprintln(&body)
+		fmt.Fprintln(tw, "  SUBSCRIPTION\tACK/S\tBACKLOG\tLEASED\tOLDEST")
+		for i, sub := range m.subscriptions {
+			marker := " "
+			if i == m.selected {
+				marker = ">"
+			}
+			fmt.Fprintf(tw, "%s %s\t%.1f\t%d\t%d\t%s\n", marker, sub.Name, m.rates[sub.Name], sub.Backlog, sub.Leased, age(sub.OldestUnackedAt))
+		}
+		help = "[up/down] select  [enter] open  [q] quit"
+	} else {
+		fmt.Fprintf(&body, "%s  (topic %s)  %d messages\n\n", m.subscription, m.topic(), len(m.messages))
+		fmt.Fprintln(tw, "  ID\tSTATE\tATTEMPTS\tATTRIBUTES\tDATA")
+		first := max(m.cursor-(m.height-8), 0)
+		for i := first; i < len(m.messages); i++ {
+			msg := m.messages[i]
+			marker := " "
+			if i == m.cursor {
+				marker = ">"
+			}
+			var attributes []string
+			for _, key := range slices.Sorted(maps.Keys(msg.Attributes)) {
+				attributes = append(attributes, key+"="+msg.Attributes[key])
+			}
+			fmt.Fprintf(tw, "%s %s\t%s\t%d\t%s\t%s\n", marker, msg.MessageID, msg.State, msg.DeliveryAttempts,
+				strings.Join(attributes, ","), strings.ReplaceAll(msg.DataPreview, "\n", " "))
+		}
+		help = "[up/down] select  [n] nack  [p] publish  [P] purge  [esc] back  [q] quit"
+	}
+	tw.Flush()
+
+	footer := help
+	switch {
+	case m.prompt != nil:
+		footer = "publish to " + m.topic() + ": " + *m.prompt + "_"
+	case m.confirmPurge:
+		footer = "purge every message of " + m.subscription + "? [y/N]"
+	case m.status != "":
+		footer = m.status + "    " + help
+	}
+
+	lines := strings.Split(strings.TrimSuffix(body.String(), "\n"), "\n")
+	lines = lines[:min(len(lines), max(m.height-2, 1))]
+	lines = append(lines, "", footer)
+	for i, line := range lines {
+		lines[i] = truncate(line, m.width)
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// age formats how long ago t was, or "-" for the zero time
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Round(time.Second).String()
+}
+
+// truncate cuts a line to width runes
+func truncate(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
+
+// rawTerminal switches the terminal to raw mode and returns a function that
+// restores it
+func rawTerminal() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() { stty(strings.TrimSpace(saved)) }, nil
+}
+
+// terminalSize returns the width and height of the terminal, or 80x24 if
+// they are unknown
+func terminalSize() (int, int) {
+	out, err := stty("size")
+	if err == nil {
+		var rows, cols int
+		if _, err := fmt.Sscan(out, &rows, &cols); err == nil && rows > 0 && cols > 0 {
+			return cols, rows
+		}
+	}
+	return 80, 24
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// readKeys sends the keys read from r until it fails
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseKeys splits raw terminal input into key names: printable characters
+// stand for themselves, special keys get names like "up" or "enter"
+func parseKeys(input []byte) []string {
+	var keys []string
+	for len(input) > 0 {
+		switch {
+		case len(input) >= 3 && bytes.HasPrefix(input, []byte("\x1b[")):
+			// Arrow keys; other escape sequences are ignored
+			switch input[2] {
+			case 'A':
+				keys = append(keys, "up")
+			case 'B':
+				keys = append(keys, "down")
+			}
+			input = input[3:]
+		case input[0] == 0x1b:
+			keys, input = append(keys, "esc"), input[1:]
+		case input[0] == '\r' || input[0] == '\n':
+			keys, input = append(keys, "enter"), input[1:]
+		case input[0] == 0x7f || input[0] == 0x08:
+			keys, input = append(keys, "backspace"), input[1:]
+		case input[0] == 0x03:
+			keys, input = append(keys, "ctrl-c"), input[1:]
+		default:
+			r, size := utf8.DecodeRune(input)
+			if r >= ' ' {
+				keys = append(keys, string(r))
+			}
+			input = input[size:]
+		}
+	}
+	return keys
+}
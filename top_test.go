@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kyontan/cloud-pubsub-emulator-lite/emulator"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys := parseKeys([]byte("q\x1b[A\x1b[B\x1b[C\r\x7f\x1b\x03é"))
+	expected := []string{"q", "up", "down", "enter", "backspace", "esc", "ctrl-c", "é"}
+	if !slices.Equal(keys, expected) {
+		t.Errorf("Expected %q, got %q", expected, keys)
+	}
+}
+
+func TestTop_Overview(t *testing.T) {
+	emu := startEmulator(t)
+	storage := emu.Storage()
+	storage.CreateTopic("projects/test/topics/orders")
+	storage.CreateTopic("projects/other/topics/audit")
+	storage.CreateSubscription("projects/test/subscriptions/billing", "projects/test/topics/orders")
+	storage.Publish("projects/test/topics/orders", []emulator.PubSubMessage{{Data: emulator.EncodeData([]byte("hello"))}})
+
+	ctx := context.Background()
+	m := newTopModel(newClient("test"))
+	m.refresh(ctx)
+	if len(m.topics) != 1 || len(m.subscriptions) != 1 {
+		t.Fatalf("Expected the resources of the project, got %v and %v", m.topics, m.subscriptions)
+	}
+	if m.subscriptions[0].Backlog != 1 {
+		t.Errorf("Expected a backlog of 1, got %d", m.subscriptions[0].Backlog)
+	}
+
+	storage.Publish("projects/test/topics/orders", []emulator.PubSubMessage{{Data: emulator.EncodeData([]byte("again"))}})
+	m.refresh(ctx)
+	if m.rates["projects/test/topics/orders"] <= 0 {
+		t.Errorf("Expected a publish rate, got %v", m.rates["projects/test/topics/orders"])
+	}
+
+	screen := m.render()
+	if !strings.Contains(screen, "projects/test/topics/orders") || !strings.Contains(screen, "> projects/test/subscriptions/billing") {
+		t.Errorf("Expected the topic and the selected subscription, got %q", screen)
+	}
+	if strings.Contains(screen, "projects/other") {
+		t.Errorf("Expected other projects to be left out, got %q", screen)
+	}
+	if !m.handleKey(ctx, "q") {
+		t.Error("Expected q to quit")
+	}
+}
+
+func TestTop_SubscriptionActions(t *testing.T) {
+	emu := startEmulator(t)
+	storage := emu.Storage()
+	storage.CreateTopic("projects/test/topics/orders")
+	storage.CreateSubscription("projects/test/subscriptions/billing", "projects/test/topics/orders")
+	storage.Publish("projects/test/topics/orders", []emulator.PubSubMessage{{Data: emulator.EncodeData([]byte("hello"))}})
+	storage.Pull("projects/test/subscriptions/billing", 1)
+
+	ctx := context.Background()
+	m := newTopModel(newClient("test"))
+	m.refresh(ctx)
+	m.handleKey(ctx, "enter")
+	if m.subscription != "projects/test/subscriptions/billing" || len(m.messages) != 1 {
+		t.Fatalf("Expected the messages of the subscription, got %q with %v", m.subscription, m.messages)
+	}
+	if m.messages[0].State != emulator.PeekStateLeased {
+		t.Fatalf("Expected the pulled message to be leased, got %s", m.messages[0].State)
+	}
+	if screen := m.render(); !strings.Contains(screen, "hello") {
+		t.Errorf("Expected the message data, got %q", screen)
+	}
+
+	// Nack the leased message
+	m.handleKey(ctx, "n")
+	if m.messages[0].State != emulator.PeekStateAvailable {
+		t.Errorf("Expected the nacked message to be available, got %s", m.messages[0].State)
+	}
+
+	// Publish a message typed at the prompt
+	for _, key := range []string{"p", "h", "i", "x", "backspace", "enter"} {
+		m.handleKey(ctx, key)
+	}
+	if len(m.messages) != 2 || m.messages[1].DataPreview != "hi" {
+		t.Fatalf("Expected the published message, got %v", m.messages)
+	}
+
+	// Purge, cancelled and then confirmed
+	m.handleKey(ctx, "P")
+	m.handleKey(ctx, "n")
+	if len(m.messages) != 2 {
+		t.Errorf("Expected a cancelled purge to keep the messages, got %v", m.messages)
+	}
+	m.handleKey(ctx, "P")
+	m.handleKey(ctx, "y")
+	if m.status != "purged 2 messages" {
+		t.Errorf("Expected 2 messages to be purged, got %q", m.status)
+	}
+	if stats, _ := storage.BacklogStats("projects/test/subscriptions/billing"); stats.Backlog != 0 {
+		t.Errorf("Expected an empty backlog, got %d", stats.Backlog)
+	}
+
+	m.handleKey(ctx, "esc")
+	if m.subscription != "" {
+		t.Errorf("Expected to be back at the overview, got %q", m.subscription)
+	}
+}